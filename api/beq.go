@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/ezbeq"
+	"github.com/iloveicedgreentea/go-plex/internal/state"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// manual requests get the same time as a playback event
+const beqRequestTimeout = 2 * time.Minute
+
+var (
+	beqClient   *ezbeq.BeqClient
+	beqClientMu sync.Mutex
+	// beqRequestMu serializes loads and unloads, they change the current profile and last match of the shared client
+	beqRequestMu sync.Mutex
+)
+
+// BeqLoadRequest loads an entry by hand. Devices and slots default to all of them
+type BeqLoadRequest struct {
+	EntryID string `json:"entryId" binding:"required"`
+	// MVAdjust is only used if the entry is not in the local catalog
+	MVAdjust float64  `json:"mvAdjust"`
+	Devices  []string `json:"devices"`
+	Slots    []int    `json:"slots"`
+}
+
+// BeqUnloadRequest unloads by hand. Devices and slots default to all of them
+type BeqUnloadRequest struct {
+	Devices []string `json:"devices"`
+	Slots   []int    `json:"slots"`
+}
+
+// BeqPinRequest pins an entry to a title. Without a TMDB ID, the title playing now is used
+type BeqPinRequest struct {
+	TMDB     string  `json:"tmdb"`
+	EntryID  string  `json:"entryId" binding:"required"`
+	MVAdjust float64 `json:"mvAdjust"`
+}
+
+// getBeqClient returns the client for manual requests, connecting on first use or if ezbeq was down before
+func getBeqClient() (*ezbeq.BeqClient, error) {
+	beqClientMu.Lock()
+	defer beqClientMu.Unlock()
+
+	if !config.GetBool("ezbeq.enabled") {
+		return nil, errors.New("ezbeq is not enabled")
+	}
+	if beqClient == nil {
+		// kept even if ezbeq is down, so it follows devices once instead of once per request
+		c, err := ezbeq.NewClient(config.GetString("ezbeq.url"), config.GetString("ezbeq.port"))
+		beqClient = c
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(beqClient.Devices()) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := beqClient.GetStatus(ctx); err != nil {
+			return nil, fmt.Errorf("error getting ezbeq devices: %w", err)
+		}
+	}
+	return beqClient, nil
+}
+
+// targets fills in every device and slot if none were given
+func targets(client *ezbeq.BeqClient, devices []string, slots []int) ([]string, []int) {
+	if len(devices) == 0 {
+		for _, d := range client.Devices() {
+			devices = append(devices, d.Name)
+		}
+	}
+	if len(slots) == 0 {
+		slots = ezbeq.AllSlots()
+	}
+	return devices, slots
+}
+
+func beqContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.Request.Context(), beqRequestTimeout)
+}
+
+// GetBeqProfiles returns the profile loaded into each device
+func GetBeqProfiles(c *gin.Context) {
+	c.JSON(200, gin.H{"profiles": state.GetStore().GetProfiles()})
+}
+
+// LoadBeqProfile loads a catalog entry into the chosen devices and slots without searching
+func LoadBeqProfile(c *gin.Context) {
+	var req BeqLoadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	client, err := getBeqClient()
+	if err != nil {
+		c.JSON(503, gin.H{"error": err.Error()})
+		return
+	}
+
+	m := &models.SearchRequest{
+		EntryID:    req.EntryID,
+		MVAdjust:   req.MVAdjust,
+		SkipSearch: true,
+		DryrunMode: config.GetBool("ezbeq.dryRun"),
+	}
+	if e, ok := client.Entry(req.EntryID); ok {
+		m.MVAdjust = e.MvAdjust
+		m.Year = e.Year
+	}
+	m.Devices, m.Slots = targets(client, req.Devices, req.Slots)
+
+	ctx, cancel := beqContext(c)
+	defer cancel()
+	beqRequestMu.Lock()
+	defer beqRequestMu.Unlock()
+	if err := client.LoadBeqProfile(ctx, m); err != nil {
+		log.Errorf("Error loading entry %s from the API: %v", req.EntryID, err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "profile loaded", "entryId": m.EntryID, "devices": m.Devices, "slots": m.Slots})
+}
+
+// UnloadBeqProfile unloads the chosen devices and slots
+func UnloadBeqProfile(c *gin.Context) {
+	var req BeqUnloadRequest
+	// an empty body unloads everything
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	client, err := getBeqClient()
+	if err != nil {
+		c.JSON(503, gin.H{"error": err.Error()})
+		return
+	}
+
+	m := &models.SearchRequest{DryrunMode: config.GetBool("ezbeq.dryRun")}
+	m.Devices, m.Slots = targets(client, req.Devices, req.Slots)
+
+	ctx, cancel := beqContext(c)
+	defer cancel()
+	beqRequestMu.Lock()
+	defer beqRequestMu.Unlock()
+	if err := client.UnloadBeqProfile(ctx, m); err != nil {
+		log.Errorf("Error unloading from the API: %v", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "profile unloaded", "devices": m.Devices, "slots": m.Slots})
+}
+
+// GetBeqPins returns every pinned entry by TMDB ID
+func GetBeqPins(c *gin.Context) {
+	c.JSON(200, gin.H{"pins": state.GetStore().GetPins()})
+}
+
+// PinBeqProfile pins an entry to a title so it is used the next time it plays
+func PinBeqProfile(c *gin.Context) {
+	var req BeqPinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TMDB == "" {
+		p, ok := state.GetStore().LastPlayer()
+		if !ok || p.TMDB == "" {
+			c.JSON(400, gin.H{"error": "nothing is playing, a tmdb ID is required"})
+			return
+		}
+		req.TMDB = p.TMDB
+	}
+	client, err := getBeqClient()
+	if err != nil {
+		c.JSON(503, gin.H{"error": err.Error()})
+		return
+	}
+
+	pin, err := client.Pin(req.TMDB, req.EntryID, req.MVAdjust)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "entry pinned", "pin": pin})
+}
+
+// DeleteBeqPin removes the pin for a TMDB ID
+func DeleteBeqPin(c *gin.Context) {
+	if err := state.GetStore().DeletePin(c.Param("tmdb")); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "pin removed"})
+}
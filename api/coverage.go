@@ -0,0 +1,42 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iloveicedgreentea/go-plex/internal/coverage"
+)
+
+// GetCoverage returns the last library coverage report, as CSV with ?format=csv
+func GetCoverage(c *gin.Context) {
+	r, ok := coverage.Last()
+	if !ok {
+		c.JSON(404, gin.H{"error": "no coverage report yet, start a scan first", "running": coverage.Running()})
+		return
+	}
+	if c.Query("format") == "csv" {
+		var buf bytes.Buffer
+		if err := r.WriteCSV(&buf); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="coverage.csv"`)
+		c.Data(200, "text/csv", buf.Bytes())
+		return
+	}
+	c.JSON(200, gin.H{"running": coverage.Running(), "report": r})
+}
+
+// StartCoverageScan scans the libraries in the background
+func StartCoverageScan(c *gin.Context) {
+	if err := coverage.Start(); err != nil {
+		if errors.Is(err, coverage.ErrRunning) {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(202, gin.H{"message": "coverage scan started"})
+}
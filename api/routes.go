@@ -9,4 +9,17 @@ func RegisterRoutes(router *gin.Engine) {
 	router.GET("/config", GetConfig)
 	router.POST("/config", SaveConfig)
 	router.GET("/logs", GetLogs)
+
+	// drive ezbeq by hand
+	beq := router.Group("/api/beq")
+	beq.GET("/profiles", GetBeqProfiles)
+	beq.POST("/load", LoadBeqProfile)
+	beq.POST("/unload", UnloadBeqProfile)
+	beq.GET("/pins", GetBeqPins)
+	beq.POST("/pin", PinBeqProfile)
+	beq.DELETE("/pin/:tmdb", DeleteBeqPin)
+
+	// which titles in the libraries have BEQ
+	router.GET("/api/coverage", GetCoverage)
+	router.POST("/api/coverage/scan", StartCoverageScan)
 }
\ No newline at end of file
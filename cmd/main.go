@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/gin-gonic/gin"
 	"github.com/iloveicedgreentea/go-plex/api"
+	"github.com/iloveicedgreentea/go-plex/internal/avr"
 	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/coverage"
 	"github.com/iloveicedgreentea/go-plex/internal/handlers"
 	"github.com/iloveicedgreentea/go-plex/internal/logger"
+	"github.com/iloveicedgreentea/go-plex/internal/mqtt"
 	"github.com/iloveicedgreentea/go-plex/models"
 )
 
@@ -22,7 +29,30 @@ func noCache() gin.HandlerFunc {
 	}
 }
 
+// writeCoverage scans the libraries and writes the report to path, as CSV if it ends in .csv
+func writeCoverage(path string) error {
+	r, err := coverage.Run(context.Background())
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if filepath.Ext(path) == ".csv" {
+		return r.WriteCSV(f)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
 func main() {
+	coverageOut := flag.String("coverage", "", "scan the libraries for BEQ coverage, write the report to this file (.csv or .json) and exit")
+	flag.Parse()
+
 	/*
 		###############################
 		Setups
@@ -49,6 +79,14 @@ func main() {
 		}
 	}
 
+	if *coverageOut != "" {
+		if err := writeCoverage(*coverageOut); err != nil {
+			log.Fatalf("Coverage scan failed: %v", err)
+		}
+		log.Infof("Wrote coverage report to %s", *coverageOut)
+		return
+	}
+
 	// you can copy this schema to create event handlers for any service
 	// create channel to receive jobs
 	var plexChan = make(chan models.PlexWebhookPayload, 5)
@@ -74,6 +112,7 @@ func main() {
 	   ############################## */
 	// healthcheck
 	r.GET("/health", handlers.ProcessHealthcheckWebhookGin)
+	r.GET("/health/status", handlers.ProcessHealthStatusGin)
 
 	// Add plex webhook handler
 	r.POST("/plexwebhook", func(c *gin.Context) {
@@ -85,6 +124,8 @@ func main() {
 	r.POST("/jellyfinwebhook", func(c *gin.Context) {
 		handlers.ProcessJfWebhook(jfChan, c)
 	})
+	// dry run a webhook and return what it would do
+	r.POST("/simulate/:source", handlers.ProcessSimulateWebhook)
 	r.Static("/assets", "./assets")
 	r.GET("/config-exists", api.ConfigExists)
 	r.GET("/get-config", api.GetConfig)
@@ -100,6 +141,9 @@ func main() {
 	<-minidspReady
 	<-jfReady
 	log.Info("All workers are ready.")
+	coverage.StartSchedule()
+	avr.StartDenonProxy()
+	mqtt.Start()
 
 	r.Static("/web", "./web")
 	r.NoRoute(func(c *gin.Context) {
@@ -0,0 +1,88 @@
+package avr
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	anthemPort    = "14999"
+	anthemTimeout = 5 * time.Second
+	// zone 1 is the main zone
+	anthemZone = "Z1"
+)
+
+// AnthemClient is a client for Anthem AVM and MRX processors over their IP protocol
+type AnthemClient struct {
+	noControl
+	ServerURL string
+	Port      string
+	Timeout   time.Duration
+}
+
+// makeReq sends a query like Z1AIN? and returns the value of the reply.
+// Commands and replies end with ; and the processor pushes status changes at any time, so other replies are skipped
+func (c *AnthemClient) makeReq(command string) (string, error) {
+	port := c.Port
+	if port == "" {
+		port = anthemPort
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = anthemTimeout
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.ServerURL, port), timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+
+	log.Debugf("Sending anthem command: %s", command)
+	if _, err := conn.Write([]byte(command + ";")); err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSuffix(command, "?")
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := r.ReadString(';')
+		if err != nil {
+			return "", err
+		}
+		msg = strings.TrimSpace(strings.TrimSuffix(msg, ";"))
+		log.Debugf("Got anthem message: %s", msg)
+		// !I is an invalid command and !E one that can't be run now, like in standby
+		if strings.HasPrefix(msg, "!") && strings.Contains(msg, name) {
+			return "", fmt.Errorf("anthem rejected %s: %s", command, msg)
+		}
+		if strings.HasPrefix(msg, name) {
+			return msg[len(name):], nil
+		}
+	}
+}
+
+// GetAudioInput returns the name of the incoming audio format, like "Dolby TrueHD 7.1"
+func (c *AnthemClient) GetAudioInput() (string, error) {
+	return c.makeReq(anthemZone + "AIN?")
+}
+
+// GetCodec returns the name of the incoming audio, like "Dolby TrueHD 7.1"
+func (c *AnthemClient) GetCodec() (string, error) {
+	name, err := c.GetAudioInput()
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("Got audio input from anthem: %s", name)
+	if name == "" || strings.EqualFold(name, "No Signal") {
+		return "", errors.New("anthem reported no audio signal")
+	}
+	return name, nil
+}
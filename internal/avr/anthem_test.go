@@ -0,0 +1,83 @@
+package avr
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeTCP runs a device which sends greeting, then calls handle with each message split on delim and writes its reply
+func newFakeTCP(t *testing.T, greeting string, delim byte, handle func(msg string) string) (string, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_, _ = conn.Write([]byte(greeting))
+				r := bufio.NewReader(conn)
+				for {
+					msg, err := r.ReadString(delim)
+					if err != nil {
+						return
+					}
+					_, _ = conn.Write([]byte(handle(strings.TrimSuffix(msg, string(delim)))))
+				}
+			}(conn)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	return host, port
+}
+
+func TestAnthemGetCodec(t *testing.T) {
+	input := "Dolby TrueHD 5.1"
+	host, port := newFakeTCP(t, "", ';', func(msg string) string {
+		// status changes are pushed before the reply
+		status := "Z1VOL-35.0;"
+		switch msg {
+		case "Z1AIN?":
+			if input == "" {
+				return status + "!EZ1AIN?;"
+			}
+			return status + "Z1AIN" + input + ";"
+		default:
+			return "!I" + msg + ";"
+		}
+	})
+	c := &AnthemClient{ServerURL: host, Port: port, Timeout: time.Second}
+
+	codec, err := c.GetCodec()
+	assert.NoError(t, err)
+	assert.Equal(t, "Dolby TrueHD 5.1", codec)
+
+	// standby
+	input = ""
+	_, err = c.GetCodec()
+	assert.Error(t, err)
+}
+
+func TestNewAnthemClient(t *testing.T) {
+	original := config.GetString("ezbeq.avrbrand")
+	defer config.Set("ezbeq.avrbrand", original)
+
+	config.Set("ezbeq.avrbrand", "anthem")
+	_, ok := GetAVRClient("192.168.1.40").(*AnthemClient)
+	assert.True(t, ok)
+
+	config.Set("ezbeq.avrbrand", "trinnov")
+	_, ok = GetAVRClient("192.168.1.40").(*TrinnovClient)
+	assert.True(t, ok)
+}
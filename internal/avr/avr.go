@@ -1,12 +1,21 @@
 package avr
 
 import (
-	"github.com/reiver/go-telnet"
 	"github.com/iloveicedgreentea/go-plex/internal/config"
 )
 // AVRClient is an interface for interacting with any AVR
 type AVRClient interface {
+    // GetCodec returns the codec as the AVR reports it, map it with a CodecMapper
     GetCodec() (string, error)
+    // GetPower returns true if the AVR is on
+    GetPower() (bool, error)
+    SetPower(on bool) error
+    GetInput() (string, error)
+    SetInput(input string) error
+    // GetVolume returns the master volume in dB
+    GetVolume() (float64, error)
+    SetVolume(db float64) error
+    SetSoundMode(mode string) error
 }
 
 // GetAVRClient returns a new instance of an AVRClient based on a brand like denon
@@ -16,10 +25,31 @@ func GetAVRClient(url string) AVRClient {
     switch config.GetString("ezbeq.avrbrand") {
     case "denon":
         log.Debug("Creating Denon AVR client")
-        return &DenonClient{ServerURL: url, Port: "23", TelClient: telnet.StandardCaller}
+        return &DenonClient{ServerURL: url, Port: "23"}
+    case "onkyo", "integra", "pioneer":
+        log.Debug("Creating eISCP AVR client")
+        return &OnkyoClient{ServerURL: url, Port: onkyoPort}
+    case "yamaha":
+        log.Debug("Creating Yamaha AVR client")
+        return NewYamahaClient(url, yamahaPort)
+    case "anthem":
+        log.Debug("Creating Anthem AVR client")
+        return &AnthemClient{ServerURL: url, Port: anthemPort}
+    case "trinnov":
+        log.Debug("Creating Trinnov AVR client")
+        return &TrinnovClient{ServerURL: url, Port: trinnovPort}
     // Add cases for other brands
     default:
 		log.Error("No AVR brand set in config")
         return nil
     }
-}
\ No newline at end of file
+}
+
+// GetConfiguredClient returns the client for the AVR in the config
+func GetConfiguredClient() AVRClient {
+    url := config.GetString("ezbeq.avrip")
+    if url == "" {
+        url = config.GetString("ezbeq.avrurl")
+    }
+    return GetAVRClient(url)
+}
@@ -0,0 +1,104 @@
+package avr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+)
+
+// ErrNotSupported is returned by AVRs which can only report the codec
+var ErrNotSupported = errors.New("not supported by this AVR")
+
+// noControl is embedded by clients which don't support control yet
+type noControl struct{}
+
+func (noControl) GetPower() (bool, error)        { return false, ErrNotSupported }
+func (noControl) SetPower(on bool) error         { return ErrNotSupported }
+func (noControl) GetInput() (string, error)      { return "", ErrNotSupported }
+func (noControl) SetInput(input string) error    { return ErrNotSupported }
+func (noControl) GetVolume() (float64, error)    { return 0, ErrNotSupported }
+func (noControl) SetVolume(db float64) error     { return ErrNotSupported }
+func (noControl) SetSoundMode(mode string) error { return ErrNotSupported }
+
+// MediaSettings is how to set up the AVR for a media type. Empty fields are left alone
+type MediaSettings struct {
+	// PowerOn turns the AVR on if it is in standby
+	PowerOn   bool   `mapstructure:"powerOn" json:"powerOn"`
+	Input     string `mapstructure:"input" json:"input"`
+	SoundMode string `mapstructure:"soundMode" json:"soundMode"`
+	// Volume is the master volume in dB
+	Volume *float64 `mapstructure:"volume" json:"volume"`
+}
+
+// GetMediaSettings returns the settings for a media type like movie or episode from ezbeq.avrMediaSettings
+func GetMediaSettings(mediaType string) (MediaSettings, bool) {
+	var settings map[string]MediaSettings
+	if err := config.UnmarshalKey("ezbeq.avrMediaSettings", &settings); err != nil {
+		log.Errorf("Error reading AVR media settings, ignoring them: %v", err)
+		return MediaSettings{}, false
+	}
+	mediaType = strings.ToLower(mediaType)
+	s, ok := settings[mediaType]
+	// shows and episodes are the same thing
+	if !ok && mediaType == "episode" {
+		s, ok = settings["show"]
+	}
+	return s, ok
+}
+
+// String describes the settings, like "input BD, volume -18.0dB"
+func (s MediaSettings) String() string {
+	var parts []string
+	if s.PowerOn {
+		parts = append(parts, "power on")
+	}
+	if s.Input != "" {
+		parts = append(parts, "input "+s.Input)
+	}
+	if s.SoundMode != "" {
+		parts = append(parts, "sound mode "+s.SoundMode)
+	}
+	if s.Volume != nil {
+		parts = append(parts, fmt.Sprintf("volume %.1fdB", *s.Volume))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ApplyMediaSettings sets up the AVR. The input is set before the sound mode since modes depend on the signal
+func ApplyMediaSettings(c AVRClient, s MediaSettings) error {
+	if s.PowerOn {
+		on, err := c.GetPower()
+		if err != nil {
+			return fmt.Errorf("could not get power: %w", err)
+		}
+		if !on {
+			log.Info("Turning on AVR")
+			if err := c.SetPower(true); err != nil {
+				return fmt.Errorf("could not turn on: %w", err)
+			}
+		}
+	}
+
+	var errs []error
+	if s.Input != "" {
+		log.Infof("Setting AVR input to %s", s.Input)
+		if err := c.SetInput(s.Input); err != nil {
+			errs = append(errs, fmt.Errorf("could not set input: %w", err))
+		}
+	}
+	if s.SoundMode != "" {
+		log.Infof("Setting AVR sound mode to %s", s.SoundMode)
+		if err := c.SetSoundMode(s.SoundMode); err != nil {
+			errs = append(errs, fmt.Errorf("could not set sound mode: %w", err))
+		}
+	}
+	if s.Volume != nil {
+		log.Infof("Setting AVR volume to %.1fdB", *s.Volume)
+		if err := c.SetVolume(*s.Volume); err != nil {
+			errs = append(errs, fmt.Errorf("could not set volume: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,99 @@
+package avr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatDenonVolume(t *testing.T) {
+	tests := map[float64]string{
+		-30:   "50",
+		-29.5: "505",
+		-18:   "62",
+		-79.5: "005",
+		-80:   "00",
+		0:     "80",
+		-25.2: "55",
+	}
+	for db, expected := range tests {
+		got, err := formatDenonVolume(db)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, got, db)
+	}
+	_, err := formatDenonVolume(20)
+	assert.Error(t, err)
+}
+
+func TestDenonControl(t *testing.T) {
+	assert := assert.New(t)
+	f := newFakeDenon(t, map[string]string{
+		"PW?": "PWSTANDBY",
+		"SI?": "SITV",
+		"MS?": "MSSTEREO",
+		"MV?": "MV40",
+		"SD?": "SDAUTO",
+	})
+	c := startDenonSession(t, f)
+	assert.Eventually(func() bool {
+		state, ok := c.GetState()
+		return ok && state.InputMode == "AUTO"
+	}, time.Second, 10*time.Millisecond)
+
+	on, err := c.GetPower()
+	assert.NoError(err)
+	assert.False(on)
+
+	volume := -18.0
+	err = ApplyMediaSettings(c, MediaSettings{PowerOn: true, Input: "bd", SoundMode: "Movie", Volume: &volume})
+	assert.NoError(err)
+
+	// the receiver echoes every change
+	assert.Eventually(func() bool {
+		state, _ := c.GetState()
+		return state.Volume == -18
+	}, time.Second, 10*time.Millisecond)
+	state, _ := c.GetState()
+	assert.Equal("ON", state.Power)
+	assert.Equal("BD", state.Input)
+	assert.Equal("MOVIE", state.SoundMode)
+
+	vol, err := c.GetVolume()
+	assert.NoError(err)
+	assert.Equal(-18.0, vol)
+	input, err := c.GetInput()
+	assert.NoError(err)
+	assert.Equal("BD", input)
+}
+
+func TestApplyMediaSettingsNotSupported(t *testing.T) {
+	volume := -20.0
+	err := ApplyMediaSettings(&OnkyoClient{}, MediaSettings{Input: "BD", Volume: &volume})
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestGetMediaSettings(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.avrMediaSettings", map[string]interface{}{
+		"movie": map[string]interface{}{"input": "BD", "volume": -18, "powerOn": true},
+		"show":  map[string]interface{}{"volume": -25.5},
+	})
+	t.Cleanup(func() { config.Set("ezbeq.avrMediaSettings", nil) })
+
+	s, ok := GetMediaSettings("Movie")
+	assert.True(ok)
+	assert.True(s.PowerOn)
+	assert.Equal("BD", s.Input)
+	assert.Equal(-18.0, *s.Volume)
+	assert.Equal("power on, input BD, volume -18.0dB", s.String())
+
+	s, ok = GetMediaSettings("episode")
+	assert.True(ok)
+	assert.Equal(-25.5, *s.Volume)
+	assert.Empty(s.Input)
+
+	_, ok = GetMediaSettings("track")
+	assert.False(ok)
+}
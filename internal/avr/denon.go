@@ -1,79 +1,137 @@
 package avr
 
 import (
-	"bytes"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
 	"github.com/iloveicedgreentea/go-plex/internal/logger"
-	"github.com/reiver/go-telnet"
 )
 
 var log = logger.GetLogger()
 
+// how long to wait for a reply from the receiver
+const denonTimeout = 5 * time.Second
 
-// DenonClient is a client for Denon AVRs
+// DenonClient is a client for Denon AVRs. All clients for a receiver share one session since it only allows one telnet connection
 type DenonClient struct {
 	ServerURL string
 	Port      string
-	TelClient telnet.Caller
 }
 
+// session returns the running session for the receiver
+func (c *DenonClient) session() *denonSession {
+	return getDenonSession(c.ServerURL + ":" + c.Port)
+}
 
-// make a request to denon via telnet
+// make a request to denon and return the reply, like PWSTANDBY for PW?
 func (c *DenonClient) makeReq(command string) (string, error) {
-	conn, err := telnet.DialTo(fmt.Sprintf("%s:%s", c.ServerURL, c.Port))
+	log.Debugf("Sending command: %s", command)
+	res, err := c.session().query(command, denonTimeout)
 	if err != nil {
 		return "", err
 	}
-	cmd := fmt.Sprintf("%s\r", command)
-	log.Debugf("Sending command: %s", cmd)
+	log.Debugf("Got result: %s", res)
+
+	return res, nil
+}
+
+// GetState returns the live state of the receiver
+func (c *DenonClient) GetState() (DenonState, bool) {
+	return c.session().State()
+}
 
-	// send cmd
-	_, err = conn.Write([]byte(cmd))
+// GetAudioMode returns the current audio mode like dolby atmos, stereo, etc
+func (c *DenonClient) GetCodec() (string, error) {
+	// the receiver sends every change so the state is current while connected
+	if state, ok := c.GetState(); ok && state.SoundMode != "" {
+		return strings.ToLower(state.SoundMode), nil
+	}
+	mode, err := c.makeReq("MS?")
 	if err != nil {
 		return "", err
 	}
-	time.Sleep(500 * time.Millisecond)
-	// receive
-	var buffer [1]byte
-	p := buffer[:]
-	// final result
-	var result []byte
-	log.Debug("Receiving data")
-	// Read until carriage return
-	for {
-		// this function is weird, it will just read the length of the byte[] given, but block
-		// so you need to give it 1 length array and read 1 byte at a time
-		n, err := conn.Read(p) // will block if nothing else to send
-		if n > 0 {
-			data := p[:n]
-			// store val in final result
-			result = append(result, data[0])
-			// read response one at a time
-			// if char is 13 (CR) then break
-			if bytes.Equal(data, []byte{13}) {
-				break
-			}
-		}
-
-		if err != nil {
-			break
-		}
+	return strings.ToLower(strings.TrimPrefix(mode, "MS")), nil
+}
+
+// GetPower returns true if the receiver is on
+func (c *DenonClient) GetPower() (bool, error) {
+	if state, ok := c.GetState(); ok && state.Power != "" {
+		return state.Power == "ON", nil
 	}
+	res, err := c.makeReq("PW?")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimPrefix(res, "PW") == "ON", nil
+}
 
+// SetPower turns the receiver on or to standby
+func (c *DenonClient) SetPower(on bool) error {
+	cmd := "PWSTANDBY"
+	if on {
+		cmd = "PWON"
+	}
+	_, err := c.makeReq(cmd)
+	return err
+}
+
+// GetInput returns the source, like BD or MPLAY
+func (c *DenonClient) GetInput() (string, error) {
+	if state, ok := c.GetState(); ok && state.Input != "" {
+		return state.Input, nil
+	}
+	res, err := c.makeReq("SI?")
 	if err != nil {
 		return "", err
 	}
+	return strings.TrimPrefix(res, "SI"), nil
+}
 
-	log.Debugf("Got result: %s", string(result))
+// SetInput selects a source by its denon name, like BD, MPLAY or SAT/CBL
+func (c *DenonClient) SetInput(input string) error {
+	_, err := c.makeReq("SI" + strings.ToUpper(input))
+	return err
+}
 
-	return string(result), nil
+// GetVolume returns the master volume in dB
+func (c *DenonClient) GetVolume() (float64, error) {
+	res, err := c.makeReq("MV?")
+	if err != nil {
+		return 0, err
+	}
+	vol, ok := parseDenonVolume(strings.TrimPrefix(res, "MV"))
+	if !ok {
+		return 0, fmt.Errorf("unexpected volume %s", res)
+	}
+	return vol, nil
 }
 
-// GetAudioMode returns the current audio mode like dolby atmos, stereo, etc
-func (c *DenonClient) GetCodec() (string, error) {
-	mode, err := c.makeReq("MS?")
-	return strings.ToLower(mode[2:]), err
-}
\ No newline at end of file
+// SetVolume sets the master volume in dB, rounded to half a dB
+func (c *DenonClient) SetVolume(db float64) error {
+	vol, err := formatDenonVolume(db)
+	if err != nil {
+		return err
+	}
+	_, err = c.makeReq("MV" + vol)
+	return err
+}
+
+// SetSoundMode selects a surround mode by its denon name, like MOVIE, DIRECT or DOLBY DIGITAL
+func (c *DenonClient) SetSoundMode(mode string) error {
+	_, err := c.makeReq("MS" + strings.ToUpper(mode))
+	return err
+}
+
+// formatDenonVolume turns dB into the denon volume, like 50 for -30dB or 505 for -29.5dB
+func formatDenonVolume(db float64) (string, error) {
+	if db < -80 || db > 18 {
+		return "", fmt.Errorf("volume %.1fdB is outside -80 to 18", db)
+	}
+	vol := math.Round((db+80)*2) / 2
+	if vol == math.Trunc(vol) {
+		return fmt.Sprintf("%02d", int(vol)), nil
+	}
+	return fmt.Sprintf("%03d", int(vol*10)), nil
+}
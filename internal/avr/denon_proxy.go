@@ -0,0 +1,113 @@
+package avr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+)
+
+var denonProxyOnce sync.Once
+
+// StartDenonProxy serves a telnet port so other clients like Home Assistant can share the one connection the receiver allows.
+// It does nothing unless ezbeq.denonProxyPort is set and the AVR is a denon
+func StartDenonProxy() {
+	port := config.GetInt("ezbeq.denonProxyPort")
+	if port <= 0 {
+		return
+	}
+	c, ok := GetConfiguredClient().(*DenonClient)
+	if !ok {
+		log.Warn("Denon proxy port is set but the AVR brand is not denon, not starting the proxy")
+		return
+	}
+	denonProxyOnce.Do(func() {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			log.Errorf("Error starting denon proxy: %v", err)
+			return
+		}
+		log.Infof("Denon proxy listening on port %d", port)
+		go serveDenonProxy(context.Background(), ln, c.session())
+	})
+}
+
+// serveDenonProxy accepts clients until ctx is done
+func serveDenonProxy(ctx context.Context, ln net.Listener, s *denonSession) {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Errorf("Denon proxy stopped: %v", err)
+			}
+			return
+		}
+		go proxyDenonClient(ctx, conn, s)
+	}
+}
+
+// proxyDenonClient sends commands from a client to the receiver and every line from the receiver to the client, like a direct connection
+func proxyDenonClient(ctx context.Context, conn net.Conn, s *denonSession) {
+	log.Infof("Denon proxy client %s connected", conn.RemoteAddr())
+	ctx, cancel := context.WithCancel(ctx)
+	lines, unsubscribe := s.subscribe()
+	// unblock the read when the client goes away or we are asked to stop
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		stop()
+		unsubscribe()
+		cancel()
+		conn.Close()
+		log.Infof("Denon proxy client %s disconnected", conn.RemoteAddr())
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case line := <-lines:
+				if err := conn.SetWriteDeadline(time.Now().Add(denonTimeout)); err != nil {
+					cancel()
+					return
+				}
+				if _, err := conn.Write([]byte(line + "\r")); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	sc := bufio.NewScanner(conn)
+	sc.Split(scanDenonCommands)
+	for sc.Scan() {
+		cmd := strings.TrimSpace(sc.Text())
+		if cmd == "" {
+			continue
+		}
+		log.Debugf("Denon proxy forwarding %s from %s", cmd, conn.RemoteAddr())
+		if err := s.send(cmd, denonTimeout); err != nil {
+			log.Warnf("Denon proxy could not forward %s: %v", cmd, err)
+		}
+	}
+}
+
+// scanDenonCommands splits on CR like the receiver, or LF for clients that send that
+func scanDenonCommands(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,122 @@
+package avr
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// proxyClient is a client like Home Assistant connected to the proxy
+type proxyClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialProxy(t *testing.T, ln net.Listener) *proxyClient {
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &proxyClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (p *proxyClient) send(cmd string) {
+	if _, err := p.conn.Write([]byte(cmd)); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// expect reads lines until want, failing if it doesn't come
+func (p *proxyClient) expect(want string) {
+	_ = p.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		line, err := p.r.ReadString('\r')
+		if err != nil {
+			p.t.Fatalf("expected %s: %v", want, err)
+		}
+		if strings.TrimSuffix(line, "\r") == want {
+			return
+		}
+	}
+}
+
+func TestDenonProxy(t *testing.T) {
+	assert := assert.New(t)
+	f := newFakeDenon(t, map[string]string{
+		"PW?": "PWON",
+		"SI?": "SIBD",
+		"MS?": "MSDOLBY ATMOS",
+		"MV?": "MV50",
+		"SD?": "SDHDMI",
+	})
+	c := startDenonSession(t, f)
+	assert.Eventually(func() bool {
+		state, ok := c.GetState()
+		return ok && state.InputMode == "HDMI"
+	}, time.Second, 10*time.Millisecond)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := c.session()
+	go serveDenonProxy(ctx, ln, s)
+
+	ha := dialProxy(t, ln)
+	script := dialProxy(t, ln)
+	assert.Eventually(func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.subs) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// replies and events go to every client
+	ha.send("PW?\r")
+	ha.expect("PWON")
+	script.expect("PWON")
+	f.push("MSSTEREO")
+	ha.expect("MSSTEREO")
+	script.expect("MSSTEREO")
+
+	// changes from clients are seen by the session too, LF works as well as CR
+	script.send("MV45\n")
+	ha.expect("MV45")
+	assert.Eventually(func() bool {
+		state, _ := c.GetState()
+		return state.Volume == -35
+	}, time.Second, 10*time.Millisecond)
+
+	// our own queries still get their reply
+	res, err := c.makeReq("SI?")
+	assert.NoError(err)
+	assert.Equal("SIBD", res)
+
+	// clients that leave stop getting lines
+	script.conn.Close()
+	assert.Eventually(func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.subs) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestScanDenonCommands(t *testing.T) {
+	sc := bufio.NewScanner(strings.NewReader("PW?\rMV50\nSIBD\r\nMS?"))
+	sc.Split(scanDenonCommands)
+	var cmds []string
+	for sc.Scan() {
+		if sc.Text() != "" {
+			cmds = append(cmds, sc.Text())
+		}
+	}
+	assert.Equal(t, []string{"PW?", "MV50", "SIBD", "MS?"}, cmds)
+}
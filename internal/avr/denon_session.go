@@ -0,0 +1,371 @@
+package avr
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/mqtt"
+)
+
+// queries sent on connect so the state is complete, the rest comes in as events
+var denonResyncQueries = []string{"PW?", "SI?", "MS?", "MV?", "SD?"}
+
+const (
+	denonRetryBaseDelay = time.Second
+	denonRetryMaxDelay  = 30 * time.Second
+)
+
+// the receiver drops commands sent closer together than this
+var denonCommandInterval = 50 * time.Millisecond
+
+// how many lines a subscriber can fall behind before lines are dropped for it
+const denonSubscriberBuffer = 64
+
+// DenonState is the live state of a receiver, built from the events it sends
+type DenonState struct {
+	// Power is ON or STANDBY
+	Power string `json:"power"`
+	// Input is the source, like BD or MPLAY
+	Input string `json:"input"`
+	// SoundMode is the surround mode, like DOLBY ATMOS
+	SoundMode string `json:"soundMode"`
+	// Volume is the master volume in dB
+	Volume float64 `json:"volume"`
+	// InputMode is the audio input mode, like AUTO or HDMI
+	InputMode string    `json:"inputMode"`
+	Updated   time.Time `json:"updated"`
+}
+
+// denonWaiter is a query waiting for a reply starting with prefix
+type denonWaiter struct {
+	prefix string
+	ch     chan string
+}
+
+// denonSession is a long lived connection to a receiver that reconnects when it drops
+type denonSession struct {
+	addr string
+	// retryBase and retryMax bound the reconnect backoff
+	retryBase time.Duration
+	retryMax  time.Duration
+	mu        sync.Mutex
+	conn      net.Conn
+	connected chan struct{}
+	state     DenonState
+	waiters   []*denonWaiter
+	// subs get every line the receiver sends
+	subs map[chan string]struct{}
+	once sync.Once
+	// writeMu serializes commands from every client of the session
+	writeMu   sync.Mutex
+	lastWrite time.Time
+}
+
+var (
+	denonSessions   = make(map[string]*denonSession)
+	denonSessionsMu sync.Mutex
+)
+
+// getDenonSession returns the session for a receiver, starting it on first use
+func getDenonSession(addr string) *denonSession {
+	denonSessionsMu.Lock()
+	s, ok := denonSessions[addr]
+	if !ok {
+		s = newDenonSession(addr)
+		denonSessions[addr] = s
+	}
+	denonSessionsMu.Unlock()
+
+	s.once.Do(func() {
+		go s.run(context.Background())
+	})
+	return s
+}
+
+func newDenonSession(addr string) *denonSession {
+	return &denonSession{
+		addr:      addr,
+		retryBase: denonRetryBaseDelay,
+		retryMax:  denonRetryMaxDelay,
+		connected: make(chan struct{}),
+		subs:      make(map[chan string]struct{}),
+	}
+}
+
+// State returns the state of the receiver, false if not connected since it may be stale
+func (s *denonSession) State() (DenonState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, s.conn != nil
+}
+
+// run keeps the session connected until ctx is done, reconnecting with backoff
+func (s *denonSession) run(ctx context.Context) {
+	attempt := 0
+	for {
+		connected, err := s.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			attempt = 0
+		}
+		attempt++
+		delay := s.backoff(attempt)
+		log.Warnf("denon connection to %s dropped, reconnecting in %v: %v", s.addr, delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff returns an exponential delay with jitter
+func (s *denonSession) backoff(attempt int) time.Duration {
+	limit := s.retryBase << (attempt - 1)
+	if limit <= 0 || limit > s.retryMax {
+		limit = s.retryMax
+	}
+	return limit/2 + time.Duration(rand.Int63n(int64(limit/2)+1))
+}
+
+// listen reads events until the connection fails. It reports if it managed to connect
+func (s *denonSession) listen(ctx context.Context) (bool, error) {
+	d := net.Dialer{Timeout: denonTimeout}
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return false, err
+	}
+	// unblock the read when we are asked to stop
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	log.Infof("Connected to denon at %s", s.addr)
+
+	s.mu.Lock()
+	s.conn = conn
+	close(s.connected)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.connected = make(chan struct{})
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	// events may have been missed while disconnected
+	for _, q := range denonResyncQueries {
+		if err := s.write(q); err != nil {
+			return true, err
+		}
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\r')
+		if err != nil {
+			return true, err
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			s.handle(line)
+		}
+	}
+}
+
+// write sends a command, ending it with CR. Commands are sent one at a time and spaced out
+func (s *denonSession) write(command string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if wait := denonCommandInterval - time.Since(s.lastWrite); wait > 0 {
+		time.Sleep(wait)
+	}
+	defer func() { s.lastWrite = time.Now() }()
+
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		return errors.New("not connected to denon")
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(denonTimeout)); err != nil {
+		return err
+	}
+	_, err := conn.Write([]byte(command + "\r"))
+	return err
+}
+
+// query sends a command and waits for the reply, which starts with the first two letters of the command
+func (s *denonSession) query(command string, timeout time.Duration) (string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	if err := s.waitConnected(timer.C); err != nil {
+		return "", err
+	}
+
+	w := &denonWaiter{prefix: command[:2], ch: make(chan string, 1)}
+	s.mu.Lock()
+	s.waiters = append(s.waiters, w)
+	s.mu.Unlock()
+	defer s.removeWaiter(w)
+
+	if err := s.write(command); err != nil {
+		return "", err
+	}
+	select {
+	case res := <-w.ch:
+		return res, nil
+	case <-timer.C:
+		return "", errors.New("timeout waiting for denon reply to " + command)
+	}
+}
+
+// send writes a command once connected, without waiting for a reply
+func (s *denonSession) send(command string, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	if err := s.waitConnected(timer.C); err != nil {
+		return err
+	}
+	return s.write(command)
+}
+
+// waitConnected waits for the session to connect, it may still be connecting
+func (s *denonSession) waitConnected(timeout <-chan time.Time) error {
+	s.mu.Lock()
+	connected := s.connected
+	s.mu.Unlock()
+	select {
+	case <-connected:
+		return nil
+	case <-timeout:
+		return errors.New("timeout connecting to denon")
+	}
+}
+
+// subscribe returns a channel with every line the receiver sends until cancel is called
+func (s *denonSession) subscribe() (<-chan string, func()) {
+	ch := make(chan string, denonSubscriberBuffer)
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subs, ch)
+		s.mu.Unlock()
+	}
+}
+
+func (s *denonSession) removeWaiter(w *denonWaiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.waiters {
+		if v == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// handle applies an event to the state, answers any query waiting for it, passes it to subscribers and publishes changes
+func (s *denonSession) handle(line string) {
+	s.mu.Lock()
+	prev := s.state
+	s.state = applyDenonEvent(s.state, line)
+	cur := s.state
+	for _, w := range s.waiters {
+		if strings.HasPrefix(line, w.prefix) {
+			select {
+			case w.ch <- line:
+			default:
+			}
+		}
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- line:
+		default:
+			log.Debugf("denon subscriber is behind, dropping %s", line)
+		}
+	}
+	s.mu.Unlock()
+
+	if !denonStateChanged(prev, cur) {
+		return
+	}
+	topic := config.GetString("mqtt.topicAvrState")
+	if topic == "" {
+		return
+	}
+	b, err := json.Marshal(cur)
+	if err != nil {
+		return
+	}
+	log.Debugf("denon state changed, publishing %s to %s", b, topic)
+	if err := mqtt.PublishWrapper(topic, string(b)); err != nil {
+		log.Errorf("Error publishing denon state: %v", err)
+	}
+}
+
+// applyDenonEvent returns the state updated with an event like MSDOLBY ATMOS. Unknown events are ignored
+func applyDenonEvent(state DenonState, line string) DenonState {
+	if len(line) < 2 {
+		return state
+	}
+	value := line[2:]
+	switch line[:2] {
+	case "PW":
+		state.Power = value
+	case "SI":
+		state.Input = value
+	case "MS":
+		// quick select and smart select events are not sound modes
+		if strings.HasPrefix(value, "QUICK") || strings.HasPrefix(value, "SMART") {
+			return state
+		}
+		state.SoundMode = value
+	case "MV":
+		// MVMAX is the volume limit
+		vol, ok := parseDenonVolume(value)
+		if !ok {
+			return state
+		}
+		state.Volume = vol
+	case "SD":
+		state.InputMode = value
+	default:
+		return state
+	}
+	state.Updated = time.Now()
+	return state
+}
+
+// parseDenonVolume turns the volume like 50, or 505 for 50.5, into dB where 80 is 0dB
+func parseDenonVolume(v string) (float64, bool) {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	vol := float64(n)
+	// three digits have a half step
+	if len(v) == 3 {
+		vol = float64(n) / 10
+	}
+	return vol - 80, true
+}
+
+// denonStateChanged ignores the update time
+func denonStateChanged(prev, cur DenonState) bool {
+	prev.Updated = cur.Updated
+	return prev != cur
+}
@@ -0,0 +1,188 @@
+package avr
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDenon answers queries from state, applies set commands and can push events to every connection
+type fakeDenon struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	state map[string]string
+	conns []net.Conn
+}
+
+func newFakeDenon(t *testing.T, state map[string]string) *fakeDenon {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeDenon{ln: ln, state: state}
+	t.Cleanup(func() {
+		ln.Close()
+		f.drop()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeDenon) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		cmd, err := r.ReadString('\r')
+		if err != nil {
+			return
+		}
+		cmd = strings.TrimSuffix(cmd, "\r")
+		f.mu.Lock()
+		// set commands are echoed back as the new state
+		reply, ok := f.state[cmd]
+		if !strings.HasSuffix(cmd, "?") && len(cmd) > 2 {
+			f.state[cmd[:2]+"?"] = cmd
+			reply, ok = cmd, true
+		}
+		f.mu.Unlock()
+		if ok {
+			_, _ = conn.Write([]byte(reply + "\r"))
+		}
+	}
+}
+
+// push sends an unsolicited event
+func (f *fakeDenon) push(event string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.conns {
+		_, _ = c.Write([]byte(event + "\r"))
+	}
+}
+
+// drop closes every connection like a receiver reboot
+func (f *fakeDenon) drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.conns {
+		c.Close()
+	}
+	f.conns = nil
+}
+
+// startDenonSession runs a session for the fake and registers it so clients use it, stopping it when the test ends
+func startDenonSession(t *testing.T, f *fakeDenon) *DenonClient {
+	host, port, _ := net.SplitHostPort(f.ln.Addr().String())
+	c := &DenonClient{ServerURL: host, Port: port}
+	s := newDenonSession(host + ":" + port)
+	s.retryBase, s.retryMax = 10*time.Millisecond, 20*time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		// the session reads the config, which later tests change
+		<-done
+		denonSessionsMu.Lock()
+		delete(denonSessions, s.addr)
+		denonSessionsMu.Unlock()
+	})
+	s.once.Do(func() {
+		go func() {
+			s.run(ctx)
+			close(done)
+		}()
+	})
+	denonSessionsMu.Lock()
+	denonSessions[s.addr] = s
+	denonSessionsMu.Unlock()
+	return c
+}
+
+func TestDenonSession(t *testing.T) {
+	assert := assert.New(t)
+	f := newFakeDenon(t, map[string]string{
+		"PW?": "PWON",
+		"SI?": "SIBD",
+		"MS?": "MSDOLBY ATMOS",
+		// the limit comes after the volume
+		"MV?": "MV505\rMVMAX 98",
+		"SD?": "SDHDMI",
+	})
+	c := startDenonSession(t, f)
+
+	// resynced on connect
+	assert.Eventually(func() bool {
+		state, ok := c.GetState()
+		return ok && state.InputMode == "HDMI"
+	}, time.Second, 10*time.Millisecond)
+	state, _ := c.GetState()
+	assert.Equal("ON", state.Power)
+	assert.Equal("BD", state.Input)
+	assert.Equal(-29.5, state.Volume)
+
+	codec, err := c.GetCodec()
+	assert.NoError(err)
+	assert.Equal("dolby atmos", codec)
+
+	// changes are picked up without asking
+	f.push("MSDTS:X")
+	assert.Eventually(func() bool {
+		codec, _ := c.GetCodec()
+		return codec == "dts:x"
+	}, time.Second, 10*time.Millisecond)
+
+	// queries get their own reply and not an event
+	f.push("MV45")
+	res, err := c.makeReq("PW?")
+	assert.NoError(err)
+	assert.Equal("PWON", res)
+
+	// reconnects and resyncs after the receiver drops the connection
+	f.mu.Lock()
+	f.state["MS?"] = "MSSTEREO"
+	f.mu.Unlock()
+	f.drop()
+	assert.Eventually(func() bool {
+		codec, err := c.GetCodec()
+		return err == nil && codec == "stereo"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestDenonQueryNotConnected(t *testing.T) {
+	s := newDenonSession("127.0.0.1:1")
+	_, err := s.query("MS?", 20*time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestApplyDenonEvent(t *testing.T) {
+	assert := assert.New(t)
+	var state DenonState
+	for _, e := range []string{"PWSTANDBY", "SIMPLAY", "MSDOLBY ATMOS", "MSQUICK1", "MV50", "MVMAX 98", "SDAUTO", "Z2ON", "X"} {
+		state = applyDenonEvent(state, e)
+	}
+	assert.Equal("STANDBY", state.Power)
+	assert.Equal("MPLAY", state.Input)
+	assert.Equal("DOLBY ATMOS", state.SoundMode)
+	assert.Equal(-30.0, state.Volume)
+	assert.Equal("AUTO", state.InputMode)
+
+	assert.False(denonStateChanged(state, applyDenonEvent(state, "PWSTANDBY")))
+	assert.True(denonStateChanged(state, applyDenonEvent(state, "PWON")))
+}
@@ -18,7 +18,7 @@ func TestMakeReq(t *testing.T) {
 
 	res, err := c.makeReq("PW?")
 	assert.NoError(t, err)
-	assert.Equal(t, "PWSTANDBY\r", res)
+	assert.Equal(t, "PWSTANDBY", res)
 
 }
 func TestGetAudioMode(t *testing.T) {
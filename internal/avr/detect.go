@@ -0,0 +1,109 @@
+package avr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+)
+
+var codecPollInterval = 250 * time.Millisecond
+
+const (
+	defaultCodecStableWindow = 2 * time.Second
+	defaultCodecDeadline     = 20 * time.Second
+)
+
+// Detection is the codec DetectCodec found and how long it took
+type Detection struct {
+	Codec string
+	// Reading is what the AVR reported
+	Reading string
+	// Stable is false if the deadline passed before the reading settled
+	Stable bool
+	Took   time.Duration
+	At     time.Time
+}
+
+var (
+	lastDetection   Detection
+	lastDetectionMu sync.Mutex
+)
+
+// LastDetection returns the latest detection, e.g for the health status
+func LastDetection() Detection {
+	lastDetectionMu.Lock()
+	defer lastDetectionMu.Unlock()
+	return lastDetection
+}
+
+// DetectionSettings returns ezbeq.avrCodecStableSeconds and ezbeq.avrCodecTimeoutSeconds, or the defaults if unset
+func DetectionSettings() (window, deadline time.Duration) {
+	window, deadline = defaultCodecStableWindow, defaultCodecDeadline
+	if s := config.GetFloat64("ezbeq.avrCodecStableSeconds"); s > 0 {
+		window = time.Duration(s * float64(time.Second))
+	}
+	if s := config.GetFloat64("ezbeq.avrCodecTimeoutSeconds"); s > 0 {
+		deadline = time.Duration(s * float64(time.Second))
+	}
+	return window, deadline
+}
+
+// DetectCodec polls the AVR until it reports the same thing for window, since receivers show PCM or the last format while the signal locks.
+// If that doesn't happen before deadline, the last reading is returned as not stable
+func DetectCodec(ctx context.Context, c AVRClient, m CodecMapper, window, deadline time.Duration) (Detection, error) {
+	start := time.Now()
+	timeout := time.After(deadline)
+	ticker := time.NewTicker(codecPollInterval)
+	defer ticker.Stop()
+
+	var d Detection
+	// since is when the current reading started, zero if the last poll failed
+	var since time.Time
+	var lastErr error
+	for {
+		reading, err := c.GetCodec()
+		now := time.Now()
+		switch {
+		case err != nil:
+			log.Debugf("Error reading codec from AVR, still waiting: %v", err)
+			lastErr = err
+			since = time.Time{}
+		case since.IsZero() || reading != d.Reading:
+			log.Debugf("AVR reports %q", reading)
+			d.Reading = reading
+			since = now
+		}
+		if !since.IsZero() && now.Sub(since) >= window {
+			d.Stable = true
+			return finishDetection(d, m, start), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return d, ctx.Err()
+		case <-timeout:
+			if since.IsZero() {
+				return d, fmt.Errorf("no codec from AVR after %v: %w", deadline, lastErr)
+			}
+			log.Warnf("AVR reading %q was not stable for %v before the %v deadline, using it anyway", d.Reading, window, deadline)
+			return finishDetection(d, m, start), nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// finishDetection maps the reading and saves the detection
+func finishDetection(d Detection, m CodecMapper, start time.Time) Detection {
+	d.Codec = m.MapCodec(d.Reading)
+	d.At = time.Now()
+	d.Took = d.At.Sub(start)
+	log.Infof("Detected codec %s from AVR reading %q in %v", d.Codec, d.Reading, d.Took.Round(time.Millisecond))
+
+	lastDetectionMu.Lock()
+	lastDetection = d
+	lastDetectionMu.Unlock()
+	return d
+}
@@ -0,0 +1,115 @@
+package avr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// settlingAVR reports each reading for a number of polls, like a receiver locking on to a signal
+type settlingAVR struct {
+	noControl
+	mu       sync.Mutex
+	readings []string
+	polls    []int
+}
+
+func (s *settlingAVR) GetCodec() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.readings) > 1 && s.polls[0] == 0 {
+		s.readings, s.polls = s.readings[1:], s.polls[1:]
+	}
+	if s.polls[0] > 0 {
+		s.polls[0]--
+	}
+	if s.readings[0] == "" {
+		return "", errors.New("no signal")
+	}
+	return s.readings[0], nil
+}
+
+func fastPolling(t *testing.T) {
+	interval := codecPollInterval
+	codecPollInterval = time.Millisecond
+	t.Cleanup(func() { codecPollInterval = interval })
+}
+
+func TestDetectCodec(t *testing.T) {
+	assert := assert.New(t)
+	fastPolling(t)
+	m := GetCodecMapper("denon")
+
+	// no signal, then pcm while the signal locks, then atmos
+	c := &settlingAVR{readings: []string{"", "MULTI CH IN", "DOLBY ATMOS"}, polls: []int{3, 5, -1}}
+	d, err := DetectCodec(context.Background(), c, m, 20*time.Millisecond, time.Second)
+	assert.NoError(err)
+	assert.True(d.Stable)
+	assert.Equal("Atmos", d.Codec)
+	assert.Equal("DOLBY ATMOS", d.Reading)
+	assert.GreaterOrEqual(d.Took, 20*time.Millisecond)
+	assert.Equal(d, LastDetection())
+
+	// no window takes the first reading
+	c = &settlingAVR{readings: []string{"STEREO", "DOLBY ATMOS"}, polls: []int{1, -1}}
+	d, err = DetectCodec(context.Background(), c, m, 0, time.Second)
+	assert.NoError(err)
+	assert.Equal("STEREO", d.Reading)
+	assert.Equal("Empty", d.Codec)
+}
+
+func TestDetectCodecDeadline(t *testing.T) {
+	assert := assert.New(t)
+	fastPolling(t)
+	m := GetCodecMapper("denon")
+
+	// never settles
+	c := &flappingAVR{readings: []string{"DOLBY ATMOS", "MULTI CH IN"}}
+	d, err := DetectCodec(context.Background(), c, m, time.Second, 30*time.Millisecond)
+	assert.NoError(err)
+	assert.False(d.Stable)
+	assert.NotEmpty(d.Codec)
+
+	// never reads anything
+	_, err = DetectCodec(context.Background(), &settlingAVR{readings: []string{""}, polls: []int{-1}}, m, 0, 30*time.Millisecond)
+	assert.ErrorContains(err, "no signal")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = DetectCodec(ctx, &settlingAVR{readings: []string{""}, polls: []int{-1}}, m, 0, time.Second)
+	assert.ErrorIs(err, context.Canceled)
+}
+
+// flappingAVR changes its reading on every poll
+type flappingAVR struct {
+	noControl
+	readings []string
+	n        int
+}
+
+func (f *flappingAVR) GetCodec() (string, error) {
+	f.n++
+	return f.readings[f.n%len(f.readings)], nil
+}
+
+func TestDetectionSettings(t *testing.T) {
+	assert := assert.New(t)
+	window, deadline := DetectionSettings()
+	assert.Equal(defaultCodecStableWindow, window)
+	assert.Equal(defaultCodecDeadline, deadline)
+
+	config.Set("ezbeq.avrCodecStableSeconds", 1.5)
+	config.Set("ezbeq.avrCodecTimeoutSeconds", "10")
+	t.Cleanup(func() {
+		config.Set("ezbeq.avrCodecStableSeconds", nil)
+		config.Set("ezbeq.avrCodecTimeoutSeconds", nil)
+	})
+	window, deadline = DetectionSettings()
+	assert.Equal(1500*time.Millisecond, window)
+	assert.Equal(10*time.Second, deadline)
+}
@@ -0,0 +1,166 @@
+package avr
+
+import (
+	"strings"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+)
+
+// codec for readings that don't map to anything
+const emptyCodec = "Empty"
+
+// CodecMapper maps what an AVR reports, from GetCodec, to an ezBEQ codec name
+type CodecMapper interface {
+	MapCodec(reading string) string
+}
+
+// Rule maps readings which contain every string in Match, and none in Exclude, to Codec. Matching ignores case
+type Rule struct {
+	Match   []string `mapstructure:"match" json:"match"`
+	Exclude []string `mapstructure:"exclude" json:"exclude"`
+	Codec   string   `mapstructure:"codec" json:"codec"`
+}
+
+func (r Rule) matches(reading string) bool {
+	if len(r.Match) == 0 {
+		return false
+	}
+	for _, s := range r.Match {
+		if !strings.Contains(reading, strings.ToLower(s)) {
+			return false
+		}
+	}
+	for _, s := range r.Exclude {
+		if strings.Contains(reading, strings.ToLower(s)) {
+			return false
+		}
+	}
+	return true
+}
+
+// TableMapper maps with the first matching rule. Codecs without a channel count are "maybe" codecs resolved through the ezbeq codec fallbacks
+type TableMapper struct {
+	Rules []Rule
+}
+
+func (m TableMapper) MapCodec(reading string) string {
+	reading = strings.ToLower(strings.TrimSpace(reading))
+	for _, r := range m.Rules {
+		if r.matches(reading) {
+			return r.Codec
+		}
+	}
+	return emptyCodec
+}
+
+// denonRules are for the sound mode (MS), which has no channel count except for multi channel PCM
+var denonRules = []Rule{
+	{Match: []string{"dolby atmos"}, Codec: "Atmos"},
+	{Match: []string{"dts:x"}, Codec: "DTS-X"},
+	{Match: []string{"dts-x"}, Codec: "DTS-X"},
+	// There are very few truehd 7.1 titles and many atmos titles have wrong metadata. Most of the time, TrueHD 7.1 is Atmos
+	{Match: []string{"dolby hd"}, Codec: "AtmosMaybe"},
+	{Match: []string{"truehd 5.1"}, Codec: "TrueHD 5.1"},
+	{Match: []string{"truehd 6.1"}, Codec: "TrueHD 6.1"},
+	{Match: []string{"truehd"}, Codec: "AtmosMaybe"},
+	{Match: []string{"dolby d+"}, Codec: "DD+AtmosMaybe"},
+	{Match: []string{"dolby digital +"}, Codec: "DD+AtmosMaybe"},
+	{Match: []string{"dd+"}, Codec: "DD+AtmosMaybe"},
+	{Match: []string{"dts-hd ma 7.1"}, Codec: "DTS-HD MA 7.1"},
+	{Match: []string{"dts-hd ma 5.1"}, Codec: "DTS-HD MA 5.1"},
+	{Match: []string{"dts-hd hra 7.1"}, Codec: "DTS-HD HR 7.1"},
+	{Match: []string{"dts-hd hra 5.1"}, Codec: "DTS-HD HR 5.1"},
+	{Match: []string{"dts hd mstr"}, Codec: "DTS-HDMAMaybe"},
+	{Match: []string{"dts hd"}, Codec: "DTS-HDMAMaybe"},
+	{Match: []string{"dts 5.1"}, Codec: "DTS 5.1"},
+	{Match: []string{"dts surround"}, Codec: "DTS 5.1"},
+	{Match: []string{"dts es"}, Codec: "DTS 5.1"},
+	{Match: []string{"multi ch in 7.1"}, Codec: "LPCM 7.1"},
+	{Match: []string{"multi ch in"}, Codec: "LPCMMaybe"},
+	{Match: []string{"lpcm 7.1"}, Codec: "LPCM 7.1"},
+	{Match: []string{"lpcm 5.1"}, Codec: "LPCM 5.1"},
+	{Match: []string{"lpcm 2.0"}, Codec: "LPCM 2.0"},
+	{Match: []string{"aac stereo"}, Codec: "AAC 2.0"},
+	{Match: []string{"ac3 5.1"}, Codec: "AC3 5.1"},
+	{Match: []string{"dolby digital"}, Codec: "AC3 5.1"},
+	{Match: []string{"dolby audio-dd"}, Codec: "AC3 5.1"},
+}
+
+// signalRules are for the signal format, with the channels and listening mode if the AVR reports them, like "Dolby TrueHD, 7.1 ch, Dolby Atmos".
+// Generic rules come last since listening modes can name another codec, like "DTS Neural:X"
+var signalRules = []Rule{
+	{Match: []string{"atmos", "plus"}, Codec: "DD+ Atmos"},
+	{Match: []string{"atmos", "dd+"}, Codec: "DD+ Atmos"},
+	{Match: []string{"atmos", "e-ac-3"}, Codec: "DD+ Atmos"},
+	{Match: []string{"atmos"}, Codec: "Atmos"},
+	{Match: []string{"dts:x"}, Codec: "DTS-X"},
+	{Match: []string{"dts-x"}, Codec: "DTS-X"},
+	// most truehd 7.1 titles are atmos, confirmed later like with denon
+	{Match: []string{"truehd", "7.1"}, Codec: "AtmosMaybe"},
+	{Match: []string{"truehd", "6.1"}, Codec: "TrueHD 6.1"},
+	{Match: []string{"truehd", "5.1"}, Codec: "TrueHD 5.1"},
+	{Match: []string{"truehd"}, Exclude: []string{"2.0"}, Codec: "AtmosMaybe"},
+	{Match: []string{"plus", "7.1"}, Codec: "DD+Atmos7.1Maybe"},
+	{Match: []string{"plus", "5.1"}, Codec: "DD+Atmos5.1Maybe"},
+	{Match: []string{"plus"}, Codec: "DD+AtmosMaybe"},
+	{Match: []string{"dd+"}, Codec: "DD+AtmosMaybe"},
+	{Match: []string{"e-ac-3"}, Codec: "DD+AtmosMaybe"},
+	{Match: []string{"eac3"}, Codec: "DD+AtmosMaybe"},
+	{Match: []string{"master audio", "7.1"}, Codec: "DTS-HD MA 7.1"},
+	{Match: []string{"master audio", "5.1"}, Codec: "DTS-HD MA 5.1"},
+	{Match: []string{"master audio"}, Exclude: []string{"6.1", "2.0"}, Codec: "DTS-HDMAMaybe"},
+	{Match: []string{"dts-hd ma", "7.1"}, Codec: "DTS-HD MA 7.1"},
+	{Match: []string{"dts-hd ma", "5.1"}, Codec: "DTS-HD MA 5.1"},
+	{Match: []string{"dts-hd ma"}, Exclude: []string{"6.1", "2.0"}, Codec: "DTS-HDMAMaybe"},
+	{Match: []string{"high resolution", "7.1"}, Codec: "DTS-HD HR 7.1"},
+	{Match: []string{"high resolution", "5.1"}, Codec: "DTS-HD HR 5.1"},
+	{Match: []string{"high resolution"}, Exclude: []string{"6.1", "2.0"}, Codec: "DTS-HDHRMaybe"},
+	{Match: []string{"dts-hd hr", "7.1"}, Codec: "DTS-HD HR 7.1"},
+	{Match: []string{"dts-hd hr", "5.1"}, Codec: "DTS-HD HR 5.1"},
+	{Match: []string{"dts-hd hr"}, Exclude: []string{"6.1", "2.0"}, Codec: "DTS-HDHRMaybe"},
+	{Match: []string{"pcm", "7.1"}, Codec: "LPCM 7.1"},
+	{Match: []string{"pcm", "5.1"}, Codec: "LPCM 5.1"},
+	{Match: []string{"pcm", "2.0"}, Codec: "LPCM 2.0"},
+	// multichannel pcm is 5.1 or 7.1
+	{Match: []string{"pcm", "multi"}, Codec: "LPCMMaybe"},
+	{Match: []string{"pcm"}, Exclude: []string{"ch"}, Codec: "LPCM 2.0"},
+	{Match: []string{"aac"}, Exclude: []string{"5.1", "7.1"}, Codec: "AAC 2.0"},
+	{Match: []string{"dolby d"}, Exclude: []string{"2.0", "7.1"}, Codec: "AC3 5.1"},
+	{Match: []string{"ac3"}, Exclude: []string{"2.0", "7.1"}, Codec: "AC3 5.1"},
+	{Match: []string{"ac-3"}, Exclude: []string{"2.0", "7.1"}, Codec: "AC3 5.1"},
+	{Match: []string{"dts"}, Exclude: []string{"dts-hd", "7.1", "6.1", "2.0"}, Codec: "DTS 5.1"},
+}
+
+// defaultRules are the rules for each brand in GetAVRClient
+var defaultRules = map[string][]Rule{
+	"denon":   denonRules,
+	"onkyo":   signalRules,
+	"integra": signalRules,
+	"pioneer": signalRules,
+	"yamaha":  signalRules,
+	"anthem":  signalRules,
+	"trinnov": signalRules,
+}
+
+// GetCodecMapper returns the mapper for a brand. Rules for the brand in ezbeq.avrCodecMappings are tried before the defaults
+func GetCodecMapper(brand string) CodecMapper {
+	brand = strings.ToLower(brand)
+	var custom map[string][]Rule
+	if err := config.UnmarshalKey("ezbeq.avrCodecMappings", &custom); err != nil {
+		log.Errorf("Error reading AVR codec mappings, ignoring them: %v", err)
+	}
+	// viper lowercases keys
+	rules := append([]Rule(nil), custom[brand]...)
+	return TableMapper{Rules: append(rules, defaultRules[brand]...)}
+}
+
+// ReadCodec gets the codec from the AVR and maps it to an ezBEQ codec name
+func ReadCodec(c AVRClient, mapper CodecMapper) (string, error) {
+	reading, err := c.GetCodec()
+	if err != nil {
+		return "", err
+	}
+	codec := mapper.MapCodec(reading)
+	log.Debugf("AVR reported %q, mapped to %s", reading, codec)
+	return codec, nil
+}
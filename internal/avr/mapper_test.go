@@ -0,0 +1,147 @@
+package avr
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// readings as receivers report them
+var codecCorpus = map[string]map[string]string{
+	"denon": {
+		"dolby atmos":        "Atmos",
+		"dts:x":              "DTS-X",
+		"dts:x (mstr)":       "DTS-X",
+		"dolby hd":           "AtmosMaybe",
+		"dolby audio-truehd": "AtmosMaybe",
+		"dolby digital +":    "DD+AtmosMaybe",
+		"dolby d+":           "DD+AtmosMaybe",
+		"dolby audio-dd+":    "DD+AtmosMaybe",
+		"dts hd mstr":        "DTS-HDMAMaybe",
+		"dts-hd ma 7.1":      "DTS-HD MA 7.1",
+		"dts-hd hra 5.1":     "DTS-HD HR 5.1",
+		"dts surround":       "DTS 5.1",
+		"multi ch in 7.1":    "LPCM 7.1",
+		"multi ch in":        "LPCMMaybe",
+		"truehd 5.1":         "TrueHD 5.1",
+		"dolby digital":      "AC3 5.1",
+		"dolby audio-dd":     "AC3 5.1",
+		"aac stereo":         "AAC 2.0",
+		"stereo":             "Empty",
+		"direct":             "Empty",
+	},
+	"onkyo": {
+		"Dolby Atmos, 7.1.4 ch, Dolby Atmos":               "Atmos",
+		"Dolby TrueHD, 7.1 ch, Dolby Atmos":                "Atmos",
+		"Dolby TrueHD, 7.1 ch, Straight Decode":            "AtmosMaybe",
+		"Dolby TrueHD, 5.1 ch, Straight Decode":            "TrueHD 5.1",
+		"Dolby Digital Plus, 7.1 ch, Dolby Atmos":          "DD+ Atmos",
+		"Dolby Digital Plus Atmos, 5.1.2 ch":               "DD+ Atmos",
+		"Dolby Digital Plus, 5.1 ch, Dolby Surround":       "DD+Atmos5.1Maybe",
+		"DTS:X, 7.1 ch, DTS:X":                             "DTS-X",
+		"DTS-HD Master Audio, 7.1 ch, DTS:X":               "DTS-X",
+		"DTS-HD Master Audio, 7.1 ch, DTS-HD Master Audio": "DTS-HD MA 7.1",
+		"DTS-HD Master Audio, 5.1 ch, DTS Neural:X":        "DTS-HD MA 5.1",
+		"DTS-HD High Resolution, 7.1 ch":                   "DTS-HD HR 7.1",
+		"DTS, 5.1 ch, DTS":                                 "DTS 5.1",
+		"Multich PCM, 7.1 ch, Multichannel":                "LPCM 7.1",
+		"PCM, 2.0 ch, Stereo":                              "LPCM 2.0",
+		"PCM, 5.1 ch, DTS Neural:X":                        "LPCM 5.1",
+		"AAC, 2.0 ch, Stereo":                              "AAC 2.0",
+		"Dolby D, 5.1 ch, Dolby Surround":                  "AC3 5.1",
+		"Dolby D, 5.1 ch, DTS Neural:X":                    "AC3 5.1",
+		"Unknown":                                          "Empty",
+	},
+	"yamaha": {
+		"Dolby Atmos":                  "Atmos",
+		"Dolby Digital Plus Atmos":     "DD+ Atmos",
+		"Dolby TrueHD":                 "AtmosMaybe",
+		"Dolby Digital Plus":           "DD+AtmosMaybe",
+		"DTS:X":                        "DTS-X",
+		"DTS-HD Master Audio":          "DTS-HDMAMaybe",
+		"DTS-HD High Resolution Audio": "DTS-HDHRMaybe",
+		"DTS":                          "DTS 5.1",
+		"Multi Ch PCM":                 "LPCMMaybe",
+		"PCM":                          "LPCM 2.0",
+		"AAC":                          "AAC 2.0",
+		"Dolby Digital":                "AC3 5.1",
+		"Analog":                       "Empty",
+	},
+	"anthem": {
+		"Dolby Atmos":            "Atmos",
+		"Dolby TrueHD 7.1":       "AtmosMaybe",
+		"Dolby TrueHD":           "AtmosMaybe",
+		"Dolby Digital Plus":     "DD+AtmosMaybe",
+		"Dolby Digital Plus 5.1": "DD+Atmos5.1Maybe",
+		"DTS:X":                  "DTS-X",
+		"DTS-HD MA 7.1":          "DTS-HD MA 7.1",
+		"DTS-HD Master Audio":    "DTS-HDMAMaybe",
+		"DTS-HD HR 6.1":          "Empty",
+		"DTS 5.1":                "DTS 5.1",
+		"PCM 2.0":                "LPCM 2.0",
+		"PCM 7.1":                "LPCM 7.1",
+		"Dolby Digital 5.1":      "AC3 5.1",
+		"Analog":                 "Empty",
+	},
+	"trinnov": {
+		"ATMOS TrueHD": "Atmos",
+		"ATMOS DD+":    "DD+ Atmos",
+		"TrueHD":       "AtmosMaybe",
+		"DD+":          "DD+AtmosMaybe",
+		"DTS:X":        "DTS-X",
+		"DTS-HD MA":    "DTS-HDMAMaybe",
+		"DTS":          "DTS 5.1",
+		"PCM":          "LPCM 2.0",
+		"none":         "Empty",
+	},
+}
+
+func TestCodecMapperCorpus(t *testing.T) {
+	for brand, readings := range codecCorpus {
+		m := GetCodecMapper(brand)
+		for reading, expected := range readings {
+			assert.Equal(t, expected, m.MapCodec(reading), brand+": "+reading)
+		}
+	}
+}
+
+func TestCustomCodecMappings(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.avrCodecMappings", map[string]interface{}{
+		"denon": []interface{}{
+			map[string]interface{}{"match": []string{"Dolby Digital +"}, "codec": "DD+ Atmos"},
+			map[string]interface{}{"match": []string{"dts"}, "exclude": []string{"mstr", "dts:x"}, "codec": "DTS 5.1"},
+		},
+	})
+	t.Cleanup(func() { config.Set("ezbeq.avrCodecMappings", nil) })
+
+	m := GetCodecMapper("Denon")
+	assert.Equal("DD+ Atmos", m.MapCodec("DOLBY DIGITAL +"))
+	assert.Equal("DTS 5.1", m.MapCodec("DTS HD"))
+	// falls through to the defaults
+	assert.Equal("DTS-HDMAMaybe", m.MapCodec("DTS HD MSTR"))
+	assert.Equal("DTS-X", m.MapCodec("DTS:X"))
+	// other brands are not affected
+	assert.Equal("DD+AtmosMaybe", GetCodecMapper("yamaha").MapCodec("Dolby Digital Plus"))
+	// unknown brands only use their custom rules
+	assert.Equal("Empty", GetCodecMapper("marantz").MapCodec("Dolby Atmos"))
+}
+
+type fakeCodecClient struct {
+	noControl
+	reading string
+	err     error
+}
+
+func (c fakeCodecClient) GetCodec() (string, error) { return c.reading, c.err }
+
+func TestReadCodec(t *testing.T) {
+	codec, err := ReadCodec(fakeCodecClient{reading: "DOLBY ATMOS"}, GetCodecMapper("denon"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Atmos", codec)
+
+	_, err = ReadCodec(fakeCodecClient{err: errors.New("standby")}, GetCodecMapper("denon"))
+	assert.Error(t, err)
+}
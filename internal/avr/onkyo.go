@@ -0,0 +1,216 @@
+package avr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// eISCP is ISCP wrapped in a 16 byte header, used by Onkyo, Integra and newer Pioneer receivers
+const (
+	eiscpMagic      = "ISCP"
+	eiscpHeaderSize = 16
+	eiscpVersion    = 1
+	// unit type 1 is a receiver
+	iscpStart = "!1"
+	// largest message we accept, receivers send well under this
+	eiscpMaxSize = 4096
+
+	onkyoPort    = "60128"
+	onkyoTimeout = 5 * time.Second
+)
+
+// OnkyoClient is a client for Onkyo, Integra and Pioneer receivers over eISCP
+type OnkyoClient struct {
+	noControl
+	ServerURL string
+	Port      string
+	Timeout   time.Duration
+}
+
+// AudioFormat is the incoming audio info from the IFA command
+type AudioFormat struct {
+	Input          string
+	Format         string
+	SampleRate     string
+	Channels       string
+	ListeningMode  string
+	OutputChannels string
+}
+
+// encodeEiscp wraps an ISCP command like IFAQSTN in an eISCP packet
+func encodeEiscp(command string) []byte {
+	data := iscpStart + command + "\r"
+	var buf bytes.Buffer
+	buf.WriteString(eiscpMagic)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(eiscpHeaderSize))
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+	buf.Write([]byte{eiscpVersion, 0, 0, 0})
+	buf.WriteString(data)
+	return buf.Bytes()
+}
+
+// readEiscp reads one packet and returns the command and parameter, like IFA and "HDMI 1,..."
+func readEiscp(r io.Reader) (string, error) {
+	header := make([]byte, eiscpHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	if string(header[:4]) != eiscpMagic {
+		return "", fmt.Errorf("not an eISCP packet: %q", header[:4])
+	}
+	headerSize := binary.BigEndian.Uint32(header[4:8])
+	dataSize := binary.BigEndian.Uint32(header[8:12])
+	if headerSize < eiscpHeaderSize || headerSize > eiscpMaxSize || dataSize > eiscpMaxSize {
+		return "", fmt.Errorf("invalid eISCP sizes %d and %d", headerSize, dataSize)
+	}
+	// skip any header bytes past the ones we know
+	if _, err := io.CopyN(io.Discard, r, int64(headerSize-eiscpHeaderSize)); err != nil {
+		return "", err
+	}
+	data := make([]byte, dataSize)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+
+	// receivers end messages with EOF, sometimes followed by CR LF
+	msg := strings.TrimRight(string(data), "\x1a\r\n")
+	if !strings.HasPrefix(msg, "!") || len(msg) < 2 {
+		return "", fmt.Errorf("invalid ISCP message: %q", msg)
+	}
+	// drop the start char and unit type
+	return msg[2:], nil
+}
+
+// makeReq sends a command and returns the parameter of the first reply for it.
+// Receivers also push status messages at any time so others are skipped
+func (c *OnkyoClient) makeReq(command string) (string, error) {
+	port := c.Port
+	if port == "" {
+		port = onkyoPort
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = onkyoTimeout
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.ServerURL, port), timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+
+	log.Debugf("Sending eISCP command: %s", command)
+	if _, err := conn.Write(encodeEiscp(command)); err != nil {
+		return "", err
+	}
+
+	// replies are the 3 letter command followed by the value
+	name := command[:3]
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readEiscp(r)
+		if err != nil {
+			return "", err
+		}
+		log.Debugf("Got eISCP message: %s", msg)
+		if !strings.HasPrefix(msg, name) {
+			continue
+		}
+		param := msg[len(name):]
+		// N/A is sent when the receiver can't answer, like in standby
+		if param == "N/A" {
+			return "", fmt.Errorf("receiver has no value for %s", name)
+		}
+		return param, nil
+	}
+}
+
+// GetAudioFormat returns the incoming audio signal info
+func (c *OnkyoClient) GetAudioFormat() (AudioFormat, error) {
+	res, err := c.makeReq("IFAQSTN")
+	if err != nil {
+		return AudioFormat{}, err
+	}
+	return parseAudioFormat(res)
+}
+
+// parseAudioFormat parses the IFA value, like "HDMI 1,Dolby TrueHD,48 kHz,7.1 ch,Dolby Atmos,7.1.4 ch,"
+func parseAudioFormat(s string) (AudioFormat, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) < 4 {
+		return AudioFormat{}, fmt.Errorf("unexpected audio info: %q", s)
+	}
+	// older models send fewer fields
+	for len(fields) < 6 {
+		fields = append(fields, "")
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return AudioFormat{
+		Input:          fields[0],
+		Format:         fields[1],
+		SampleRate:     fields[2],
+		Channels:       fields[3],
+		ListeningMode:  fields[4],
+		OutputChannels: fields[5],
+	}, nil
+}
+
+// listening modes from the LMD command
+var onkyoListeningModes = map[string]string{
+	"00": "Stereo",
+	"01": "Direct",
+	"0C": "All Ch Stereo",
+	"11": "Pure Audio",
+	"40": "Straight Decode",
+	"80": "Dolby Surround",
+	"82": "DTS Neural:X",
+	"FF": "Auto Surround",
+}
+
+// GetListeningMode returns the listening mode name, or its hex code if not known
+func (c *OnkyoClient) GetListeningMode() (string, error) {
+	res, err := c.makeReq("LMDQSTN")
+	if err != nil {
+		return "", err
+	}
+	if mode, ok := onkyoListeningModes[strings.ToUpper(res)]; ok {
+		return mode, nil
+	}
+	return res, nil
+}
+
+// GetCodec returns the format, channels and listening mode of the incoming audio, like "Dolby TrueHD, 7.1 ch, Dolby Atmos"
+func (c *OnkyoClient) GetCodec() (string, error) {
+	f, err := c.GetAudioFormat()
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("Got audio format from receiver: %#v", f)
+	if f.Format == "" {
+		return "", errors.New("receiver reported no audio format")
+	}
+	return f.String(), nil
+}
+
+// String joins the format, channels and listening mode, which is what the codec is mapped from
+func (f AudioFormat) String() string {
+	parts := []string{f.Format}
+	for _, s := range []string{f.Channels, f.ListeningMode} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
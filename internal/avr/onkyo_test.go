@@ -0,0 +1,123 @@
+package avr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeEiscp is a receiver that answers queries from replies and pushes a status message first
+type fakeEiscp struct {
+	ln      net.Listener
+	replies map[string]string
+}
+
+func newFakeEiscp(t *testing.T, replies map[string]string) *fakeEiscp {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeEiscp{ln: ln, replies: replies}
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeEiscp) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				msg, err := readEiscp(r)
+				if err != nil {
+					return
+				}
+				// unsolicited messages come before the reply
+				_, _ = conn.Write(fakeReply("NLTF3"))
+				reply, ok := f.replies[msg[:3]]
+				if !ok {
+					reply = "N/A"
+				}
+				_, _ = conn.Write(fakeReply(msg[:3] + reply))
+			}
+		}(conn)
+	}
+}
+
+// fakeReply encodes a message like a receiver does, ending with EOF CR LF
+func fakeReply(msg string) []byte {
+	data := "!1" + msg + "\x1a\r\n"
+	var buf bytes.Buffer
+	buf.WriteString("ISCP")
+	_ = binary.Write(&buf, binary.BigEndian, uint32(16))
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+	buf.Write([]byte{1, 0, 0, 0})
+	buf.WriteString(data)
+	return buf.Bytes()
+}
+
+func (f *fakeEiscp) client() *OnkyoClient {
+	host, port, _ := net.SplitHostPort(f.ln.Addr().String())
+	return &OnkyoClient{ServerURL: host, Port: port, Timeout: time.Second}
+}
+
+func TestEiscpPacket(t *testing.T) {
+	p := encodeEiscp("IFAQSTN")
+	assert.Equal(t, "ISCP", string(p[:4]))
+	assert.Equal(t, uint32(16), binary.BigEndian.Uint32(p[4:8]))
+	assert.Equal(t, uint32(len("!1IFAQSTN\r")), binary.BigEndian.Uint32(p[8:12]))
+
+	msg, err := readEiscp(bytes.NewReader(p))
+	assert.NoError(t, err)
+	assert.Equal(t, "IFAQSTN", msg)
+
+	_, err = readEiscp(bytes.NewReader([]byte("NOPE000000000000")))
+	assert.Error(t, err)
+}
+
+func TestOnkyoGetCodec(t *testing.T) {
+	f := newFakeEiscp(t, map[string]string{
+		"IFA": "HDMI 1,Dolby TrueHD,48 kHz,7.1 ch,Dolby Atmos,7.1.4 ch,",
+		"LMD": "0C",
+	})
+	c := f.client()
+
+	format, err := c.GetAudioFormat()
+	assert.NoError(t, err)
+	assert.Equal(t, AudioFormat{Input: "HDMI 1", Format: "Dolby TrueHD", SampleRate: "48 kHz", Channels: "7.1 ch", ListeningMode: "Dolby Atmos", OutputChannels: "7.1.4 ch"}, format)
+
+	codec, err := c.GetCodec()
+	assert.NoError(t, err)
+	assert.Equal(t, "Dolby TrueHD, 7.1 ch, Dolby Atmos", codec)
+
+	mode, err := c.GetListeningMode()
+	assert.NoError(t, err)
+	assert.Equal(t, "All Ch Stereo", mode)
+}
+
+func TestOnkyoStandby(t *testing.T) {
+	f := newFakeEiscp(t, map[string]string{})
+	_, err := f.client().GetCodec()
+	assert.Error(t, err)
+}
+
+func TestNewOnkyoClient(t *testing.T) {
+	original := config.GetString("ezbeq.avrbrand")
+	defer config.Set("ezbeq.avrbrand", original)
+
+	config.Set("ezbeq.avrbrand", "integra")
+	c, ok := GetAVRClient("192.168.1.20").(*OnkyoClient)
+	assert.True(t, ok)
+	assert.Equal(t, "60128", c.Port)
+}
@@ -0,0 +1,111 @@
+package avr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+)
+
+var (
+	// how long the AVR gets to turn on and switch input
+	preflightTimeout      = 20 * time.Second
+	preflightPollInterval = 500 * time.Millisecond
+	// receivers ignore commands while they turn on, so the input is sent again if it didn't stick
+	preflightResendAfter = 3 * time.Second
+)
+
+// PlayerInput is the AVR input a player is connected to
+type PlayerInput struct {
+	// Player is the plex player UUID or title, or the jellyfin device ID, device name or client name
+	Player string `mapstructure:"player" json:"player"`
+	Input  string `mapstructure:"input" json:"input"`
+}
+
+// ExpectedInput returns the input in ezbeq.avrPlayerInputs for the player. Any of the ids can match like ezbeq routes
+func ExpectedInput(ids ...string) (string, bool) {
+	var inputs []PlayerInput
+	if err := config.UnmarshalKey("ezbeq.avrPlayerInputs", &inputs); err != nil {
+		log.Errorf("Error reading AVR player inputs, ignoring them: %v", err)
+		return "", false
+	}
+	for _, p := range inputs {
+		for _, id := range ids {
+			if id != "" && strings.EqualFold(strings.TrimSpace(p.Player), strings.TrimSpace(id)) {
+				return p.Input, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Preflight turns the AVR on and switches it to input, then waits until it reports both so the codec it reads is for the player.
+// An empty input only checks the power. AVRs which can't report their power are assumed to be ready
+func Preflight(ctx context.Context, c AVRClient, input string) error {
+	on, err := c.GetPower()
+	if errors.Is(err, ErrNotSupported) {
+		log.Debug("AVR can't report its power, skipping preflight")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not get power: %w", err)
+	}
+	if !on {
+		log.Info("AVR is in standby, turning it on")
+		if err := c.SetPower(true); err != nil {
+			return fmt.Errorf("could not turn on: %w", err)
+		}
+	}
+
+	ticker := time.NewTicker(preflightPollInterval)
+	defer ticker.Stop()
+	deadline := time.After(preflightTimeout)
+	var lastSet time.Time
+	for {
+		ready, err := preflightReady(c, input)
+		if err != nil {
+			return err
+		}
+		if ready {
+			return nil
+		}
+		// the input can only be set once the AVR is on
+		if on, _ := c.GetPower(); on && input != "" && time.Since(lastSet) > preflightResendAfter {
+			log.Infof("Switching AVR to input %s", input)
+			if err := c.SetInput(input); err != nil {
+				return fmt.Errorf("could not set input: %w", err)
+			}
+			lastSet = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			if input == "" {
+				return fmt.Errorf("AVR did not turn on after %v", preflightTimeout)
+			}
+			return fmt.Errorf("AVR is not on and on input %s after %v", input, preflightTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// preflightReady is true once the AVR is on and on input, if set
+func preflightReady(c AVRClient, input string) (bool, error) {
+	on, err := c.GetPower()
+	if err != nil || !on {
+		return false, nil
+	}
+	if input == "" {
+		return true, nil
+	}
+	current, err := c.GetInput()
+	if errors.Is(err, ErrNotSupported) {
+		return false, fmt.Errorf("could not get input: %w", err)
+	}
+	return err == nil && strings.EqualFold(current, input), nil
+}
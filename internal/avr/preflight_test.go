@@ -0,0 +1,140 @@
+package avr
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAVR turns on and switches input after a number of polls, like a receiver that takes a while
+type fakeAVR struct {
+	noControl
+	mu         sync.Mutex
+	on         bool
+	input      string
+	bootPolls  int
+	stuck      bool
+	inputsSent int
+}
+
+func (f *fakeAVR) GetCodec() (string, error) { return "", nil }
+
+func (f *fakeAVR) GetPower() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.on {
+		return false, nil
+	}
+	if f.bootPolls > 0 {
+		f.bootPolls--
+		return false, nil
+	}
+	return true, nil
+}
+
+func (f *fakeAVR) SetPower(on bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.on = on
+	return nil
+}
+
+func (f *fakeAVR) GetInput() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.input, nil
+}
+
+func (f *fakeAVR) SetInput(input string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inputsSent++
+	if !f.stuck {
+		f.input = input
+	}
+	return nil
+}
+
+func fastPreflight(t *testing.T) {
+	timeout, poll, resend := preflightTimeout, preflightPollInterval, preflightResendAfter
+	preflightTimeout, preflightPollInterval, preflightResendAfter = 200*time.Millisecond, 5*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() {
+		preflightTimeout, preflightPollInterval, preflightResendAfter = timeout, poll, resend
+	})
+}
+
+func TestPreflight(t *testing.T) {
+	assert := assert.New(t)
+	fastPreflight(t)
+
+	// off and on another input
+	f := &fakeAVR{input: "TV", bootPolls: 3}
+	assert.NoError(Preflight(context.Background(), f, "bd"))
+	assert.True(f.on)
+	assert.Equal("bd", f.input)
+	assert.Equal(1, f.inputsSent)
+
+	// already ready
+	f = &fakeAVR{on: true, input: "BD"}
+	assert.NoError(Preflight(context.Background(), f, "BD"))
+	assert.Equal(0, f.inputsSent)
+
+	// only power
+	f = &fakeAVR{input: "TV"}
+	assert.NoError(Preflight(context.Background(), f, ""))
+	assert.Equal("TV", f.input)
+
+	// the input never changes
+	f = &fakeAVR{on: true, input: "TV", stuck: true}
+	assert.Error(Preflight(context.Background(), f, "BD"))
+	assert.Greater(f.inputsSent, 1)
+
+	// nothing to check
+	assert.NoError(Preflight(context.Background(), &OnkyoClient{}, "BD"))
+}
+
+func TestDenonPreflight(t *testing.T) {
+	assert := assert.New(t)
+	fastPreflight(t)
+	f := newFakeDenon(t, map[string]string{
+		"PW?": "PWSTANDBY",
+		"SI?": "SITV",
+		"MS?": "MSSTEREO",
+		"MV?": "MV40",
+		"SD?": "SDAUTO",
+	})
+	c := startDenonSession(t, f)
+	assert.Eventually(func() bool {
+		_, ok := c.GetState()
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(Preflight(context.Background(), c, "MPLAY"))
+	state, _ := c.GetState()
+	assert.Equal("ON", state.Power)
+	assert.Equal("MPLAY", state.Input)
+}
+
+func TestExpectedInput(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.avrPlayerInputs", []interface{}{
+		map[string]interface{}{"player": "shield-uuid", "input": "MPLAY"},
+		map[string]interface{}{"player": "Living Room", "input": "BD"},
+	})
+	t.Cleanup(func() { config.Set("ezbeq.avrPlayerInputs", nil) })
+
+	input, ok := ExpectedInput("shield-uuid")
+	assert.True(ok)
+	assert.Equal("MPLAY", input)
+
+	input, ok = ExpectedInput("", "living room")
+	assert.True(ok)
+	assert.Equal("BD", input)
+
+	_, ok = ExpectedInput("other")
+	assert.False(ok)
+}
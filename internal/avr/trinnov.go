@@ -0,0 +1,117 @@
+package avr
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	trinnovPort    = "44100"
+	trinnovTimeout = 5 * time.Second
+	// name we identify as, shown in the trinnov client list
+	trinnovClientID = "gowatchit"
+)
+
+// TrinnovClient is a client for Trinnov Altitude processors over their TCP protocol
+type TrinnovClient struct {
+	noControl
+	ServerURL string
+	Port      string
+	Timeout   time.Duration
+}
+
+// TrinnovDecoder is the DECODER state, like "DECODER NONAUDIO 0 PLAYABLE 1 DECODER ATMOS TrueHD UPMIXER none"
+type TrinnovDecoder struct {
+	NonAudio bool
+	Playable bool
+	Decoder  string
+	Upmixer  string
+}
+
+// GetDecoder asks for the current state and returns the decoder from it
+func (c *TrinnovClient) GetDecoder() (TrinnovDecoder, error) {
+	port := c.Port
+	if port == "" {
+		port = trinnovPort
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = trinnovTimeout
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.ServerURL, port), timeout)
+	if err != nil {
+		return TrinnovDecoder{}, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return TrinnovDecoder{}, err
+	}
+
+	r := bufio.NewReader(conn)
+	// the processor greets with its version first
+	welcome, err := r.ReadString('\n')
+	if err != nil {
+		return TrinnovDecoder{}, err
+	}
+	log.Debugf("Connected to trinnov: %s", strings.TrimSpace(welcome))
+
+	// clients must identify before sending commands
+	if _, err := fmt.Fprintf(conn, "id %s\nget_current_state\n", trinnovClientID); err != nil {
+		return TrinnovDecoder{}, err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return TrinnovDecoder{}, err
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "ERROR") {
+			return TrinnovDecoder{}, fmt.Errorf("trinnov returned %s", line)
+		}
+		if strings.HasPrefix(line, "DECODER ") {
+			log.Debugf("Got trinnov decoder: %s", line)
+			return parseTrinnovDecoder(line)
+		}
+	}
+}
+
+// parseTrinnovDecoder parses the DECODER line, the decoder and upmixer names can have spaces
+func parseTrinnovDecoder(line string) (TrinnovDecoder, error) {
+	var d TrinnovDecoder
+	var nonAudio, playable int
+	if _, err := fmt.Sscanf(line, "DECODER NONAUDIO %d PLAYABLE %d", &nonAudio, &playable); err != nil {
+		return d, fmt.Errorf("unexpected decoder state %q: %w", line, err)
+	}
+	d.NonAudio = nonAudio == 1
+	d.Playable = playable == 1
+
+	_, rest, ok := strings.Cut(line, " PLAYABLE ")
+	if !ok {
+		return d, fmt.Errorf("unexpected decoder state %q", line)
+	}
+	_, rest, ok = strings.Cut(rest, " DECODER ")
+	if !ok {
+		return d, fmt.Errorf("unexpected decoder state %q", line)
+	}
+	d.Decoder, d.Upmixer, _ = strings.Cut(rest, " UPMIXER ")
+	d.Decoder = strings.TrimSpace(d.Decoder)
+	d.Upmixer = strings.TrimSpace(d.Upmixer)
+	return d, nil
+}
+
+// GetCodec returns the decoder of the incoming audio, like "ATMOS TrueHD". The decoder has no channel count
+func (c *TrinnovClient) GetCodec() (string, error) {
+	d, err := c.GetDecoder()
+	if err != nil {
+		return "", err
+	}
+	if d.NonAudio || d.Decoder == "" || strings.EqualFold(d.Decoder, "none") {
+		return "", errors.New("trinnov reported no audio signal")
+	}
+	return d.Decoder, nil
+}
@@ -0,0 +1,43 @@
+package avr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrinnovGetCodec(t *testing.T) {
+	decoder := "DECODER NONAUDIO 0 PLAYABLE 1 DECODER ATMOS TrueHD UPMIXER none"
+	identified := false
+	host, port := newFakeTCP(t, "Welcome on Trinnov Optimizer (Version 4.3.2, ID 10485761)\n", '\n', func(msg string) string {
+		switch {
+		case strings.HasPrefix(msg, "id "):
+			identified = true
+			return "OK\n"
+		case msg == "get_current_state" && identified:
+			return "SRATE 48000\nAUDIOSYNC Slave\n" + decoder + "\nVOLUME -30.0\n"
+		default:
+			return "ERROR: unknown command\n"
+		}
+	})
+	c := &TrinnovClient{ServerURL: host, Port: port, Timeout: time.Second}
+
+	codec, err := c.GetCodec()
+	assert.NoError(t, err)
+	assert.Equal(t, "ATMOS TrueHD", codec)
+
+	decoder = "DECODER NONAUDIO 1 PLAYABLE 0 DECODER none UPMIXER none"
+	_, err = c.GetCodec()
+	assert.Error(t, err)
+}
+
+func TestParseTrinnovDecoder(t *testing.T) {
+	d, err := parseTrinnovDecoder("DECODER NONAUDIO 0 PLAYABLE 1 DECODER DTS-HD MA UPMIXER Neural:X")
+	assert.NoError(t, err)
+	assert.Equal(t, TrinnovDecoder{Playable: true, Decoder: "DTS-HD MA", Upmixer: "Neural:X"}, d)
+
+	_, err = parseTrinnovDecoder("DECODER garbage")
+	assert.Error(t, err)
+}
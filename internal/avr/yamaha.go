@@ -0,0 +1,120 @@
+package avr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	yamahaPort = "80"
+	// all MusicCast receivers have a main zone
+	yamahaZone = "main"
+)
+
+// YamahaClient is a client for Yamaha receivers over the MusicCast (YamahaExtendedControl) HTTP API
+type YamahaClient struct {
+	noControl
+	ServerURL  string
+	Port       string
+	Zone       string
+	HTTPClient http.Client
+}
+
+// YamahaSignalInfo is the response of getSignalInfo
+type YamahaSignalInfo struct {
+	ResponseCode int `json:"response_code"`
+	Audio        struct {
+		Error  int    `json:"error"`
+		Format string `json:"format"`
+		Fs     string `json:"fs"`
+	} `json:"audio"`
+}
+
+// YamahaStatus is the part of getStatus we use
+type YamahaStatus struct {
+	ResponseCode    int    `json:"response_code"`
+	Power           string `json:"power"`
+	Input           string `json:"input"`
+	SoundProgram    string `json:"sound_program"`
+	SurrDecoderType string `json:"surr_decoder_type"`
+}
+
+// NewYamahaClient returns a client for the main zone
+func NewYamahaClient(url, port string) *YamahaClient {
+	return &YamahaClient{
+		ServerURL: url,
+		Port:      port,
+		Zone:      yamahaZone,
+		HTTPClient: http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+// makeReq gets a path under the zone, like getSignalInfo, and decodes it into v
+func (c *YamahaClient) makeReq(path string, v interface{}) error {
+	base := c.ServerURL
+	if !strings.HasPrefix(base, "http") {
+		base = "http://" + base
+	}
+	url := fmt.Sprintf("%s:%s/YamahaExtendedControl/v1/%s/%s", base, c.Port, c.Zone, path)
+	log.Debugf("Sending yamaha request: %s", url)
+
+	res, err := c.HTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 200 {
+		return errors.New(res.Status)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// GetSignalInfo returns the incoming signal info
+func (c *YamahaClient) GetSignalInfo() (YamahaSignalInfo, error) {
+	var info YamahaSignalInfo
+	if err := c.makeReq("getSignalInfo", &info); err != nil {
+		return info, err
+	}
+	// any non zero code is an error, like 3 when the zone is off
+	if info.ResponseCode != 0 {
+		return info, fmt.Errorf("yamaha returned response code %d", info.ResponseCode)
+	}
+	return info, nil
+}
+
+// GetStatus returns the power, input and sound program of the zone
+func (c *YamahaClient) GetStatus() (YamahaStatus, error) {
+	var status YamahaStatus
+	if err := c.makeReq("getStatus", &status); err != nil {
+		return status, err
+	}
+	if status.ResponseCode != 0 {
+		return status, fmt.Errorf("yamaha returned response code %d", status.ResponseCode)
+	}
+	return status, nil
+}
+
+// GetCodec returns the format of the incoming audio, like "Dolby Atmos". Yamaha does not report the channel count
+func (c *YamahaClient) GetCodec() (string, error) {
+	info, err := c.GetSignalInfo()
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("Got signal info from yamaha: %#v", info.Audio)
+	if info.Audio.Error != 0 || info.Audio.Format == "" {
+		return "", errors.New("yamaha reported no audio signal")
+	}
+	return info.Audio.Format, nil
+}
@@ -0,0 +1,67 @@
+package avr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeYamaha serves MusicCast responses by path
+func newFakeYamaha(t *testing.T, responses map[string]string) *YamahaClient {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(res))
+	}))
+	t.Cleanup(srv.Close)
+	i := strings.LastIndex(srv.URL, ":")
+	return NewYamahaClient(srv.URL[:i], srv.URL[i+1:])
+}
+
+func TestYamahaGetCodec(t *testing.T) {
+	c := newFakeYamaha(t, map[string]string{
+		"/YamahaExtendedControl/v1/main/getSignalInfo": `{"response_code":0,"audio":{"error":0,"format":"Dolby Atmos","fs":"48 kHz"},"video":{"resolution":"2160p"}}`,
+		"/YamahaExtendedControl/v1/main/getStatus":     `{"response_code":0,"power":"on","input":"hdmi1","sound_program":"straight","surr_decoder_type":"auto"}`,
+	})
+
+	codec, err := c.GetCodec()
+	assert.NoError(t, err)
+	assert.Equal(t, "Dolby Atmos", codec)
+
+	status, err := c.GetStatus()
+	assert.NoError(t, err)
+	assert.Equal(t, "hdmi1", status.Input)
+	assert.Equal(t, "straight", status.SoundProgram)
+}
+
+func TestYamahaNoSignal(t *testing.T) {
+	c := newFakeYamaha(t, map[string]string{
+		"/YamahaExtendedControl/v1/main/getSignalInfo": `{"response_code":0,"audio":{"error":1,"format":"","fs":""}}`,
+	})
+	_, err := c.GetCodec()
+	assert.Error(t, err)
+
+	// zone off
+	c = newFakeYamaha(t, map[string]string{
+		"/YamahaExtendedControl/v1/main/getSignalInfo": `{"response_code":3}`,
+	})
+	_, err = c.GetCodec()
+	assert.Error(t, err)
+}
+
+func TestNewYamahaClient(t *testing.T) {
+	original := config.GetString("ezbeq.avrbrand")
+	defer config.Set("ezbeq.avrbrand", original)
+
+	config.Set("ezbeq.avrbrand", "yamaha")
+	c, ok := GetAVRClient("192.168.1.30").(*YamahaClient)
+	assert.True(t, ok)
+	assert.Equal(t, "main", c.Zone)
+}
@@ -22,7 +22,7 @@ import (
 // IsExpectedCodecPlaying checks if AVR is playing expectedCodec (mapped and normalized string)
 func IsExpectedCodecPlayingAVR(avrClient avr.AVRClient, expectedCodec string) (bool, error) {
 	// get the codec from avr
-	codec, err := avrClient.GetCodec()
+	codec, err := avr.ReadCodec(avrClient, avr.GetCodecMapper(config.GetString("ezbeq.avrbrand")))
 	if err != nil {
 		log.Errorf("error getting codec from denon, can't continue: %s", err)
 		return false, err
@@ -8,6 +8,8 @@ import (
 
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 var v *viper.Viper
@@ -99,3 +101,37 @@ func GetInt(key string) int {
 func GetIntSlice(key string) []int {
 	return v.GetIntSlice(key)
 }
+
+// IsSet is true if the key has a value. The web UI saves empty fields as blank strings, so those count as unset
+func IsSet(key string) bool {
+	return v.IsSet(key) && strings.TrimSpace(v.GetString(key)) != ""
+}
+
+func GetStringMapString(key string) map[string]string {
+	return v.GetStringMapString(key)
+}
+
+func GetStringMapStringSlice(key string) map[string][]string {
+	return v.GetStringMapStringSlice(key)
+}
+
+func UnmarshalKey(key string, rawVal interface{}) error {
+	return v.UnmarshalKey(key, rawVal)
+}
+
+func GetFloat64(key string) float64 {
+	return v.GetFloat64(key)
+}
+
+// LookupFloat64 returns the value if it is set and a number. A blank field or something like "undefined" from the web UI is false
+func LookupFloat64(key string) (float64, bool) {
+	if !IsSet(key) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v.GetString(key)), 64)
+	if err != nil {
+		log.Warnf("%s is not a number: %q", key, v.GetString(key))
+		return 0, false
+	}
+	return f, true
+}
@@ -0,0 +1,171 @@
+// Package coverage scans the media libraries and reports which titles have a BEQ profile
+package coverage
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/ezbeq"
+	"github.com/iloveicedgreentea/go-plex/internal/logger"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+var log = logger.GetLogger()
+
+// status of a title
+const (
+	StatusBEQ     = "beq"
+	StatusNoBEQ   = "no_beq"
+	StatusBlocked = "blocked"
+	StatusError   = "error"
+)
+
+// codec the media servers map to when they don't know it
+const emptyCodec = "Empty"
+
+// Item is a title in a library and the BEQ entry it would get
+type Item struct {
+	Source  string `json:"source"`
+	Title   string `json:"title"`
+	Year    int    `json:"year"`
+	Season  int    `json:"season,omitempty"`
+	TMDB    string `json:"tmdb"`
+	Codec   string `json:"codec"`
+	Edition string `json:"edition"`
+	Status  string `json:"status"`
+	EntryID string `json:"entryId,omitempty"`
+	Author  string `json:"author,omitempty"`
+	// Reason is why there is no entry
+	Reason string `json:"reason,omitempty"`
+	// MissingTMDB titles are matched on title, which is less reliable
+	MissingTMDB bool `json:"missingTmdb"`
+	// EmptyCodec titles can't be matched since their codec is unknown
+	EmptyCodec bool `json:"emptyCodec"`
+}
+
+// Summary counts the titles in a report
+type Summary struct {
+	Titles      int `json:"titles"`
+	WithBEQ     int `json:"withBeq"`
+	WithoutBEQ  int `json:"withoutBeq"`
+	MissingTMDB int `json:"missingTmdb"`
+	EmptyCodec  int `json:"emptyCodec"`
+}
+
+// Report is the result of a scan
+type Report struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Summary  Summary   `json:"summary"`
+	Items    []Item    `json:"items"`
+	// Errors are sources that could not be read
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Source lists the titles of a media server with their metadata and codec filled in
+type Source interface {
+	Name() string
+	Titles(ctx context.Context) ([]Item, error)
+}
+
+// Matcher finds the entry that would be loaded for a title, see ezbeq.BeqClient.FindEntry
+type Matcher interface {
+	FindEntry(ctx context.Context, m *models.SearchRequest) (models.BeqCatalog, error)
+}
+
+// Scan lists every title in the sources and matches each against the catalog
+func Scan(ctx context.Context, matcher Matcher, sources ...Source) Report {
+	r := Report{Started: time.Now()}
+	for _, s := range sources {
+		items, err := s.Titles(ctx)
+		if err != nil {
+			log.Errorf("Coverage: could not list %s titles: %v", s.Name(), err)
+			r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", s.Name(), err))
+		}
+		log.Infof("Coverage: matching %d %s titles", len(items), s.Name())
+		for _, it := range items {
+			if ctx.Err() != nil {
+				r.Errors = append(r.Errors, ctx.Err().Error())
+				r.Finished = time.Now()
+				return r
+			}
+			r.add(match(ctx, matcher, it))
+		}
+	}
+	r.Finished = time.Now()
+	log.Infof("Coverage: %d of %d titles have BEQ", r.Summary.WithBEQ, r.Summary.Titles)
+	return r
+}
+
+// match fills in the entry or why there is none
+func match(ctx context.Context, matcher Matcher, it Item) Item {
+	it.MissingTMDB = it.TMDB == ""
+	it.EmptyCodec = it.Codec == "" || it.Codec == emptyCodec
+	// errors from the source are already set
+	if it.Status == StatusError {
+		return it
+	}
+	if it.EmptyCodec {
+		it.Status = StatusNoBEQ
+		it.Reason = "codec is not mapped"
+		return it
+	}
+
+	m := &models.SearchRequest{Title: it.Title, Year: it.Year, TMDB: it.TMDB, Codec: it.Codec, Edition: it.Edition, Season: it.Season}
+	e, err := matcher.FindEntry(ctx, m)
+	switch {
+	case errors.Is(err, ezbeq.ErrBlocked):
+		it.Status = StatusBlocked
+		it.Reason = err.Error()
+	case err != nil:
+		it.Status = StatusNoBEQ
+		it.Reason = err.Error()
+	default:
+		it.Status = StatusBEQ
+		it.EntryID = e.ID
+		it.Author = e.Author
+	}
+	return it
+}
+
+func (r *Report) add(it Item) {
+	r.Items = append(r.Items, it)
+	r.Summary.Titles++
+	if it.Status == StatusBEQ {
+		r.Summary.WithBEQ++
+	} else {
+		r.Summary.WithoutBEQ++
+	}
+	if it.MissingTMDB {
+		r.Summary.MissingTMDB++
+	}
+	if it.EmptyCodec {
+		r.Summary.EmptyCodec++
+	}
+}
+
+// WriteCSV writes a row per title
+func (r Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{"source", "title", "year", "season", "tmdb", "codec", "edition", "status", "entry_id", "author", "reason", "missing_tmdb", "empty_codec"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, it := range r.Items {
+		season := ""
+		if it.Season > 0 {
+			season = strconv.Itoa(it.Season)
+		}
+		row := []string{it.Source, it.Title, strconv.Itoa(it.Year), season, it.TMDB, it.Codec, it.Edition, it.Status, it.EntryID, it.Author, it.Reason, strconv.FormatBool(it.MissingTMDB), strconv.FormatBool(it.EmptyCodec)}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,105 @@
+package coverage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/ezbeq"
+	"github.com/iloveicedgreentea/go-plex/internal/plex"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource struct {
+	items []Item
+	err   error
+}
+
+func (s fakeSource) Name() string { return "fake" }
+
+func (s fakeSource) Titles(ctx context.Context) ([]Item, error) { return s.items, s.err }
+
+// fakeMatcher has an entry for each tmdb ID
+type fakeMatcher map[string]models.BeqCatalog
+
+func (f fakeMatcher) FindEntry(ctx context.Context, m *models.SearchRequest) (models.BeqCatalog, error) {
+	if m.TMDB == "blocked" {
+		return models.BeqCatalog{}, fmt.Errorf("%w: %s", ezbeq.ErrBlocked, m.Title)
+	}
+	e, ok := f[m.TMDB]
+	if !ok {
+		return models.BeqCatalog{}, errors.New("beq profile was not found in catalog")
+	}
+	return e, nil
+}
+
+func TestScan(t *testing.T) {
+	assert := assert.New(t)
+	matcher := fakeMatcher{"51497": {ID: "1", Author: "aron7awol"}}
+	src := fakeSource{items: []Item{
+		{Source: "fake", Title: "Fast Five", Year: 2011, TMDB: "51497", Codec: "DTS-X"},
+		{Title: "12 Strong", Year: 2018, TMDB: "429351", Codec: "DTS-HD MA 7.1"},
+		{Title: "Unknown", Year: 2020, Codec: "Atmos"},
+		{Title: "Stereo", Year: 1999, TMDB: "2", Codec: "Empty"},
+		{Title: "Blocked", Year: 2022, TMDB: "blocked", Codec: "Atmos"},
+		{Title: "Broken", Status: StatusError, Reason: "timeout"},
+	}, err: errors.New("library TV: timeout")}
+
+	r := Scan(context.Background(), matcher, src)
+	assert.Equal(Summary{Titles: 6, WithBEQ: 1, WithoutBEQ: 5, MissingTMDB: 2, EmptyCodec: 2}, r.Summary)
+	assert.Equal([]string{"fake: library TV: timeout"}, r.Errors)
+
+	statuses := make([]string, 0, len(r.Items))
+	for _, it := range r.Items {
+		statuses = append(statuses, it.Status)
+	}
+	assert.Equal([]string{StatusBEQ, StatusNoBEQ, StatusNoBEQ, StatusNoBEQ, StatusBlocked, StatusError}, statuses)
+	assert.Equal("1", r.Items[0].EntryID)
+	assert.Equal("aron7awol", r.Items[0].Author)
+	assert.Equal("codec is not mapped", r.Items[3].Reason)
+
+	var buf bytes.Buffer
+	assert.NoError(r.WriteCSV(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 7)
+	assert.Equal("fake,Fast Five,2011,,51497,DTS-X,,beq,1,aron7awol,,false,false", lines[1])
+}
+
+func TestPlexSource(t *testing.T) {
+	assert := assert.New(t)
+	responses := map[string]string{
+		"/library/sections":       `<MediaContainer><Directory key="1" type="movie" title="Movies"/><Directory key="2" type="show" title="TV"/><Directory key="3" type="artist" title="Music"/></MediaContainer>`,
+		"/library/sections/1/all": `<MediaContainer><Video key="/library/metadata/10" type="movie" title="Fast Five" year="2011"/></MediaContainer>`,
+		// two episodes of the same season are one item
+		"/library/sections/2/all": `<MediaContainer><Video key="/library/metadata/21" type="episode" title="Pilot" year="2019" grandparentKey="/library/metadata/20" grandparentTitle="The Mandalorian" parentIndex="1" index="1"/><Video key="/library/metadata/22" type="episode" title="The Child" year="2019" grandparentKey="/library/metadata/20" grandparentTitle="The Mandalorian" parentIndex="1" index="2"/></MediaContainer>`,
+		"/library/metadata/10":    `<MediaContainer><Video title="Fast Five" editionTitle="Extended"><Media><Part><Stream streamType="2" displayTitle="DTS-HD MA 7.1" extendedDisplayTitle="DTS:X"/></Part></Media><Guid id="tmdb://51497"/></Video></MediaContainer>`,
+		"/library/metadata/20":    `<MediaContainer><Directory type="show" title="The Mandalorian" year="2019"><Guid id="tmdb://82856"/></Directory></MediaContainer>`,
+		"/library/metadata/21":    `<MediaContainer><Video title="Pilot"><Media><Part><Stream streamType="2" displayTitle="TrueHD 7.1 Atmos" extendedDisplayTitle="Atmos"/></Part></Media><Guid id="tmdb://1"/></Video></MediaContainer>`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(res))
+	}))
+	defer srv.Close()
+	i := strings.LastIndex(srv.URL, ":")
+	client := plex.NewClient(srv.URL[:i], srv.URL[i+1:], "", "")
+
+	items, err := PlexSource{Client: client}.Titles(context.Background())
+	assert.NoError(err)
+	assert.Equal([]Item{{Source: "plex", Title: "Fast Five", Year: 2011, TMDB: "51497", Codec: "DTS-X", Edition: "Extended"}}, items)
+
+	items, err = PlexSource{Client: client, TV: true}.Titles(context.Background())
+	assert.NoError(err)
+	assert.Len(items, 2)
+	assert.Equal(Item{Source: "plex", Title: "The Mandalorian", Year: 2019, Season: 1, TMDB: "82856", Codec: "Atmos"}, items[1])
+}
@@ -0,0 +1,180 @@
+package coverage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/ezbeq"
+	"github.com/iloveicedgreentea/go-plex/internal/jellyfin"
+	"github.com/iloveicedgreentea/go-plex/internal/plex"
+)
+
+// docker path, same volume as the config
+const defaultReportPath = "/data/coverage.json"
+
+// ErrRunning is returned when a scan is started while one is running
+var ErrRunning = errors.New("a coverage scan is already running")
+
+var (
+	mu           sync.Mutex
+	running      bool
+	last         *Report
+	scheduleOnce sync.Once
+)
+
+// begin marks a scan as running, false if one already is
+func begin() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if running {
+		return false
+	}
+	running = true
+	return true
+}
+
+func end() {
+	mu.Lock()
+	defer mu.Unlock()
+	running = false
+}
+
+// Running reports if a scan is in progress
+func Running() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return running
+}
+
+// Run scans the enabled libraries and keeps the report
+func Run(ctx context.Context) (Report, error) {
+	if !begin() {
+		return Report{}, ErrRunning
+	}
+	defer end()
+	return run(ctx)
+}
+
+// Start runs a scan in the background
+func Start() error {
+	if !begin() {
+		return ErrRunning
+	}
+	go func() {
+		defer end()
+		if _, err := run(context.Background()); err != nil {
+			log.Errorf("Coverage scan failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+func run(ctx context.Context) (Report, error) {
+	beqClient, err := ezbeq.NewClient(config.GetString("ezbeq.url"), config.GetString("ezbeq.port"))
+	defer beqClient.Close()
+	if err != nil {
+		return Report{}, fmt.Errorf("could not connect to ezbeq: %w", err)
+	}
+	sources := configuredSources()
+	if len(sources) == 0 {
+		return Report{}, errors.New("neither plex nor jellyfin is enabled")
+	}
+
+	log.Info("Starting coverage scan")
+	r := Scan(ctx, beqClient, sources...)
+
+	mu.Lock()
+	last = &r
+	mu.Unlock()
+	if err := save(defaultReportPath, r); err != nil {
+		log.Warnf("Could not save coverage report: %v", err)
+	}
+	return r, nil
+}
+
+// configuredSources returns a source for each enabled media server
+func configuredSources() []Source {
+	tv := config.GetBool("ezbeq.enableTvBeq")
+	var sources []Source
+	if config.GetBool("plex.enabled") {
+		sources = append(sources, PlexSource{
+			Client: plex.NewClient(config.GetString("plex.url"), config.GetString("plex.port"), config.GetString("plex.playerMachineIdentifier"), config.GetString("plex.playerIP")),
+			TV:     tv,
+		})
+	}
+	if config.GetBool("jellyfin.enabled") {
+		sources = append(sources, JellyfinSource{
+			Client: jellyfin.NewClient(config.GetString("jellyfin.url"), config.GetString("jellyfin.port"), config.GetString("jellyfin.playerMachineIdentifier"), config.GetString("jellyfin.playerIP")),
+			UserID: config.GetString("jellyfin.userID"),
+			TV:     tv,
+		})
+	}
+	return sources
+}
+
+// Last returns the report of the last scan, including one saved before a restart
+func Last() (Report, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	if last != nil {
+		return *last, true
+	}
+	r, err := load(defaultReportPath)
+	if err != nil {
+		return Report{}, false
+	}
+	last = &r
+	return r, true
+}
+
+func save(path string, r Report) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func load(path string) (Report, error) {
+	var r Report
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(b, &r)
+	return r, err
+}
+
+// StartSchedule scans every ezbeq.coverageScanHours in the background. 0 or unset only scans on request
+func StartSchedule() {
+	hours := config.GetInt("ezbeq.coverageScanHours")
+	if hours <= 0 {
+		return
+	}
+	scheduleOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Duration(hours) * time.Hour)
+			defer ticker.Stop()
+			for range ticker.C {
+				if _, err := Run(context.Background()); err != nil {
+					log.Errorf("Scheduled coverage scan failed: %v", err)
+				}
+			}
+		}()
+	})
+}
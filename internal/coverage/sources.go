@@ -0,0 +1,160 @@
+package coverage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/iloveicedgreentea/go-plex/internal/jellyfin"
+	"github.com/iloveicedgreentea/go-plex/internal/plex"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// PlexSource lists the movie libraries of a plex server, and show libraries if TV is set.
+// Shows get one item per season since the catalog has entries per season
+type PlexSource struct {
+	Client *plex.PlexClient
+	TV     bool
+}
+
+func (s PlexSource) Name() string {
+	return "plex"
+}
+
+func (s PlexSource) Titles(ctx context.Context) ([]Item, error) {
+	sections, err := s.Client.GetLibrarySections()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	var errs []error
+	// shows are looked up once for all their seasons
+	shows := make(map[string]models.ShowContainer)
+	for _, sec := range sections.Directory {
+		if sec.Type != plex.SectionMovie && !(s.TV && sec.Type == plex.SectionShow) {
+			continue
+		}
+		lib, err := s.Client.GetLibraryItems(sec.Key, sec.Type)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("library %s: %w", sec.Title, err))
+			continue
+		}
+		log.Debugf("Coverage: reading %d items from plex library %s", len(lib.Video), sec.Title)
+
+		seasons := make(map[string]bool)
+		for _, v := range lib.Video {
+			if err := ctx.Err(); err != nil {
+				return items, err
+			}
+			year, _ := strconv.Atoi(v.Year)
+			it := Item{Source: s.Name(), Title: v.Title, Year: year}
+
+			if sec.Type == plex.SectionShow {
+				key := v.GrandparentKey + "/" + v.ParentIndex
+				if seasons[key] {
+					continue
+				}
+				seasons[key] = true
+
+				show, ok := shows[v.GrandparentKey]
+				if !ok {
+					if show, err = s.Client.GetShowData(v.GrandparentKey); err != nil {
+						log.Warnf("Coverage: could not get show metadata for %s: %v", v.GrandparentTitle, err)
+					}
+					shows[v.GrandparentKey] = show
+				}
+				it.Title = v.GrandparentTitle
+				it.Season, _ = strconv.Atoi(v.ParentIndex)
+				it.Year, _ = strconv.Atoi(show.Directory.Year)
+				it.TMDB = plex.ShowTMDB(show)
+			}
+
+			// the listing does not have the audio streams or ids
+			data, err := s.Client.GetMediaData(v.Key)
+			if err != nil {
+				it.Status = StatusError
+				it.Reason = err.Error()
+				items = append(items, it)
+				continue
+			}
+			// no codec is reported as an empty codec
+			it.Codec, _ = s.Client.GetAudioCodec(data)
+			it.Edition = s.Client.GetEdition(data)
+			if it.Season == 0 {
+				it.TMDB = plex.MediaTMDB(data)
+			}
+			items = append(items, it)
+		}
+	}
+
+	return items, errors.Join(errs...)
+}
+
+// JellyfinSource lists the movies in the user's jellyfin libraries, and episodes if TV is set.
+// Shows get one item per season since the catalog has entries per season
+type JellyfinSource struct {
+	Client *jellyfin.JellyfinClient
+	UserID string
+	TV     bool
+}
+
+func (s JellyfinSource) Name() string {
+	return "jellyfin"
+}
+
+func (s JellyfinSource) Titles(ctx context.Context) ([]Item, error) {
+	types := "Movie"
+	if s.TV {
+		types += ",Episode"
+	}
+	list, err := s.Client.GetLibraryItems(s.UserID, types)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Item
+	series := make(map[string]models.JellyfinMetadata)
+	seasons := make(map[string]bool)
+	for _, data := range list {
+		if err := ctx.Err(); err != nil {
+			return items, err
+		}
+		it := Item{Source: s.Name(), Title: data.OriginalTitle, Year: data.ProductionYear}
+		if it.Title == "" {
+			it.Title = data.Name
+		}
+
+		if data.Type == "Episode" {
+			key := fmt.Sprintf("%s/%d", data.SeriesID, data.ParentIndexNumber)
+			if seasons[key] {
+				continue
+			}
+			seasons[key] = true
+
+			show, ok := series[data.SeriesID]
+			if !ok {
+				if show, err = s.Client.GetMetadata(s.UserID, data.SeriesID); err != nil {
+					log.Warnf("Coverage: could not get series metadata for %s: %v", data.SeriesName, err)
+				}
+				series[data.SeriesID] = show
+			}
+			it.Title = data.SeriesName
+			if show.OriginalTitle != "" {
+				it.Title = show.OriginalTitle
+			}
+			it.Season = data.ParentIndexNumber
+			it.Year = show.ProductionYear
+			data.ExternalUrls = show.ExternalUrls
+		}
+
+		// missing values are reported as missing tmdb or an empty codec
+		it.TMDB, _ = s.Client.GetJfTMDB(data)
+		it.Codec, _ = s.Client.GetAudioCodec(data)
+		it.Edition = s.Client.GetEdition(data)
+		items = append(items, it)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,70 @@
+package ezbeq
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// authorPriority returns the preferred authors for the request, best first. codecs are the codecs searched for, after fallbacks,
+// since the request can have a maybe codec like AtmosMaybe. A per codec family list in ezbeq.authorPriorityByCodec takes precedence over ezbeq.preferredAuthor
+func authorPriority(m *models.SearchRequest, codecs []string) []string {
+	// viper lowercases keys
+	byCodec := config.GetStringMapString("ezbeq.authorPriorityByCodec")
+	for _, codec := range codecs {
+		if v, ok := byCodec[authorFamily(codec)]; ok && hasAuthor(v) {
+			return splitAuthors(v)
+		}
+	}
+	if hasAuthor(m.PreferredAuthor) {
+		return splitAuthors(m.PreferredAuthor)
+	}
+	return nil
+}
+
+// authorFamily is the codec family for author lists. Atmos is on a truehd track, so it uses the truehd list
+func authorFamily(codec string) string {
+	if strings.EqualFold(strings.TrimSpace(codec), "atmos") {
+		return "truehd"
+	}
+	return codecFamily(codec)
+}
+
+// splitAuthors splits a comma separated list of authors
+func splitAuthors(s string) []string {
+	var authors []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); hasAuthor(v) {
+			authors = append(authors, v)
+		}
+	}
+	return authors
+}
+
+// authorRank returns the position of the author in the priority list, or the length of the list if it isn't in it
+func authorRank(authors []string, author string) int {
+	author = strings.ToLower(strings.TrimSpace(author))
+	for i, v := range authors {
+		if v == author {
+			return i
+		}
+	}
+	return len(authors)
+}
+
+// authorReason explains why the author of the winning entry was picked
+func authorReason(authors []string, author string) string {
+	if len(authors) == 0 {
+		return fmt.Sprintf("no preferred authors, using %s", author)
+	}
+	rank := authorRank(authors, author)
+	if rank == len(authors) {
+		return fmt.Sprintf("none of the preferred authors %v have a match, falling back to %s", authors, author)
+	}
+	if rank == 0 {
+		return fmt.Sprintf("%s is the top preferred author", author)
+	}
+	return fmt.Sprintf("%s is preferred author #%d, %v have no better match", author, rank+1, authors[:rank])
+}
@@ -0,0 +1,87 @@
+package ezbeq
+
+import (
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorPriority(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.authorPriorityByCodec", map[string]string{"dts": "mobe1969"})
+	t.Cleanup(func() { config.Set("ezbeq.authorPriorityByCodec", map[string]string{}) })
+
+	type testStruct struct {
+		name     string
+		m        models.SearchRequest
+		expected []string
+	}
+	tt := []testStruct{
+		{name: "preferred order kept", m: models.SearchRequest{Codec: "Atmos", PreferredAuthor: "aron7awol, mobe1969"}, expected: []string{"aron7awol", "mobe1969"}},
+		{name: "none", m: models.SearchRequest{Codec: "Atmos", PreferredAuthor: "None"}, expected: nil},
+		{name: "blank", m: models.SearchRequest{Codec: "Atmos", PreferredAuthor: ""}, expected: nil},
+		{name: "codec family override", m: models.SearchRequest{Codec: "DTS-HD MA 7.1", PreferredAuthor: "aron7awol"}, expected: []string{"mobe1969"}},
+	}
+	for _, tc := range tt {
+		assert.Equal(tc.expected, authorPriority(&tc.m, []string{tc.m.Codec}), tc.name)
+	}
+}
+
+func TestAuthorPriorityResolvedCodec(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.authorPriorityByCodec", map[string]string{"truehd": "bmoney", "dd+": "aron7awol"})
+	t.Cleanup(func() { config.Set("ezbeq.authorPriorityByCodec", map[string]string{}) })
+
+	type testStruct struct {
+		name     string
+		m        models.SearchRequest
+		expected []string
+	}
+	// atmos uses the truehd list, and maybe codecs use the family of their fallbacks
+	tt := []testStruct{
+		{name: "atmos", m: models.SearchRequest{Codec: "Atmos", PreferredAuthor: "mobe1969"}, expected: []string{"bmoney"}},
+		{name: "atmos maybe", m: models.SearchRequest{Codec: "AtmosMaybe", PreferredAuthor: "mobe1969"}, expected: []string{"bmoney"}},
+		{name: "dd+ maybe", m: models.SearchRequest{Codec: "DD+Atmos5.1Maybe", PreferredAuthor: "mobe1969"}, expected: []string{"aron7awol"}},
+		{name: "no list for the family", m: models.SearchRequest{Codec: "LPCM 7.1", PreferredAuthor: "mobe1969"}, expected: []string{"mobe1969"}},
+	}
+	for _, tc := range tt {
+		codecs, err := codecChain(tc.m.Codec)
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.expected, authorPriority(&tc.m, codecs), tc.name)
+	}
+}
+
+func TestMatchCatalogAuthors(t *testing.T) {
+	assert := assert.New(t)
+
+	type testStruct struct {
+		name    string
+		authors string
+		id      string
+		reason  string
+	}
+	// both 12 Strong entries score the same, so the author decides
+	tt := []testStruct{
+		{name: "first author wins", authors: "mobe1969, aron7awol", id: "4", reason: "top preferred"},
+		{name: "order matters", authors: "aron7awol,mobe1969", id: "3", reason: "top preferred"},
+		{name: "second author when first has none", authors: "bmoney, mobe1969", id: "4", reason: "preferred author #2"},
+		{name: "fall back to any author", authors: "bmoney", id: "3", reason: "falling back"},
+		{name: "no preference keeps catalog order", authors: "none", id: "3", reason: "no preferred authors"},
+	}
+	for _, tc := range tt {
+		m := &models.SearchRequest{TMDB: "429351", Year: 2018, Codec: "DTS-HD MA 7.1", PreferredAuthor: tc.authors}
+		res, results, err := matchCatalog(m, testCatalogEntries(), []string{m.Codec})
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.id, res.ID, tc.name)
+		assert.Contains(results[0].AuthorReason, tc.reason, tc.name)
+	}
+
+	// a better match beats a preferred author
+	m := &models.SearchRequest{TMDB: "429351", Year: 2018, Codec: "DTS-HD MA 5.1", PreferredAuthor: "mobe1969"}
+	res, results, err := matchCatalog(m, testCatalogEntries(), []string{m.Codec})
+	assert.NoError(err)
+	assert.Equal("3", res.ID)
+	assert.Contains(results[0].AuthorReason, "falling back")
+}
@@ -0,0 +1,140 @@
+package ezbeq
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling ezbeq while it is considered down
+var ErrCircuitOpen = errors.New("ezbeq is unavailable, circuit breaker is open")
+
+// breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// how many failed calls in a row trip the breaker and how long it stays open before trying again
+var (
+	breakerThreshold = 3
+	breakerCooldown  = 30 * time.Second
+)
+
+// BreakerStatus is the state of the circuit breaker for an ezbeq server
+type BreakerStatus struct {
+	Server    string    `json:"server"`
+	State     string    `json:"state"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"lastError,omitempty"`
+	OpenedAt  time.Time `json:"openedAt,omitempty"`
+}
+
+// breaker stops calls to a server that keeps failing so events fail fast instead of waiting on retries
+type breaker struct {
+	mu        sync.Mutex
+	server    string
+	failures  int
+	lastError string
+	openedAt  time.Time
+	// probing is true while a single call is let through to test if the server is back
+	probing bool
+}
+
+// every client for the same server shares one breaker
+var (
+	breakers   = make(map[string]*breaker)
+	breakersMu sync.Mutex
+)
+
+func getBreaker(server string) *breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[server]
+	if !ok {
+		b = &breaker{server: server}
+		breakers[server] = b
+	}
+	return b
+}
+
+// state must be called with the lock held
+func (b *breaker) state() string {
+	switch {
+	case b.failures < breakerThreshold:
+		return breakerClosed
+	case time.Since(b.openedAt) < breakerCooldown:
+		return breakerOpen
+	default:
+		return breakerHalfOpen
+	}
+}
+
+// allow reports if a call can be made. Once the cooldown is over, one call at a time is let through
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state() {
+	case breakerOpen:
+		return ErrCircuitOpen
+	case breakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures >= breakerThreshold {
+		log.Infof("ezbeq at %s is reachable again, closing circuit breaker", b.server)
+	}
+	b.failures = 0
+	b.lastError = ""
+	b.probing = false
+}
+
+func (b *breaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err.Error()
+	b.probing = false
+	if b.failures >= breakerThreshold {
+		// a failed probe keeps it open for another cooldown
+		b.openedAt = time.Now()
+		if b.failures == breakerThreshold {
+			log.Warnf("ezbeq at %s failed %d times, opening circuit breaker for %v", b.server, b.failures, breakerCooldown)
+		}
+	}
+}
+
+func (b *breaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerStatus{
+		Server:    b.server,
+		State:     b.state(),
+		Failures:  b.failures,
+		LastError: b.lastError,
+	}
+	if s.State != breakerClosed {
+		s.OpenedAt = b.openedAt
+	}
+	return s
+}
+
+// Status returns the circuit breaker state of every ezbeq server in use
+func Status() []BreakerStatus {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	out := make([]BreakerStatus, 0, len(breakers))
+	for _, b := range breakers {
+		out = append(out, b.status())
+	}
+	return out
+}
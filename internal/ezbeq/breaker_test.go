@@ -0,0 +1,160 @@
+package ezbeq
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fastRetries makes retries and the breaker cooldown quick for a test
+func fastRetries(t *testing.T) {
+	attempts, base, max, cooldown := retryAttempts, retryBaseDelay, retryMaxDelay, breakerCooldown
+	retryAttempts, retryBaseDelay, retryMaxDelay, breakerCooldown = 3, time.Millisecond, 5*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() {
+		retryAttempts, retryBaseDelay, retryMaxDelay, breakerCooldown = attempts, base, max, cooldown
+	})
+}
+
+// flakyServer fails the first failures calls with status and records each body it got
+type flakyServer struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	calls    int
+	bodies   []string
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, _ := io.ReadAll(r.Body)
+	f.bodies = append(f.bodies, string(b))
+	f.calls++
+	if f.calls <= f.failures {
+		w.WriteHeader(f.status)
+		return
+	}
+	_, _ = w.Write([]byte("{}"))
+}
+
+func TestRetryRebuildsBody(t *testing.T) {
+	assert := assert.New(t)
+	fastRetries(t)
+
+	srv := &flakyServer{failures: 2, status: http.StatusServiceUnavailable}
+	c := newTestClient(t, srv)
+
+	_, err := c.makeReq(context.Background(), "/api/2/devices/master", []byte(`{"mute":false}`), http.MethodPatch)
+	assert.NoError(err)
+	assert.Equal(3, srv.calls)
+	// every attempt sends the whole payload
+	assert.Equal([]string{`{"mute":false}`, `{"mute":false}`, `{"mute":false}`}, srv.bodies)
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	assert := assert.New(t)
+	fastRetries(t)
+
+	srv := &flakyServer{failures: 10, status: http.StatusNotFound}
+	c := newTestClient(t, srv)
+
+	_, err := c.makeReq(context.Background(), "/api/1/devices/nope", nil, http.MethodGet)
+	assert.Error(err)
+	assert.Equal(1, srv.calls)
+	// the server answered so it is not counted as down
+	assert.Equal(breakerClosed, getBreaker(c.ServerURL+":"+c.Port).status().State)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+	fastRetries(t)
+
+	srv := &flakyServer{failures: retryAttempts * breakerThreshold, status: http.StatusInternalServerError}
+	c := newTestClient(t, srv)
+	b := getBreaker(c.ServerURL + ":" + c.Port)
+
+	for i := 0; i < breakerThreshold; i++ {
+		_, err := c.makeReq(context.Background(), "/api/2/devices", nil, http.MethodGet)
+		assert.Error(err)
+	}
+	assert.Equal(retryAttempts*breakerThreshold, srv.calls)
+	assert.Equal(breakerOpen, b.status().State)
+
+	// fails fast without calling ezbeq
+	_, err := c.makeReq(context.Background(), "/api/2/devices", nil, http.MethodGet)
+	assert.True(errors.Is(err, ErrCircuitOpen))
+	assert.Equal(retryAttempts*breakerThreshold, srv.calls)
+
+	// the status is reported for health checks
+	var found bool
+	for _, s := range Status() {
+		if s.Server == b.server {
+			found = true
+			assert.Equal(breakerOpen, s.State)
+			assert.Contains(s.LastError, "500")
+		}
+	}
+	assert.True(found)
+
+	// after the cooldown a probe goes through and closes it
+	time.Sleep(breakerCooldown)
+	assert.Equal(breakerHalfOpen, b.status().State)
+	_, err = c.makeReq(context.Background(), "/api/2/devices", nil, http.MethodGet)
+	assert.NoError(err)
+	assert.Equal(breakerClosed, b.status().State)
+}
+
+func TestHalfOpenAllowsOneProbe(t *testing.T) {
+	assert := assert.New(t)
+	fastRetries(t)
+
+	b := &breaker{server: "probe"}
+	for i := 0; i < breakerThreshold; i++ {
+		b.failure(errors.New("down"))
+	}
+	assert.ErrorIs(b.allow(), ErrCircuitOpen)
+
+	time.Sleep(breakerCooldown)
+	assert.NoError(b.allow())
+	assert.ErrorIs(b.allow(), ErrCircuitOpen)
+
+	// a failed probe opens it again
+	b.failure(errors.New("still down"))
+	assert.Equal(breakerOpen, b.status().State)
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	assert := assert.New(t)
+	fastRetries(t)
+	retryBaseDelay, retryMaxDelay = time.Second, time.Second
+
+	srv := &flakyServer{failures: 10, status: http.StatusBadGateway}
+	c := newTestClient(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.makeReq(ctx, "/api/2/devices", nil, http.MethodGet)
+	assert.Error(err)
+	assert.Less(time.Since(start), 500*time.Millisecond)
+	assert.Equal(1, srv.calls)
+}
+
+func TestBackoff(t *testing.T) {
+	assert := assert.New(t)
+	for attempt := 1; attempt <= 10; attempt++ {
+		limit := retryBaseDelay << (attempt - 1)
+		if limit > retryMaxDelay {
+			limit = retryMaxDelay
+		}
+		d := backoff(attempt)
+		assert.GreaterOrEqual(d, limit/2)
+		assert.LessOrEqual(d, limit)
+	}
+}
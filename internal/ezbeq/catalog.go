@@ -0,0 +1,352 @@
+package ezbeq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// docker path, same volume as the config
+const defaultCatalogPath = "/data/catalog.json"
+
+// with no filters, search returns every entry in the catalog
+const catalogEndpoint = "/api/1/search"
+
+var (
+	sharedCatalog      *Catalog
+	catalogOnce        sync.Once
+	catalogRefreshOnce sync.Once
+	// searchOnlyCatalog is kept in memory for searches ezbeq can't narrow down when the local catalog is off
+	searchOnlyCatalog   = NewCatalog("")
+	searchOnlyCatalogMu sync.Mutex
+)
+
+// Catalog is a local copy of the ezbeq catalog indexed by tmdb, year, audio type and author
+type Catalog struct {
+	path     string
+	mu       sync.RWMutex
+	entries  []models.BeqCatalog
+	byID     map[string]int
+	byTMDB   map[string][]int
+	byYear   map[int][]int
+	byAudio  map[string][]int
+	byAuthor map[string][]int
+	updated  time.Time
+}
+
+// CatalogQuery filters a catalog search. Empty fields are not filtered on
+type CatalogQuery struct {
+	TMDB string
+	Year int
+	// YearTolerance also matches years this far either side of Year
+	YearTolerance int
+	AudioType     string
+	Authors       []string
+}
+
+// NewCatalog returns an empty catalog which is cached at path
+func NewCatalog(path string) *Catalog {
+	cat := &Catalog{path: path}
+	cat.Load(nil)
+	return cat
+}
+
+// getCatalog returns the catalog shared by every client, loaded from disk if it was cached before
+func getCatalog() *Catalog {
+	catalogOnce.Do(func() {
+		sharedCatalog = NewCatalog(defaultCatalogPath)
+		if err := sharedCatalog.LoadFile(); err != nil {
+			log.Warnf("Could not load cached catalog: %v", err)
+		}
+	})
+	return sharedCatalog
+}
+
+// Load replaces the entries and rebuilds the indexes
+func (cat *Catalog) Load(entries []models.BeqCatalog) {
+	byID := make(map[string]int, len(entries))
+	byTMDB := make(map[string][]int)
+	byYear := make(map[int][]int)
+	byAudio := make(map[string][]int)
+	byAuthor := make(map[string][]int)
+
+	for i, v := range entries {
+		byID[v.ID] = i
+		if v.MovieDbID != "" {
+			byTMDB[v.MovieDbID] = append(byTMDB[v.MovieDbID], i)
+		}
+		byYear[v.Year] = append(byYear[v.Year], i)
+		// some entries have multiple audio types
+		for _, a := range v.AudioTypes {
+			key := strings.ToLower(a)
+			byAudio[key] = append(byAudio[key], i)
+		}
+		author := strings.ToLower(strings.TrimSpace(v.Author))
+		byAuthor[author] = append(byAuthor[author], i)
+	}
+
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+	cat.entries = entries
+	cat.byID = byID
+	cat.byTMDB = byTMDB
+	cat.byYear = byYear
+	cat.byAudio = byAudio
+	cat.byAuthor = byAuthor
+	cat.updated = time.Now()
+}
+
+// LoadFile loads the catalog cached on disk, if there is one
+func (cat *Catalog) LoadFile() error {
+	info, err := os.Stat(cat.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	b, err := os.ReadFile(cat.path)
+	if err != nil {
+		return err
+	}
+	var entries []models.BeqCatalog
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+	cat.Load(entries)
+
+	cat.mu.Lock()
+	cat.updated = info.ModTime()
+	cat.mu.Unlock()
+	log.Infof("Loaded %d catalog entries from %s", len(entries), cat.path)
+
+	return nil
+}
+
+// save caches the catalog on disk so it is available right away after a restart
+func (cat *Catalog) save() error {
+	cat.mu.RLock()
+	b, err := json.Marshal(cat.entries)
+	cat.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cat.path), 0755); err != nil {
+		return err
+	}
+	tmp := cat.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, cat.path)
+}
+
+// Len returns the number of entries
+func (cat *Catalog) Len() int {
+	cat.mu.RLock()
+	defer cat.mu.RUnlock()
+	return len(cat.entries)
+}
+
+// Updated returns when the catalog was last refreshed
+func (cat *Catalog) Updated() time.Time {
+	cat.mu.RLock()
+	defer cat.mu.RUnlock()
+	return cat.updated
+}
+
+// Get returns a single entry by its catalog ID
+func (cat *Catalog) Get(id string) (models.BeqCatalog, bool) {
+	cat.mu.RLock()
+	defer cat.mu.RUnlock()
+	i, ok := cat.byID[id]
+	if !ok {
+		return models.BeqCatalog{}, false
+	}
+	return cat.entries[i], true
+}
+
+// Search returns every entry matching all of the given filters
+func (cat *Catalog) Search(q CatalogQuery) []models.BeqCatalog {
+	cat.mu.RLock()
+	defer cat.mu.RUnlock()
+
+	var sets [][]int
+	if q.TMDB != "" {
+		sets = append(sets, cat.byTMDB[q.TMDB])
+	}
+	if q.Year != 0 {
+		var ids []int
+		for y := q.Year - q.YearTolerance; y <= q.Year+q.YearTolerance; y++ {
+			ids = append(ids, cat.byYear[y]...)
+		}
+		sort.Ints(ids)
+		sets = append(sets, ids)
+	}
+	if q.AudioType != "" {
+		sets = append(sets, cat.byAudio[strings.ToLower(q.AudioType)])
+	}
+	if len(q.Authors) > 0 {
+		// any of the authors will do
+		var ids []int
+		for _, a := range q.Authors {
+			ids = append(ids, cat.byAuthor[strings.ToLower(strings.TrimSpace(a))]...)
+		}
+		sort.Ints(ids)
+		sets = append(sets, ids)
+	}
+
+	if len(sets) == 0 {
+		out := make([]models.BeqCatalog, len(cat.entries))
+		copy(out, cat.entries)
+		return out
+	}
+
+	var out []models.BeqCatalog
+	for _, i := range intersect(sets) {
+		out = append(out, cat.entries[i])
+	}
+	return out
+}
+
+// intersect returns the indexes present in every set, in catalog order
+func intersect(sets [][]int) []int {
+	counts := make(map[int]int)
+	for _, set := range sets {
+		for _, i := range set {
+			counts[i]++
+		}
+	}
+	var out []int
+	for _, i := range sets[0] {
+		if counts[i] == len(sets) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// catalogRefreshInterval is how often the catalog is downloaded again
+func catalogRefreshInterval() time.Duration {
+	hours := config.GetInt("ezbeq.catalogRefreshHours")
+	if hours <= 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// RefreshCatalog downloads the full catalog from ezbeq, indexes it and caches it on disk
+func (c *BeqClient) RefreshCatalog(ctx context.Context) error {
+	if c.Catalog == nil {
+		return errors.New("local catalog is not enabled")
+	}
+	entries, err := c.downloadCatalog(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.Catalog.Load(entries)
+	log.Infof("Refreshed local ezbeq catalog with %d entries", len(entries))
+
+	if err := c.Catalog.save(); err != nil {
+		log.Warnf("Could not cache catalog to disk: %v", err)
+	}
+
+	return nil
+}
+
+// downloadCatalog gets every entry from ezbeq
+func (c *BeqClient) downloadCatalog(ctx context.Context) ([]models.BeqCatalog, error) {
+	url := fmt.Sprintf("%s:%s%s", c.ServerURL, c.Port, catalogEndpoint)
+	log.Debugf("Downloading ezbeq catalog from %s", url)
+
+	// the full catalog is a few MB so give it longer than the usual client
+	client := http.Client{Timeout: 60 * time.Second}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got status: %d downloading catalog", res.StatusCode)
+	}
+
+	var entries []models.BeqCatalog
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("ezbeq returned an empty catalog")
+	}
+
+	return entries, nil
+}
+
+// fullCatalog returns the whole catalog for searches ezbeq can't filter, like episodes without tmdb, when the local catalog is off.
+// It is downloaded once and again only when stale
+func (c *BeqClient) fullCatalog(ctx context.Context) (*Catalog, error) {
+	searchOnlyCatalogMu.Lock()
+	defer searchOnlyCatalogMu.Unlock()
+	cat := searchOnlyCatalog
+	if cat.Len() > 0 && time.Since(cat.Updated()) < catalogRefreshInterval() {
+		return cat, nil
+	}
+
+	entries, err := c.downloadCatalog(ctx)
+	if err != nil {
+		// stale is better than nothing
+		if cat.Len() > 0 {
+			log.Warnf("Could not refresh ezbeq catalog, searching the old copy: %v", err)
+			return cat, nil
+		}
+		return nil, err
+	}
+	cat.Load(entries)
+	log.Infof("Downloaded ezbeq catalog with %d entries for searching by title", len(entries))
+	return cat, nil
+}
+
+// startCatalogRefresh keeps the local catalog up to date in the background. Only the first client runs it
+func (c *BeqClient) startCatalogRefresh() {
+	catalogRefreshOnce.Do(func() {
+		go func() {
+			interval := catalogRefreshInterval()
+			// refresh right away if what we have is missing or stale
+			if c.Catalog.Len() == 0 || time.Since(c.Catalog.Updated()) > interval {
+				if err := c.RefreshCatalog(context.Background()); err != nil {
+					log.Errorf("Error refreshing ezbeq catalog: %v", err)
+				}
+			}
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				if err := c.RefreshCatalog(context.Background()); err != nil {
+					log.Errorf("Error refreshing ezbeq catalog: %v", err)
+				}
+			}
+		}()
+	})
+}
@@ -0,0 +1,156 @@
+package ezbeq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCatalogEntries() []models.BeqCatalog {
+	return []models.BeqCatalog{
+		{ID: "1", Title: "Fast Five", Year: 2011, AudioTypes: []string{"DTS-X"}, MovieDbID: "51497", Author: "aron7awol", Edition: "Extended", MvAdjust: -1.5},
+		{ID: "2", Title: "Fast Five", Year: 2011, AudioTypes: []string{"DTS-HD MA 5.1"}, MovieDbID: "51497", Author: "aron7awol"},
+		{ID: "3", Title: "12 Strong", Year: 2018, AudioTypes: []string{"DTS-HD MA 7.1", "DTS-HD MA 5.1"}, MovieDbID: "429351", Author: "aron7awol", MvAdjust: -3.5},
+		{ID: "4", Title: "12 Strong", Year: 2018, AudioTypes: []string{"DTS-HD MA 7.1"}, MovieDbID: "429351", Author: "mobe1969"},
+		{ID: "5", Title: "Jung_E", Year: 2023, AudioTypes: []string{"DD+ Atmos"}, MovieDbID: "843794", Author: "mobe1969"},
+	}
+}
+
+// newTestClient returns a client pointed at a fake ezbeq server
+func newTestClient(t *testing.T, handler http.Handler) *BeqClient {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	i := strings.LastIndex(srv.URL, ":")
+
+	return &BeqClient{
+		ServerURL: srv.URL[:i],
+		Port:      srv.URL[i+1:],
+	}
+}
+
+func TestCatalogSearch(t *testing.T) {
+	assert := assert.New(t)
+	cat := NewCatalog(filepath.Join(t.TempDir(), "catalog.json"))
+	cat.Load(testCatalogEntries())
+	assert.Equal(5, cat.Len())
+
+	type testStruct struct {
+		q   CatalogQuery
+		ids []string
+	}
+	tt := []testStruct{
+		{q: CatalogQuery{TMDB: "51497"}, ids: []string{"1", "2"}},
+		{q: CatalogQuery{TMDB: "51497", Year: 2011, AudioType: "dts-x"}, ids: []string{"1"}},
+		// entries with multiple audio types are indexed under each
+		{q: CatalogQuery{TMDB: "429351", AudioType: "DTS-HD MA 5.1"}, ids: []string{"3"}},
+		{q: CatalogQuery{TMDB: "429351", AudioType: "DTS-HD MA 7.1"}, ids: []string{"3", "4"}},
+		{q: CatalogQuery{TMDB: "429351", AudioType: "DTS-HD MA 7.1", Authors: []string{" mobe1969"}}, ids: []string{"4"}},
+		{q: CatalogQuery{Authors: []string{"mobe1969", "aron7awol"}, Year: 2018}, ids: []string{"3", "4"}},
+		{q: CatalogQuery{TMDB: "51497", Year: 2012}, ids: nil},
+		{q: CatalogQuery{}, ids: []string{"1", "2", "3", "4", "5"}},
+	}
+	for _, tc := range tt {
+		var ids []string
+		for _, v := range cat.Search(tc.q) {
+			ids = append(ids, v.ID)
+		}
+		assert.Equal(tc.ids, ids, "query %#v", tc.q)
+	}
+
+	e, ok := cat.Get("5")
+	assert.True(ok)
+	assert.Equal("Jung_E", e.Title)
+	_, ok = cat.Get("missing")
+	assert.False(ok)
+}
+
+func TestRefreshCatalog(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "catalog.json")
+
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(catalogEndpoint, r.URL.Path)
+		assert.Empty(r.URL.RawQuery)
+		_ = json.NewEncoder(w).Encode(testCatalogEntries())
+	}))
+	c.Catalog = NewCatalog(path)
+
+	assert.NoError(c.RefreshCatalog(context.Background()))
+	assert.Equal(5, c.Catalog.Len())
+
+	// cached on disk for the next start
+	cached := NewCatalog(path)
+	assert.NoError(cached.LoadFile())
+	assert.Equal(5, cached.Len())
+}
+
+func TestSearchCatalogLocal(t *testing.T) {
+	assert := assert.New(t)
+
+	// local searches should never hit ezbeq
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	c.Catalog = NewCatalog(filepath.Join(t.TempDir(), "catalog.json"))
+	c.Catalog.Load(testCatalogEntries())
+
+	res, err := c.searchCatalog(context.Background(), &models.SearchRequest{
+		TMDB:            "51497",
+		Year:            2011,
+		Codec:           "DTS-X",
+		PreferredAuthor: "none",
+		Edition:         "Extended",
+	})
+	assert.NoError(err)
+	assert.Equal("1", res.ID)
+	assert.Equal(-1.5, res.MvAdjust)
+
+	res, err = c.searchCatalog(context.Background(), &models.SearchRequest{
+		TMDB:            "429351",
+		Year:            2018,
+		Codec:           "DTS-HD MA 7.1",
+		PreferredAuthor: "mobe1969",
+	})
+	assert.NoError(err)
+	assert.Equal("4", res.ID)
+
+	_, err = c.searchCatalog(context.Background(), &models.SearchRequest{
+		TMDB:  "ojdsfojnekfw",
+		Year:  2018,
+		Codec: "DTS-HD MA 5.1",
+	})
+	assert.Error(err)
+}
+
+func TestSearchEpisodeWithoutTMDB(t *testing.T) {
+	assert := assert.New(t)
+	searchOnlyCatalog = NewCatalog("")
+	t.Cleanup(func() { searchOnlyCatalog = NewCatalog("") })
+
+	downloads := 0
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(catalogEndpoint, r.URL.Path)
+		downloads++
+		_ = json.NewEncoder(w).Encode([]models.BeqCatalog{
+			{ID: "1", Title: "The Mandalorian", Year: 2019, AudioTypes: []string{"Atmos"}, Author: "aron7awol", ContentType: "TV", Season: "1"},
+			{ID: "2", Title: "The Mandalorian", Year: 2020, AudioTypes: []string{"Atmos"}, Author: "aron7awol", ContentType: "TV", Season: "2"},
+		})
+	}))
+
+	// every play of an episode searches the catalog, it is only downloaded once
+	for _, season := range []int{1, 2, 2} {
+		res, err := c.searchCatalog(context.Background(), &models.SearchRequest{Title: "The Mandalorian", Year: 2019, Season: season, Episode: 1, Codec: "Atmos"})
+		assert.NoError(err)
+		assert.Equal(fmt.Sprint(season), res.ID)
+	}
+	assert.Equal(1, downloads)
+}
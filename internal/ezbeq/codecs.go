@@ -0,0 +1,61 @@
+package ezbeq
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+)
+
+// defaultCodecFallbacks maps the "maybe" codecs the plex, jellyfin and avr mappers emit to the ezbeq codecs to try, in order.
+// Metadata often can't tell these apart, so the first one with a catalog entry wins
+var defaultCodecFallbacks = map[string][]string{
+	// There are very few truehd 7.1 titles and many atmos titles have wrong metadata. Most of the time, TrueHD 7.1 is Atmos
+	"AtmosMaybe": {"TrueHD 7.1", "Atmos"},
+	// most metadata says DD+ 5.1 or something but its actually DD+ Atmos
+	"DD+Atmos5.1Maybe": {"DD+ Atmos", "DD+ 5.1", "DD+"},
+	"DD+Atmos7.1Maybe": {"DD+ Atmos", "DD+ 7.1", "DD+"},
+	// the AVR only says DD+ without a channel count
+	"DD+AtmosMaybe": {"DD+ Atmos", "DD+"},
+	// some AVRs, like yamaha, don't report channels at all
+	"DTS-HDMAMaybe": {"DTS-HD MA 7.1", "DTS-HD MA 5.1"},
+	"DTS-HDHRMaybe": {"DTS-HD HR 7.1", "DTS-HD HR 5.1"},
+	"LPCMMaybe":     {"LPCM 7.1", "LPCM 5.1"},
+}
+
+// isMaybeCodec is true for codecs which need to be resolved through a fallback chain
+func isMaybeCodec(codec string) bool {
+	return strings.HasSuffix(strings.ToLower(codec), "maybe")
+}
+
+// codecFallbacks returns the fallback table with user overrides from ezbeq.codecFallbacks applied
+func codecFallbacks() map[string][]string {
+	chains := make(map[string][]string, len(defaultCodecFallbacks))
+	for k, v := range defaultCodecFallbacks {
+		chains[strings.ToLower(k)] = v
+	}
+	// viper lowercases keys
+	for k, v := range config.GetStringMapStringSlice("ezbeq.codecFallbacks") {
+		var chain []string
+		for _, codec := range v {
+			if codec = strings.TrimSpace(codec); codec != "" {
+				chain = append(chain, codec)
+			}
+		}
+		if len(chain) > 0 {
+			chains[strings.ToLower(k)] = chain
+		}
+	}
+	return chains
+}
+
+// codecChain returns the codecs to search for, in order of preference. Regular codecs are searched as is
+func codecChain(codec string) ([]string, error) {
+	if chain, ok := codecFallbacks()[strings.ToLower(codec)]; ok {
+		return chain, nil
+	}
+	if isMaybeCodec(codec) {
+		return nil, fmt.Errorf("no codec fallback chain for %s", codec)
+	}
+	return []string{codec}, nil
+}
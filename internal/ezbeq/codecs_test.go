@@ -0,0 +1,88 @@
+package ezbeq
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecChain(t *testing.T) {
+	assert := assert.New(t)
+
+	type testStruct struct {
+		codec    string
+		expected []string
+	}
+	tt := []testStruct{
+		{codec: "AtmosMaybe", expected: []string{"TrueHD 7.1", "Atmos"}},
+		{codec: "DD+Atmos5.1Maybe", expected: []string{"DD+ Atmos", "DD+ 5.1", "DD+"}},
+		{codec: "DD+Atmos7.1Maybe", expected: []string{"DD+ Atmos", "DD+ 7.1", "DD+"}},
+		{codec: "DD+AtmosMaybe", expected: []string{"DD+ Atmos", "DD+"}},
+		{codec: "atmosmaybe", expected: []string{"TrueHD 7.1", "Atmos"}},
+		{codec: "DTS-X", expected: []string{"DTS-X"}},
+	}
+	for _, tc := range tt {
+		chain, err := codecChain(tc.codec)
+		assert.NoError(err, tc.codec)
+		assert.Equal(tc.expected, chain, tc.codec)
+	}
+
+	_, err := codecChain("DTSMaybe")
+	assert.Error(err)
+}
+
+func TestCodecChainOverride(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.codecFallbacks", map[string][]string{
+		"AtmosMaybe": {"Atmos", "TrueHD 7.1"},
+		"DTSMaybe":   {"DTS-X", " DTS-HD MA 7.1 ", ""},
+	})
+	t.Cleanup(func() { config.Set("ezbeq.codecFallbacks", map[string][]string{}) })
+
+	chain, err := codecChain("AtmosMaybe")
+	assert.NoError(err)
+	assert.Equal([]string{"Atmos", "TrueHD 7.1"}, chain)
+
+	chain, err = codecChain("DTSMaybe")
+	assert.NoError(err)
+	assert.Equal([]string{"DTS-X", "DTS-HD MA 7.1"}, chain)
+
+	// defaults which are not overridden stay
+	chain, err = codecChain("DD+AtmosMaybe")
+	assert.NoError(err)
+	assert.Equal([]string{"DD+ Atmos", "DD+"}, chain)
+}
+
+// every default chain resolves to each of its codecs in order
+func TestCodecChainResolution(t *testing.T) {
+	assert := assert.New(t)
+
+	for maybe, chain := range defaultCodecFallbacks {
+		for i, want := range chain {
+			// a catalog with entries for this codec and every codec after it in the chain
+			var entries []models.BeqCatalog
+			for j := len(chain) - 1; j >= i; j-- {
+				entries = append(entries, models.BeqCatalog{ID: chain[j], Title: "Test", Year: 2020, MovieDbID: "1", AudioTypes: []string{chain[j]}, Author: "aron7awol"})
+			}
+			c := &BeqClient{Catalog: NewCatalog(filepath.Join(t.TempDir(), "catalog.json"))}
+			c.Catalog.Load(entries)
+
+			res, err := c.searchCatalog(context.Background(), &models.SearchRequest{TMDB: "1", Year: 2020, Codec: maybe})
+			assert.NoError(err, "%s -> %s", maybe, want)
+			assert.Equal(want, res.ID, "%s -> %s", maybe, want)
+			r, ok := c.lastAccepted()
+			assert.True(ok)
+			assert.Equal(want, r.Codec, "%s -> %s", maybe, want)
+		}
+	}
+
+	// nothing in the chain
+	c := &BeqClient{Catalog: NewCatalog(filepath.Join(t.TempDir(), "catalog.json"))}
+	c.Catalog.Load([]models.BeqCatalog{{ID: "1", Title: "Test", Year: 2020, MovieDbID: "1", AudioTypes: []string{"DTS-X"}}})
+	_, err := c.searchCatalog(context.Background(), &models.SearchRequest{TMDB: "1", Year: 2020, Codec: "AtmosMaybe"})
+	assert.Error(err)
+}
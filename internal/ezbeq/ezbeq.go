@@ -2,19 +2,24 @@ package ezbeq
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/iloveicedgreentea/go-plex/internal/config"
 	"github.com/iloveicedgreentea/go-plex/internal/logger"
 	"github.com/iloveicedgreentea/go-plex/internal/mqtt"
+	"github.com/iloveicedgreentea/go-plex/internal/plan"
+	"github.com/iloveicedgreentea/go-plex/internal/state"
 	"github.com/iloveicedgreentea/go-plex/models"
 )
 
@@ -30,6 +35,12 @@ type BeqClient struct {
 	MasterVolume        float64
 	HTTPClient          http.Client
 	DeviceInfo          []models.BeqDevices
+	State               *state.Store
+	Catalog             *Catalog
+	// LastMatch explains how the candidates of the last search were scored
+	LastMatch []MatchResult
+	// mu guards DeviceInfo and MuteStatus, which are updated live from the ezbeq websocket
+	mu sync.RWMutex
 }
 
 // return a new instance of a plex client
@@ -40,20 +51,110 @@ func NewClient(url, port string) (*BeqClient, error) {
 		HTTPClient: http.Client{
 			Timeout: 5 * time.Second,
 		},
+		State: state.GetStore(),
+	}
+
+	// pick up where we left off if the server was restarted
+	c.restoreState()
+
+	// search a local copy of the catalog instead of asking ezbeq each time
+	if config.GetBool("ezbeq.useLocalCatalog") {
+		c.Catalog = getCatalog()
+		c.startCatalogRefresh()
+	}
+
+	// follow changes made outside of GoWatchIt, like the ezbeq UI or a device reconnecting
+	if config.GetBool("ezbeq.liveDeviceState") {
+		c.watchDevices()
 	}
 
 	// update client with latest metadata from minidsp
-	err := c.GetStatus()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := c.GetStatus(ctx)
 	if err != nil {
 		return c, errors.New("error initializing beq client")
 	}
 	return c, nil
 }
 
+// restoreState loads the last profile from disk into the client
+func (c *BeqClient) restoreState() {
+	if c.State == nil {
+		return
+	}
+	p, ok := c.State.LastProfile()
+	// nothing is loaded anymore, the entry is only kept to reload it quickly
+	if !ok || !p.Loaded {
+		return
+	}
+	log.Debugf("Restoring saved BEQ state: entry %s, mv %v, type %s", p.EntryID, p.MVAdjust, p.MediaType)
+	c.CurrentProfile = p.EntryID
+	c.CurrentMasterVolume = p.MVAdjust
+	c.CurrentMediaType = p.MediaType
+}
+
+// saveProfile persists what was loaded into each device, and the master volume to restore if it was changed
+func (c *BeqClient) saveProfile(m *models.SearchRequest, catalog models.BeqCatalog, previous map[string]float64) {
+	if c.State == nil {
+		return
+	}
+	saved := c.State.GetProfiles()
+	for _, v := range m.Devices {
+		title, author := catalog.Title, catalog.Author
+		// search was skipped so keep what we knew about this entry
+		if prev, ok := saved[v]; ok && catalog.ID == "" && prev.EntryID == m.EntryID {
+			title, author = prev.Title, prev.Author
+		}
+		p := models.ProfileState{
+			EntryID:   m.EntryID,
+			Title:     title,
+			Codec:     m.Codec,
+			Author:    author,
+			MVAdjust:  m.MVAdjust,
+			MediaType: m.MediaType,
+			Slots:     m.Slots,
+			Loaded:    true,
+		}
+		if mv, ok := previous[v]; ok {
+			p.PreviousMasterVolume = &mv
+		}
+		err := c.State.SetProfile(v, p)
+		if err != nil {
+			log.Warnf("Could not save BEQ state for %s: %v", v, err)
+		}
+	}
+}
+
+// loadedTitle returns the title of the entry being loaded. When search was skipped it comes from the saved state
+func (c *BeqClient) loadedTitle(m *models.SearchRequest, catalog models.BeqCatalog) string {
+	if catalog.Title != "" || c.State == nil {
+		return catalog.Title
+	}
+	for _, v := range m.Devices {
+		if p, ok := c.State.GetProfiles()[v]; ok && p.EntryID == m.EntryID {
+			return p.Title
+		}
+	}
+	return ""
+}
+
+// clearProfile marks each device as unloaded on disk
+func (c *BeqClient) clearProfile(m *models.SearchRequest) {
+	if c.State == nil {
+		return
+	}
+	for _, v := range m.Devices {
+		if err := c.State.ClearProfile(v); err != nil {
+			log.Warnf("Could not save BEQ state for %s: %v", v, err)
+		}
+	}
+}
+
 // GetStatus will get metadata from ezbeq and load into client
-func (c *BeqClient) GetStatus() error {
+func (c *BeqClient) GetStatus(ctx context.Context) error {
 	// get all devices
-	res, err := c.makeReq("/api/2/devices", nil, http.MethodGet)
+	res, err := c.makeReq(ctx, "/api/2/devices", nil, http.MethodGet)
 	if err != nil {
 		return err
 	}
@@ -67,10 +168,10 @@ func (c *BeqClient) GetStatus() error {
 	// add devices to client, it returns as a map not list
 	for _, v := range payload {
 		log.Debugf("BEQ device: %#v", v.Name)
-		c.DeviceInfo = append(c.DeviceInfo, v)
+		c.setDevice(v)
 	}
 
-	if len(c.DeviceInfo) == 0 || c.DeviceInfo == nil {
+	if len(c.Devices()) == 0 {
 		return errors.New("no devices found")
 	}
 	log.Debug("c.DeviceInfo is not 0")
@@ -89,9 +190,9 @@ func urlEncode(s string) string {
 }
 
 // MuteCommand sends a mute on/off true = muted, false = not muted
-func (c *BeqClient) MuteCommand(status bool) error {
+func (c *BeqClient) MuteCommand(ctx context.Context, status bool) error {
 	log.Debug("Running mute command")
-	for _, v := range c.DeviceInfo {
+	for _, v := range c.Devices() {
 		endpoint := fmt.Sprintf("/api/1/devices/%s/mute", v.Name)
 		log.Debugf("muting device: %s", endpoint)
 		var method string
@@ -102,7 +203,7 @@ func (c *BeqClient) MuteCommand(status bool) error {
 			method = http.MethodDelete
 		}
 		log.Debugf("Running request with method: %s", method)
-		resp, err := c.makeReq(endpoint, nil, method)
+		resp, err := c.makeReq(ctx, endpoint, nil, method)
 		if err != nil {
 			return err
 		}
@@ -125,10 +226,10 @@ func (c *BeqClient) MuteCommand(status bool) error {
 }
 
 // MakeCommand sends the command of payload
-func (c *BeqClient) MakeCommand(payload []byte) error {
-	for _, v := range c.DeviceInfo {
+func (c *BeqClient) MakeCommand(ctx context.Context, payload []byte) error {
+	for _, v := range c.Devices() {
 		endpoint := fmt.Sprintf("/api/1/devices/%s", v.Name)
-		_, err := c.makeReq(endpoint, payload, http.MethodPatch)
+		_, err := c.makeReq(ctx, endpoint, payload, http.MethodPatch)
 		if err != nil {
 			return err
 		}
@@ -137,89 +238,99 @@ func (c *BeqClient) MakeCommand(payload []byte) error {
 	return nil
 }
 
+// retry settings for ezbeq calls. Delays double each attempt up to the max, with jitter
+var (
+	retryAttempts  = 4
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 4 * time.Second
+)
+
 // generic func for beq requests. Payload should be nil
-func (c *BeqClient) makeReq(endpoint string, payload []byte, methodType string) ([]byte, error) {
-	var setHeader bool
-	var req *http.Request
-	var err error
+func (c *BeqClient) makeReq(ctx context.Context, endpoint string, payload []byte, methodType string) ([]byte, error) {
+	url := fmt.Sprintf("%s:%s%s", c.ServerURL, c.Port, endpoint)
+	b := getBreaker(fmt.Sprintf("%s:%s", c.ServerURL, c.Port))
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
 
-	// log.Debugf("Using method %s", methodType)
-	switch methodType {
-	case http.MethodPut:
-		setHeader = true
-	case http.MethodPatch:
-		setHeader = true
+	var err error
+	for attempt := 1; attempt <= retryAttempts; attempt++ {
+		var res []byte
+		var retry bool
+		res, retry, err = c.doReq(ctx, url, payload, methodType)
+		if err == nil {
+			b.success()
+			return res, nil
+		}
+		if !retry {
+			// ezbeq answered so it is up, the request itself was bad
+			b.success()
+			return res, err
+		}
+		// don't keep the worker waiting if the caller gave up
+		if ctx.Err() != nil {
+			break
+		}
+		if attempt < retryAttempts {
+			delay := backoff(attempt)
+			log.Debugf("ezbeq request to %s failed (attempt %d), retrying in %v: %v", endpoint, attempt, delay, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+		}
 	}
-	// log.Debugf("Header is set to %v", setHeader)
 
-	url := fmt.Sprintf("%s:%s%s", c.ServerURL, c.Port, endpoint)
+	b.failure(err)
+	return nil, fmt.Errorf("ezbeq request to %s failed: %w", endpoint, err)
+}
+
+// doReq makes one call. The request and its body are built fresh each time so a retry sends the whole payload again
+func (c *BeqClient) doReq(ctx context.Context, url string, payload []byte, methodType string) ([]byte, bool, error) {
 	// stupid - https://github.com/golang/go/issues/32897 can't pass a typed nil without panic, because its not an untyped nil
 	// extra check in case you pass in []byte{}
-	if len(payload) == 0 {
-		req, err = http.NewRequest(methodType, url, nil)
-	} else {
-		req, err = http.NewRequest(methodType, url, bytes.NewBuffer(payload))
+	var body io.Reader
+	if len(payload) > 0 {
+		body = bytes.NewReader(payload)
 	}
+	req, err := http.NewRequestWithContext(ctx, methodType, url, body)
 	if err != nil {
-		return []byte{}, err
+		return nil, false, err
 	}
-
-	if setHeader {
+	switch methodType {
+	case http.MethodPut, http.MethodPatch:
 		req.Header.Set("Content-Type", "application/json")
 	}
-	// log.Debugf("Using url %s", req.URL)
-	// log.Debugf("Headers from req %v", req.Header)
-	// simple retry
-	res, err := c.makeCallWithRetry(req, 20, endpoint)
-
-	return res, err
-}
-
-// makeCallWithRetry returns response body and err
-func (c *BeqClient) makeCallWithRetry(req *http.Request, maxRetries int, endpoint string) ([]byte, error) {
-	// declaring here so we can return err outside of loop just by exiting it
-	var status int
-	var res *http.Response
-	var resp []byte
-	var err error
-
-	for i := 0; i < maxRetries; i++ {
-		res, err = c.HTTPClient.Do(req)
-		if err != nil {
-			log.Debugf("Error with request - Retrying %v", err)
-			time.Sleep(time.Second * 2)
-			continue
-		}
-		defer res.Body.Close()
-
-		resp, err = io.ReadAll(res.Body)
-		if err != nil {
-			log.Debugf("Reading body failed - Retrying %v", err)
-			time.Sleep(time.Second * 2)
-			continue
-		}
 
-		status = res.StatusCode
-
-		if status != http.StatusOK {
-			return nil, fmt.Errorf("got status: %d -- error from body is %v", status, string(resp))
-		}
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer res.Body.Close()
 
-		// don't retry for 404
-		if status == 404 {
-			return resp, fmt.Errorf("404 for %s", endpoint)
-		}
+	resp, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, true, err
+	}
 
-		if status >= 204 && status != 404 {
-			log.Debug(string(resp), status)
-			log.Debug("Retrying request...")
-			err = fmt.Errorf("error in response: %v", res.Status)
-			time.Sleep(time.Second * 2)
-			continue
-		}
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return resp, false, nil
+	// ezbeq is busy or broken, try again
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return nil, true, fmt.Errorf("got status: %d -- error from body is %v", res.StatusCode, string(resp))
+	default:
+		return resp, false, fmt.Errorf("got status: %d -- error from body is %v", res.StatusCode, string(resp))
 	}
+}
 
-	return resp, err
+// backoff returns the exponential delay for the attempt with jitter, so clients don't retry in lockstep
+func backoff(attempt int) time.Duration {
+	limit := retryBaseDelay << (attempt - 1)
+	if limit <= 0 || limit > retryMaxDelay {
+		limit = retryMaxDelay
+	}
+	return limit/2 + time.Duration(rand.Int63n(int64(limit/2)+1))
 }
 
 // authorCompare returns true if there is an author
@@ -228,96 +339,129 @@ func hasAuthor(s string) bool {
 	return hasAuthor != "none" && hasAuthor != ""
 }
 
-// buildAuthorWhitelist returns a string of authors to search for
-func buildAuthorWhitelist(preferredAuthors string, endpoint string) string {
-	authors := strings.Split(preferredAuthors, ",")
-	for _, v := range authors {
-		endpoint += fmt.Sprintf("&authors=%s", strings.TrimSpace(v))
-	}
-	return endpoint
+// searchCatalog will use ezbeq to search the catalog and then find the right match. tmdb data comes from plex, matched to ezbeq catalog
+func (c *BeqClient) searchCatalog(ctx context.Context, m *models.SearchRequest) (models.BeqCatalog, error) {
+	catalog, results, err := c.matchEntry(ctx, m)
+	// keep the explanation around so a missing profile can be looked into
+	c.LastMatch = results
+
+	return catalog, err
 }
 
-// searchCatalog will use ezbeq to search the catalog and then find the right match. tmdb data comes from plex, matched to ezbeq catalog
-func (c *BeqClient) searchCatalog(m *models.SearchRequest) (models.BeqCatalog, error) {
-	// url encode because of spaces and stuff
-	code := urlEncode(m.Codec)
-	endpoint := fmt.Sprintf("/api/1/search?audiotypes=%s&years=%d&tmdbid=%s", code, m.Year, m.TMDB)
+// matchEntry finds the candidates for m and picks the best one
+func (c *BeqClient) matchEntry(ctx context.Context, m *models.SearchRequest) (models.BeqCatalog, []MatchResult, error) {
+	payload, err := c.findCandidates(ctx, m)
+	if err != nil {
+		return models.BeqCatalog{}, nil, err
+	}
 
-	// this is an author whitelist for each non-empty author append it to search
-	if hasAuthor(m.PreferredAuthor) {
-		endpoint = buildAuthorWhitelist(m.PreferredAuthor, endpoint)
+	// maybe codecs are resolved through their fallback chain
+	codecs, err := codecChain(m.Codec)
+	if err != nil {
+		return models.BeqCatalog{}, nil, err
 	}
-	log.Debugf("sending ezbeq search request to %s", endpoint)
 
-	var payload []models.BeqCatalog
-	res, err := c.makeReq(endpoint, nil, http.MethodGet)
+	catalog, results, err := matchCatalog(m, payload, codecs)
+	plan.Record(ctx, plan.Candidates, fmt.Sprintf("%d candidates for %s (%d) with codecs %v", len(payload), m.Title, m.Year, codecs), results)
+
+	return catalog, results, err
+}
+
+// FindEntry returns the entry that would be loaded for m without loading it. Overrides and pins apply like for a load
+func (c *BeqClient) FindEntry(ctx context.Context, m *models.SearchRequest) (models.BeqCatalog, error) {
+	forced, isForced, err := c.applyOverride(m)
 	if err != nil {
 		return models.BeqCatalog{}, err
 	}
+	if isForced {
+		return forced, nil
+	}
+	if pinned, ok := c.pinnedEntry(m); ok {
+		return pinned, nil
+	}
+	catalog, _, err := c.matchEntry(ctx, m)
+	return catalog, err
+}
 
-	err = json.Unmarshal(res, &payload)
-	if err != nil {
-		return models.BeqCatalog{}, fmt.Errorf("error: %v // response: %v", err, string(res))
+// lastAccepted returns the entry picked by the last search, if any
+func (c *BeqClient) lastAccepted() (MatchResult, bool) {
+	for _, r := range c.LastMatch {
+		if r.Accepted {
+			return r, true
+		}
 	}
+	return MatchResult{}, false
+}
 
-	// search through results and find match
-	for _, val := range payload {
-		// if skipping TMDB, set the IDs to match
-		if config.GetBool("jellyfin.skiptmdb") {
-			if m.Title == "" {
-				return models.BeqCatalog{}, errors.New("title is blank, can't skip TMDB")
-			}
-			log.Debug("Skipping TMDB for search")
-			val.MovieDbID = m.TMDB
-			if !strings.EqualFold(val.Title, m.Title) {
-				log.Debugf("%s did not match with title %s", val.Title, m.Title)
-				continue
-			}
-			log.Debugf("%s matched with title %s", val.Title, m.Title)
+// lastAuthorReason explains why the author of the last match was picked
+func (c *BeqClient) lastAuthorReason() string {
+	if r, ok := c.lastAccepted(); ok {
+		return r.AuthorReason
+	}
+	return "no match"
+}
+
+// findCandidates returns the catalog entries which could match, from the local catalog if it is loaded or from ezbeq otherwise.
+// It is deliberately loose and returns every author, matchCatalog does the scoring and ranks authors
+func (c *BeqClient) findCandidates(ctx context.Context, m *models.SearchRequest) ([]models.BeqCatalog, error) {
+	// without tmdb, search the years around the release and match on title.
+	// Seasons come out in different years, so episodes without tmdb search everything
+	byTMDB := m.TMDB != "" && !config.GetBool("jellyfin.skiptmdb")
+	byYear := !byTMDB && m.Season == 0
+	tolerance := yearTolerance()
+
+	if c.Catalog != nil && c.Catalog.Len() > 0 {
+		var q CatalogQuery
+		if byTMDB {
+			q.TMDB = m.TMDB
+		} else if byYear {
+			q.Year = m.Year
+			q.YearTolerance = tolerance
 		}
-		log.Debugf("Beq results: Title: %v // Codec %v, ID: %v", val.Title, val.AudioTypes, val.ID)
-		// if we find a match, return it. Much easier to match on tmdb since plex provides it also
-		var audioMatch bool
-		// rationale here is some BEQ entries have multiple audio types in one entry
-		for _, v := range val.AudioTypes {
-			if strings.EqualFold(v, m.Codec) {
-				audioMatch = true
-				break
-			}
+		log.Debugf("searching local catalog with %#v", q)
+		return c.Catalog.Search(q), nil
+	}
+
+	// ezbeq can't search by title, so use a copy of the catalog instead of downloading it every time
+	if !byTMDB && !byYear {
+		cat, err := c.fullCatalog(ctx)
+		if err != nil {
+			return nil, err
 		}
-		if val.MovieDbID == m.TMDB && val.Year == m.Year && audioMatch {
-			log.Debugf("%s matched with codecs %v, checking further", val.Title, val.AudioTypes)
-			// if it matches, check edition
-			if checkEdition(val, m.Edition) {
-				log.Infof("Found a match in catalog from author %s", val.Author)
-				return val, nil
-			} else {
-				log.Errorf("Found a potential match but editions did not match entry. Not loading")
-			}
+		return cat.Search(CatalogQuery{}), nil
+	}
+
+	var endpoint string
+	switch {
+	case byTMDB:
+		endpoint = fmt.Sprintf("/api/1/search?tmdbid=%s", urlEncode(m.TMDB))
+	default:
+		endpoint = "/api/1/search?"
+		for y := m.Year - tolerance; y <= m.Year+tolerance; y++ {
+			endpoint += fmt.Sprintf("years=%d&", y)
 		}
+		endpoint = strings.TrimSuffix(endpoint, "&")
 	}
 
-	return models.BeqCatalog{}, errors.New("beq profile was not found in catalog")
-}
+	log.Debugf("sending ezbeq search request to %s", endpoint)
 
-// map to Unrated, Ultimate, Theatrical, Extended, Director, Criterion
-func checkEdition(val models.BeqCatalog, edition string) bool {
-	// if edition from beq is empty, any match will do
-	if val.Edition == "" {
-		return true
+	var payload []models.BeqCatalog
+	res, err := c.makeReq(ctx, endpoint, nil, http.MethodGet)
+	if err != nil {
+		return nil, err
 	}
 
-	// if the beq edition contains the string like Extended for "Extended Cut", its ok
-	if strings.Contains(val.Edition, edition) {
-		return true
+	err = json.Unmarshal(res, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("error: %v // response: %v", err, string(res))
 	}
 
-	return false
+	return payload, nil
 }
 
 // Edition support doesn't seem important ATM, might revisit later
 // LoadBeqProfile will load a profile into slot 1. If skipSearch true, rest of the params will be used (good for quick reload)
-func (c *BeqClient) LoadBeqProfile(m *models.SearchRequest) error {
+func (c *BeqClient) LoadBeqProfile(ctx context.Context, m *models.SearchRequest) error {
 	if !config.GetBool("ezbeq.enabled") {
 		log.Debug("BEQ is disabled, skipping")
 		return nil
@@ -334,65 +478,58 @@ func (c *BeqClient) LoadBeqProfile(m *models.SearchRequest) error {
 	var catalog models.BeqCatalog
 
 	// if provided stuff is blank, we cant skip search
-	if m.EntryID == "" || m.MVAdjust == 0 {
+	if m.EntryID == "" {
 		m.SkipSearch = false
 	}
 
-	// skip searching when resuming for speed
+	// the override table comes first, it can block the title or change what is searched for
+	var forced models.BeqCatalog
+	var isForced bool
 	if !m.SkipSearch {
-		// if AtmosMaybe, check if its really truehd 7.1. If fails, its atmos
-		if m.Codec == "AtmosMaybe" {
-			m.Codec = "TrueHD 7.1"
-			catalog, err = c.searchCatalog(m)
-			if err != nil {
-				m.Codec = "Atmos"
-				catalog, err = c.searchCatalog(m)
-				if err != nil {
-					return err
-				}
-			}
-			// most metadata contains DD+5.1 or something but its actually DD+ Atmos, so try a few options
-		} else if m.Codec == "DD+Atmos5.1Maybe" {
-			m.Codec = "DD+ Atmos"
-			catalog, err = c.searchCatalog(m)
-			// else try DD+ 5.1
-			if err != nil {
-				m.Codec = "DD+ 5.1"
-				catalog, err = c.searchCatalog(m)
-				if err != nil {
-					m.Codec = "DD+"
-					catalog, err = c.searchCatalog(m)
-					if err != nil {
-						return err
-					}
-				}
-			}
-		} else if m.Codec == "DD+Atmos7.1Maybe" {
-			m.Codec = "DD+ Atmos"
-			catalog, err = c.searchCatalog(m)
-			// else try DD+ 7.1
-			if err != nil {
-				m.Codec = "DD+ 7.1"
-				catalog, err = c.searchCatalog(m)
-				if err != nil {
-					m.Codec = "DD+"
-					catalog, err = c.searchCatalog(m)
-					if err != nil {
-						return err
-					}
-				}
-			}
-		} else {
-			catalog, err = c.searchCatalog(m)
-			if err != nil {
-				return err
-			}
+		forced, isForced, err = c.applyOverride(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	pinned, isPinned := c.pinnedEntry(m)
+	// how the entry was chosen, for the dry run
+	source := "search"
+	switch {
+	// skip searching when resuming for speed
+	case m.SkipSearch:
+		log.Debug("Skipping search for extra speed")
+		source = "given entry"
+		// fill in the title and author for the state and notifications if we can
+		if e, ok := c.Entry(m.EntryID); ok {
+			catalog = e
+		}
+	case isForced:
+		source = "override"
+		catalog = forced
+		m.EntryID = forced.ID
+		m.MVAdjust = forced.MvAdjust
+	// an entry picked by hand for this title wins over the search
+	case isPinned:
+		log.Infof("Using entry %s pinned to TMDB %s", pinned.ID, m.TMDB)
+		source = "pin"
+		catalog = pinned
+		m.EntryID = pinned.ID
+		m.MVAdjust = pinned.MvAdjust
+	default:
+		// maybe codecs like AtmosMaybe are tried in the order of their fallback chain
+		catalog, err = c.searchCatalog(ctx, m)
+		if err != nil {
+			return err
+		}
+		// use the codec that actually matched from now on
+		if r, ok := c.lastAccepted(); ok && r.Codec != "" {
+			m.Codec = r.Codec
 		}
 		// get the values from catalog search
 		m.EntryID = catalog.ID
 		m.MVAdjust = catalog.MvAdjust
-	} else {
-		log.Debug("Skipping search for extra speed")
+		log.Infof("Picked %s by %s: %s", catalog.Title, catalog.Author, c.lastAuthorReason())
 	}
 
 	// save the current stuff for later, used in media.resume
@@ -405,11 +542,23 @@ func (c *BeqClient) LoadBeqProfile(m *models.SearchRequest) error {
 	}
 
 	if m.DryrunMode {
-		return fmt.Errorf("BEQ Dry run msg - Would load title %s -- codec %s -- edition: %s, ezbeq entry ID %s - author %s", catalog.Title, m.Codec, catalog.Edition, m.EntryID, catalog.Author)
+		msg := fmt.Sprintf("BEQ Dry run msg - Would load title %s -- codec %s -- edition: %s, ezbeq entry ID %s - author %s (%s)", catalog.Title, m.Codec, catalog.Edition, m.EntryID, catalog.Author, c.lastAuthorReason())
+		if plan.Record(ctx, plan.Entry, fmt.Sprintf("load entry %s (%s) into slots %v on %v, from %s", m.EntryID, catalog.Title, m.Slots, m.Devices, source), catalog) {
+			return nil
+		}
+		return errors.New(msg)
+	}
+
+	// the entry's offset goes on the master volume instead of the input gain, if enabled
+	applyMV := config.GetBool("ezbeq.applyMasterVolume")
+	gain := m.MVAdjust
+	if applyMV {
+		gain = 0
 	}
 
 	// build payload
-	var payload models.BeqPatchV2
+	mute := false
+	payload := models.BeqPatchV2{Mute: &mute}
 	// for len m.Slots, add that many slots
 	// if no slots, add one so it doesnt error
 	if len(m.Slots) == 0 {
@@ -419,39 +568,67 @@ func (c *BeqClient) LoadBeqProfile(m *models.SearchRequest) error {
 		// append a slot to payload for each
 		payload.Slots = append(payload.Slots, models.SlotsV2{
 			ID:     strconv.Itoa(k),
-			Gains:  []float64{m.MVAdjust, m.MVAdjust},
+			Gains:  []float64{gain, gain},
 			Active: true,
 			Mutes:  []bool{false, false},
 			Entry:  m.EntryID,
 		})
 	}
-	log.Debugf("sending BEQ payload: %#v", payload)
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
 
 	// write payload to each device
+	previous := make(map[string]float64)
 	for _, v := range m.Devices {
+		devicePayload := payload
+		var target float64
+		if applyMV {
+			prev, t, err := c.masterVolumeTarget(ctx, v, m.MVAdjust)
+			if err != nil {
+				return fmt.Errorf("could not get master volume for %s: %v", v, err)
+			}
+			target = t
+			devicePayload.MasterVolume = &target
+			previous[v] = prev
+		}
+		log.Debugf("sending BEQ payload: %#v", devicePayload)
+		jsonPayload, err := json.Marshal(devicePayload)
+		if err != nil {
+			return err
+		}
+
 		endpoint := fmt.Sprintf("/api/2/devices/%s", v)
-		_, err = c.makeReq(endpoint, jsonPayload, http.MethodPatch)
+		_, err = c.makeReq(ctx, endpoint, jsonPayload, http.MethodPatch)
 		if err != nil {
 			log.Debugf("json payload %v", string(jsonPayload))
 			log.Debugf("using endpoint %s", endpoint)
 			return err
 		}
+		if applyMV {
+			log.Infof("Set master volume of %s to %v (was %v)", v, target, previous[v])
+			if err := publishMasterVolume(v, target); err != nil {
+				log.Errorf("Error publishing master volume: %v", err)
+			}
+		}
+	}
+	c.saveProfile(m, catalog, previous)
+
+	// make sure every slot actually has the entry
+	err = c.verifySlots(ctx, m, c.loadedTitle(m, catalog), false)
+	publishVerifyState(false, err)
+	if err != nil {
+		return err
 	}
 
 	return mqtt.PublishWrapper(config.GetString("mqtt.topicBeqCurrentProfile"), fmt.Sprintf("%s: %s by %s", catalog.Title, m.Codec, catalog.Author))
 }
 
 // UnloadBeqProfile will unload all profiles from all devices
-func (c *BeqClient) UnloadBeqProfile(m *models.SearchRequest) error {
+func (c *BeqClient) UnloadBeqProfile(ctx context.Context, m *models.SearchRequest) error {
 	if !config.GetBool("ezbeq.enabled") {
 		log.Debug("BEQ is disabled, skipping")
 		return nil
 	}
 	if m.DryrunMode {
+		plan.Record(ctx, plan.Ezbeq, fmt.Sprintf("unload slots %v on %v", m.Slots, m.Devices), nil)
 		return nil
 	}
 	log.Debug("Unloading ezBEQ profiles")
@@ -460,12 +637,24 @@ func (c *BeqClient) UnloadBeqProfile(m *models.SearchRequest) error {
 		for _, k := range m.Slots {
 			endpoint := fmt.Sprintf("/api/1/devices/%s/filter/%v", v, k)
 			log.Debugf("using endpoint %s", endpoint)
-			_, err := c.makeReq(endpoint, nil, http.MethodDelete)
+			_, err := c.makeReq(ctx, endpoint, nil, http.MethodDelete)
 			if err != nil {
 				return err
 			}
 		}
 	}
+	// put back the master volume if the profile changed it
+	if err := c.restoreMasterVolume(ctx, m); err != nil {
+		return err
+	}
+
+	// a half applied unload is not safe, keep the state so it can be unloaded again
+	err := c.verifySlots(ctx, m, "", true)
+	publishVerifyState(true, err)
+	if err != nil {
+		return err
+	}
+	c.clearProfile(m)
 
 	return mqtt.PublishWrapper(config.GetString("mqtt.topicBeqCurrentProfile"), "")
 }
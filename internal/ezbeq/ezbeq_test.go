@@ -1,11 +1,14 @@
 package ezbeq
 
 import (
+	"context"
 	"strings"
 	"fmt"
+	"path/filepath"
 	"testing"
 
 	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/state"
 	"github.com/iloveicedgreentea/go-plex/models"
 	"github.com/stretchr/testify/assert"
 )
@@ -18,8 +21,8 @@ func TestMuteCmds(t *testing.T) {
 	assert.NoError(err)
 
 	// send mute commands
-	assert.NoError(c.MuteCommand(true))
-	assert.NoError(c.MuteCommand(false))
+	assert.NoError(c.MuteCommand(context.Background(), true))
+	assert.NoError(c.MuteCommand(context.Background(), false))
 }
 func TestGetStatus(t *testing.T) {
 	c := &BeqClient{
@@ -31,7 +34,7 @@ func TestGetStatus(t *testing.T) {
 	assert.NotEmpty(t, c.Port)
 	assert.NotEmpty(t, c.ServerURL)
 
-	err := c.GetStatus()
+	err := c.GetStatus(context.Background())
 	assert.NoError(t, err)
 	assert.NotEmpty(t, c.DeviceInfo)
 
@@ -410,12 +413,6 @@ func TestHasAuthor(t *testing.T) {
 	}
 }
 
-func TestBuildAuthorWhitelist(t *testing.T) {
-
-	s := buildAuthorWhitelist("aron7awol, mobe1969", "/api/1/search?audiotypes=dts-x&years=2011&tmdbid=12345")
-	assert.Equal(t, "/api/1/search?audiotypes=dts-x&years=2011&tmdbid=12345&authors=aron7awol&authors=mobe1969", s)
-}
-
 func TestSearchCatalog(t *testing.T) {
 	assert := assert.New(t)
 
@@ -514,8 +511,7 @@ func TestSearchCatalog(t *testing.T) {
 				Edition:         "",
 			},
 			expectedEdition: "",
-			expectedDigest:  "c694bb4c1f67903aebc51998cd1aae417983368e784ed04bf92d873ee1ca213d",
-			expectedMvAdjust: -3.5,
+			expectedDigest:  "73a1eef9ce33abba7df0a9d2b4cec41254f6a521d521e104fa3cd2e7297c26d9",
 		},
 		{
 			// return 7.1 version with mutliple authors
@@ -601,14 +597,14 @@ func TestSearchCatalog(t *testing.T) {
 	for _, tc := range tt {
 		tc := tc
 		// should be TrueHD 7.1
-		res, err := c.searchCatalog(&tc.m)
+		res, err := c.searchCatalog(context.Background(), &tc.m)
 		assert.NoError(err)
 		assert.Equal(tc.expectedDigest, res.Digest, fmt.Sprintf("digest did not match %s", res.Digest))
 		assert.Equal(tc.expectedEdition, res.Edition, fmt.Sprintf("edition did not match %s", res.Digest))
 		assert.Equal(tc.expectedMvAdjust, res.MvAdjust, fmt.Sprintf("MV did not match %s", res.Digest))
 	}
 
-	_, err = c.searchCatalog(&models.SearchRequest{
+	_, err = c.searchCatalog(context.Background(), &models.SearchRequest{
 		TMDB:            "ojdsfojnekfw",
 		Year:            2018,
 		Codec:           "DTS-HD MA 5.1",
@@ -703,10 +699,10 @@ func TestLoadProfile(t *testing.T) {
 
 	for _, tc := range tt {
 		tc := tc
-		err = c.LoadBeqProfile(&tc)
+		err = c.LoadBeqProfile(context.Background(), &tc)
 		assert.NoError(err)
 
-		err = c.UnloadBeqProfile(&tc)
+		err = c.UnloadBeqProfile(context.Background(), &tc)
 		assert.NoError(err)
 	}
 
@@ -714,4 +710,25 @@ func TestLoadProfile(t *testing.T) {
 
 func TestTitleCom(t *testing.T) {
 	assert.True(t, strings.EqualFold("American Sniper", ""))
-}
\ No newline at end of file
+}
+
+func TestRestoreState(t *testing.T) {
+	assert := assert.New(t)
+	store, err := state.NewStore(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(err)
+	assert.NoError(store.SetProfile("master", models.ProfileState{EntryID: "123", MVAdjust: -1.5, MediaType: "movie", Loaded: true}))
+
+	c := &BeqClient{State: store}
+	c.restoreState()
+	assert.Equal("123", c.CurrentProfile)
+	assert.Equal(-1.5, c.CurrentMasterVolume)
+	assert.Equal("movie", c.CurrentMediaType)
+
+	// unloaded before the restart, so there is nothing to restore
+	assert.NoError(store.ClearProfile("master"))
+	c = &BeqClient{State: store}
+	c.restoreState()
+	assert.Empty(c.CurrentProfile)
+	assert.Zero(c.CurrentMasterVolume)
+	assert.Empty(c.CurrentMediaType)
+}
@@ -0,0 +1,271 @@
+package ezbeq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/mqtt"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// ezbeq sends the full state of a device over its websocket whenever anything on it changes
+const (
+	wsEndpoint         = "/ws"
+	deviceStateMessage = "DeviceState"
+)
+
+// wsMessage is a message from the ezbeq websocket
+type wsMessage struct {
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// stateChange is an mqtt message to send because a device changed
+type stateChange struct {
+	Topic   string
+	Payload string
+}
+
+// deviceWatcher follows the ezbeq websocket for one server and keeps every client using that server up to date
+type deviceWatcher struct {
+	mu      sync.Mutex
+	server  string
+	clients []*BeqClient
+	devices map[string]models.BeqDevices
+	once    sync.Once
+}
+
+var (
+	watchers   = make(map[string]*deviceWatcher)
+	watchersMu sync.Mutex
+)
+
+func getWatcher(server string) *deviceWatcher {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+	w, ok := watchers[server]
+	if !ok {
+		w = &deviceWatcher{server: server, devices: make(map[string]models.BeqDevices)}
+		watchers[server] = w
+	}
+	return w
+}
+
+// Devices returns a copy of the devices the client knows about
+func (c *BeqClient) Devices() []models.BeqDevices {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]models.BeqDevices(nil), c.DeviceInfo...)
+}
+
+// setDevice adds or replaces a device by name
+func (c *BeqClient) setDevice(d models.BeqDevices) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d.Name == "" {
+		return
+	}
+	for i, v := range c.DeviceInfo {
+		if v.Name == d.Name {
+			c.DeviceInfo[i] = d
+			c.MuteStatus = d.Mute
+			return
+		}
+	}
+	c.DeviceInfo = append(c.DeviceInfo, d)
+	c.MuteStatus = d.Mute
+}
+
+// watchDevices keeps the client's devices in sync with ezbeq. Every client for the same server shares one connection
+func (c *BeqClient) watchDevices() {
+	w := getWatcher(fmt.Sprintf("%s:%s", c.ServerURL, c.Port))
+	w.mu.Lock()
+	w.clients = append(w.clients, c)
+	w.mu.Unlock()
+
+	w.once.Do(func() {
+		go w.run(context.Background())
+	})
+}
+
+// client returns a client still registered with the watcher, or nil if they have all closed
+func (w *deviceWatcher) client() *BeqClient {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.clients) == 0 {
+		return nil
+	}
+	return w.clients[0]
+}
+
+// Close stops the client from following device changes, call it before dropping a client
+func (c *BeqClient) Close() {
+	watchersMu.Lock()
+	w, ok := watchers[fmt.Sprintf("%s:%s", c.ServerURL, c.Port)]
+	watchersMu.Unlock()
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, v := range w.clients {
+		if v == c {
+			w.clients = append(w.clients[:i], w.clients[i+1:]...)
+			return
+		}
+	}
+}
+
+// run listens until ctx is done, reconnecting with backoff when the connection drops
+func (w *deviceWatcher) run(ctx context.Context) {
+	attempt := 0
+	for {
+		connected, err := w.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			attempt = 0
+		}
+		attempt++
+		delay := backoff(attempt)
+		log.Warnf("ezbeq websocket at %s disconnected, reconnecting in %v: %v", w.server, delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// wsURL returns the websocket url for an ezbeq server
+func wsURL(server string) string {
+	switch {
+	case strings.HasPrefix(server, "https://"):
+		server = "wss://" + strings.TrimPrefix(server, "https://")
+	case strings.HasPrefix(server, "http://"):
+		server = "ws://" + strings.TrimPrefix(server, "http://")
+	}
+	return server + wsEndpoint
+}
+
+// listen reads device state until the connection fails. It reports if it managed to connect
+func (w *deviceWatcher) listen(ctx context.Context) (bool, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL(w.server), nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	// unblock the read when we are asked to stop
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	log.Infof("Connected to ezbeq websocket at %s", w.server)
+
+	// changes may have been missed while disconnected, resync through whichever client is still around
+	if c := w.client(); c == nil {
+		log.Debug("No ezbeq clients left, skipping resync")
+	} else if devices, err := c.getDevices(ctx); err != nil {
+		log.Warnf("Could not resync ezbeq devices: %v", err)
+	} else {
+		for _, d := range devices {
+			w.update(d)
+		}
+	}
+
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return true, err
+		}
+		if msg.Message != deviceStateMessage {
+			continue
+		}
+		var d models.BeqDevices
+		if err := json.Unmarshal(msg.Data, &d); err != nil {
+			log.Debugf("Could not parse ezbeq device state: %v", err)
+			continue
+		}
+		w.update(d)
+	}
+}
+
+// update stores the new state of a device, passes it to every client and publishes what changed
+func (w *deviceWatcher) update(d models.BeqDevices) {
+	w.mu.Lock()
+	prev, known := w.devices[d.Name]
+	w.devices[d.Name] = d
+	clients := append([]*BeqClient(nil), w.clients...)
+	w.mu.Unlock()
+
+	for _, c := range clients {
+		c.setDevice(d)
+	}
+	// the first state seen is the baseline
+	if !known || len(clients) == 0 {
+		return
+	}
+
+	for _, ch := range deviceChanges(prev, d, clients[0].loadedProfileTitle(d.Name)) {
+		log.Debugf("ezbeq device %s changed, publishing %s to %s", d.Name, ch.Payload, ch.Topic)
+		if err := mqtt.PublishWrapper(ch.Topic, ch.Payload); err != nil {
+			log.Errorf("Error publishing ezbeq device state: %v", err)
+		}
+	}
+}
+
+// deviceChanges returns the mqtt messages for what differs between two states of a device, leaving out topics which are not set.
+// loaded is the title GoWatchIt loaded itself, which was already published with more detail
+func deviceChanges(prev, cur models.BeqDevices, loaded string) []stateChange {
+	var changes []stateChange
+	add := func(key, payload string) {
+		if topic := config.GetString(key); topic != "" {
+			changes = append(changes, stateChange{topic, payload})
+		}
+	}
+	if prev.Mute != cur.Mute {
+		add("mqtt.topicMinidspMuteStatus", fmt.Sprintf("%v", cur.Mute))
+	}
+	if prev.MasterVolume != cur.MasterVolume {
+		add("mqtt.topicMinidspMasterVolume", masterVolumePayload(cur.Name, cur.MasterVolume))
+	}
+	before, now := activeEntry(prev), activeEntry(cur)
+	if before != now && (now == "" || loaded == "" || !strings.Contains(strings.ToLower(now), strings.ToLower(loaded))) {
+		add("mqtt.topicBeqCurrentProfile", now)
+	}
+	return changes
+}
+
+// activeEntry returns what is loaded in the active slot, or empty if nothing is
+func activeEntry(d models.BeqDevices) string {
+	if len(d.Slots) == 0 {
+		return ""
+	}
+	slot := d.Slots[0]
+	for _, s := range d.Slots {
+		if s.Active {
+			slot = s
+			break
+		}
+	}
+	if strings.EqualFold(slot.Last, emptySlot) {
+		return ""
+	}
+	return slot.Last
+}
+
+// loadedProfileTitle returns the title GoWatchIt loaded into a device, if any
+func (c *BeqClient) loadedProfileTitle(device string) string {
+	if c.State == nil {
+		return ""
+	}
+	if p, ok := c.State.GetProfiles()[device]; ok && p.Loaded {
+		return p.Title
+	}
+	return ""
+}
@@ -0,0 +1,169 @@
+package ezbeq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceChanges(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("mqtt.topicMinidspMuteStatus", "mute")
+	config.Set("mqtt.topicMinidspMasterVolume", "mv")
+	config.Set("mqtt.topicBeqCurrentProfile", "profile")
+	t.Cleanup(func() {
+		config.Set("mqtt.topicMinidspMuteStatus", "")
+		config.Set("mqtt.topicMinidspMasterVolume", "")
+		config.Set("mqtt.topicBeqCurrentProfile", "")
+	})
+
+	base := models.BeqDevices{Name: "master", MasterVolume: -10, Slots: []models.BeqSlots{{ID: "1", Last: "Empty", Active: true}, {ID: "2", Last: "Empty"}}}
+	withEntry := func(d models.BeqDevices, last string) models.BeqDevices {
+		d.Slots = []models.BeqSlots{{ID: "1", Last: last, Active: true}, {ID: "2", Last: "Empty"}}
+		return d
+	}
+	muted := base
+	muted.Mute = true
+	louder := base
+	louder.MasterVolume = -5
+
+	type testStruct struct {
+		name    string
+		prev    models.BeqDevices
+		cur     models.BeqDevices
+		loaded  string
+		changes []stateChange
+	}
+	tt := []testStruct{
+		{name: "nothing", prev: base, cur: base},
+		{name: "mute", prev: base, cur: muted, changes: []stateChange{{"mute", "true"}}},
+		{name: "master volume", prev: base, cur: louder, changes: []stateChange{{"mv", "{\"device\":\"master\",\"masterVolume\":-5}"}}},
+		{name: "loaded from ezbeq ui", prev: base, cur: withEntry(base, "Dune (2021)"), changes: []stateChange{{"profile", "Dune (2021)"}}},
+		{name: "loaded by us", prev: base, cur: withEntry(base, "Dune (2021)"), loaded: "Dune"},
+		{name: "unloaded", prev: withEntry(base, "Dune (2021)"), cur: base, loaded: "Dune", changes: []stateChange{{"profile", ""}}},
+	}
+	for _, tc := range tt {
+		assert.Equal(tc.changes, deviceChanges(tc.prev, tc.cur, tc.loaded), tc.name)
+	}
+
+	// topics which are not set are left out
+	config.Set("mqtt.topicMinidspMuteStatus", "")
+	assert.Empty(deviceChanges(base, muted, ""))
+}
+
+func TestWatchDevices(t *testing.T) {
+	assert := assert.New(t)
+	fastRetries(t)
+
+	initial := models.BeqDevices{Name: "master", Slots: []models.BeqSlots{{ID: "1", Last: "Empty", Active: true}}}
+	updates := make(chan models.BeqDevices)
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2/devices", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]models.BeqDevices{"master": initial})
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for d := range updates {
+			data, _ := json.Marshal(d)
+			_ = conn.WriteJSON(wsMessage{Message: deviceStateMessage, Data: data})
+		}
+	})
+	c := newTestClient(t, mux)
+
+	w := getWatcher(c.ServerURL + ":" + c.Port)
+	w.clients = append(w.clients, c)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx)
+
+	// resynced on connect
+	assert.Eventually(func() bool { return len(c.Devices()) == 1 }, time.Second, 10*time.Millisecond)
+
+	// changes made outside GoWatchIt show up on the client, and new devices are added
+	updates <- models.BeqDevices{Name: "master", Mute: true, Slots: []models.BeqSlots{{ID: "1", Last: "Dune", Active: true}}}
+	updates <- models.BeqDevices{Name: "living"}
+	assert.Eventually(func() bool { return len(c.Devices()) == 2 }, time.Second, 10*time.Millisecond)
+	d := c.Devices()[0]
+	assert.True(d.Mute)
+	assert.Equal("Dune", activeEntry(d))
+	close(updates)
+}
+
+func TestCloseUnregisters(t *testing.T) {
+	assert := assert.New(t)
+	w := getWatcher("close-test:8080")
+	a := &BeqClient{ServerURL: "close-test", Port: "8080"}
+	b := &BeqClient{ServerURL: "close-test", Port: "8080"}
+	w.clients = []*BeqClient{a, b}
+
+	a.Close()
+	assert.Equal([]*BeqClient{b}, w.clients)
+	// closing twice or without a watcher does nothing
+	a.Close()
+	(&BeqClient{ServerURL: "other", Port: "1"}).Close()
+	assert.Equal([]*BeqClient{b}, w.clients)
+}
+
+// failingTransport fails every request, like a client for a server that went away
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("client closed")
+}
+
+func TestWatcherResyncsThroughOpenClient(t *testing.T) {
+	assert := assert.New(t)
+	fastRetries(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2/devices", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]models.BeqDevices{"master": {Name: "master"}})
+	})
+	upgrader := websocket.Upgrader{}
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _, _ = conn.ReadMessage()
+	})
+	c := newTestClient(t, mux)
+	// the first client to register has closed since, e.g a coverage scan
+	closed := &BeqClient{ServerURL: c.ServerURL, Port: c.Port, HTTPClient: http.Client{Transport: failingTransport{}}}
+
+	w := getWatcher(c.ServerURL + ":" + c.Port)
+	w.mu.Lock()
+	w.clients = append(w.clients, closed, c)
+	w.mu.Unlock()
+	closed.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx)
+
+	assert.Eventually(func() bool { return len(c.Devices()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Empty(closed.Devices())
+
+	// nothing to resync through
+	c.Close()
+	assert.Nil(w.client())
+}
+
+func TestWsURL(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("ws://127.0.0.1:8080/ws", wsURL("http://127.0.0.1:8080"))
+	assert.Equal("wss://beq.local:443/ws", wsURL("https://beq.local:443"))
+}
@@ -0,0 +1,124 @@
+package ezbeq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/mqtt"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// safety limits for the device master volume, in dB
+const (
+	defaultMasterVolumeMin = -60.0
+	defaultMasterVolumeMax = 0.0
+)
+
+// masterVolumeLimits returns the min and max master volume from the config, or the defaults if they are not numbers or min isn't below max
+func masterVolumeLimits() (float64, float64) {
+	min, max := defaultMasterVolumeMin, defaultMasterVolumeMax
+	if v, ok := config.LookupFloat64("ezbeq.masterVolumeMin"); ok {
+		min = v
+	}
+	if v, ok := config.LookupFloat64("ezbeq.masterVolumeMax"); ok {
+		max = v
+	}
+	if min >= max {
+		log.Warnf("Master volume minimum %v is not below the maximum %v, using the defaults", min, max)
+		return defaultMasterVolumeMin, defaultMasterVolumeMax
+	}
+	return min, max
+}
+
+// clampMasterVolume keeps v within the configured limits
+func clampMasterVolume(v float64) float64 {
+	min, max := masterVolumeLimits()
+	if v > max {
+		log.Warnf("Master volume %v is above the limit, using %v", v, max)
+		return max
+	}
+	if v < min {
+		log.Warnf("Master volume %v is below the limit, using %v", v, min)
+		return min
+	}
+	return v
+}
+
+// getDevices returns the current state of every device
+func (c *BeqClient) getDevices(ctx context.Context) (map[string]models.BeqDevices, error) {
+	res, err := c.makeReq(ctx, "/api/2/devices", nil, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	return mapToBeqDevice(res)
+}
+
+// masterVolumeTarget returns the master volume before the offset and the volume to set
+func (c *BeqClient) masterVolumeTarget(ctx context.Context, device string, offset float64) (float64, float64, error) {
+	// if we already applied an offset, start from what it was before so reloads don't stack
+	if c.State != nil {
+		if p, ok := c.State.GetProfiles()[device]; ok && p.Loaded && p.PreviousMasterVolume != nil {
+			return *p.PreviousMasterVolume, clampMasterVolume(*p.PreviousMasterVolume + offset), nil
+		}
+	}
+
+	devices, err := c.getDevices(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	d, ok := devices[device]
+	if !ok {
+		return 0, 0, fmt.Errorf("device %s not found", device)
+	}
+
+	return d.MasterVolume, clampMasterVolume(d.MasterVolume + offset), nil
+}
+
+// setMasterVolume changes only the master volume of a device
+func (c *BeqClient) setMasterVolume(ctx context.Context, device string, v float64) error {
+	b, err := json.Marshal(models.BeqPatchV2{MasterVolume: &v})
+	if err != nil {
+		return err
+	}
+	if _, err := c.makeReq(ctx, fmt.Sprintf("/api/2/devices/%s", device), b, http.MethodPatch); err != nil {
+		return err
+	}
+	log.Infof("Set master volume of %s to %v", device, v)
+
+	return publishMasterVolume(device, v)
+}
+
+// restoreMasterVolume puts back the master volume from before the profile was loaded
+func (c *BeqClient) restoreMasterVolume(ctx context.Context, m *models.SearchRequest) error {
+	if c.State == nil {
+		return nil
+	}
+	saved := c.State.GetProfiles()
+	for _, v := range m.Devices {
+		p, ok := saved[v]
+		if !ok || !p.Loaded || p.PreviousMasterVolume == nil {
+			continue
+		}
+		log.Debugf("Restoring master volume of %s to %v", v, *p.PreviousMasterVolume)
+		if err := c.setMasterVolume(ctx, v, *p.PreviousMasterVolume); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishMasterVolume sends the master volume of a device to mqtt, if the topic is set
+func publishMasterVolume(device string, v float64) error {
+	topic := config.GetString("mqtt.topicMinidspMasterVolume")
+	if topic == "" {
+		return nil
+	}
+	return mqtt.PublishWrapper(topic, masterVolumePayload(device, v))
+}
+
+func masterVolumePayload(device string, v float64) string {
+	return fmt.Sprintf("{\"device\":\"%s\",\"masterVolume\":%v}", device, v)
+}
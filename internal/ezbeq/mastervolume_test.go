@@ -0,0 +1,171 @@
+package ezbeq
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/state"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDevice is a minimal ezbeq device called master which tracks master volume and slots
+type fakeDevice struct {
+	mu      sync.Mutex
+	mv      float64
+	slots   map[string]string
+	patches []models.BeqPatchV2
+	// ignore drops filter changes, like a device that didn't apply them
+	ignore bool
+}
+
+func (f *fakeDevice) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.slots == nil {
+		f.slots = map[string]string{"1": "Empty", "2": "Empty"}
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/2/devices":
+		d := models.BeqDevices{Name: "master", MasterVolume: f.mv}
+		for _, id := range []string{"1", "2"} {
+			d.Slots = append(d.Slots, models.BeqSlots{ID: id, Last: f.slots[id]})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]models.BeqDevices{"master": d})
+	case r.Method == http.MethodPatch && r.URL.Path == "/api/2/devices/master":
+		b, _ := io.ReadAll(r.Body)
+		var p models.BeqPatchV2
+		_ = json.Unmarshal(b, &p)
+		f.patches = append(f.patches, p)
+		if p.MasterVolume != nil {
+			f.mv = *p.MasterVolume
+		}
+		for _, s := range p.Slots {
+			if !f.ignore {
+				f.slots[s.ID] = entryTitle(s.Entry)
+			}
+		}
+		_, _ = w.Write([]byte("{}"))
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/1/devices/master/filter/"):
+		if !f.ignore {
+			f.slots[strings.TrimPrefix(r.URL.Path, "/api/1/devices/master/filter/")] = "Empty"
+		}
+		_, _ = w.Write([]byte("{}"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// entryTitle is what ezbeq shows as the last filter of a slot, the title if it is a test catalog entry
+func entryTitle(id string) string {
+	for _, e := range testCatalogEntries() {
+		if e.ID == id {
+			return e.Title
+		}
+	}
+	return "Entry " + id
+}
+
+func TestApplyMasterVolume(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.enabled", true)
+	config.Set("ezbeq.applyMasterVolume", true)
+	config.Set("ezbeq.masterVolumeMin", -20)
+	config.Set("ezbeq.masterVolumeMax", -5)
+	t.Cleanup(func() {
+		config.Set("ezbeq.applyMasterVolume", false)
+		config.Set("ezbeq.masterVolumeMin", defaultMasterVolumeMin)
+		config.Set("ezbeq.masterVolumeMax", defaultMasterVolumeMax)
+	})
+
+	dev := &fakeDevice{mv: -10}
+	c := newTestClient(t, dev)
+	store, err := state.NewStore(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(err)
+	c.State = store
+
+	m := &models.SearchRequest{Devices: []string{"master"}, Slots: []int{1}, EntryID: "123", MVAdjust: -3.5, SkipSearch: true}
+	assert.NoError(c.LoadBeqProfile(context.Background(), m))
+	assert.Equal(-13.5, dev.mv)
+	// the offset is not also applied to the input gain
+	last := dev.patches[len(dev.patches)-1]
+	assert.Equal([]float64{0, 0}, last.Slots[0].Gains)
+
+	// loading again does not stack the offset
+	assert.NoError(c.LoadBeqProfile(context.Background(), m))
+	assert.Equal(-13.5, dev.mv)
+
+	p := store.GetProfiles()["master"]
+	assert.Equal(-10.0, *p.PreviousMasterVolume)
+
+	// unload restores the volume from before
+	assert.NoError(c.UnloadBeqProfile(context.Background(), m))
+	assert.Equal(-10.0, dev.mv)
+	assert.Nil(store.GetProfiles()["master"].PreviousMasterVolume)
+
+	// limits are enforced
+	m.MVAdjust = -15
+	assert.NoError(c.LoadBeqProfile(context.Background(), m))
+	assert.Equal(-20.0, dev.mv)
+	assert.NoError(c.UnloadBeqProfile(context.Background(), m))
+
+	m.MVAdjust = 8
+	assert.NoError(c.LoadBeqProfile(context.Background(), m))
+	assert.Equal(-5.0, dev.mv)
+	assert.NoError(c.UnloadBeqProfile(context.Background(), m))
+	assert.Equal(-10.0, dev.mv)
+}
+
+func TestLoadWithoutMasterVolume(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.enabled", true)
+
+	dev := &fakeDevice{mv: -10}
+	c := newTestClient(t, dev)
+
+	m := &models.SearchRequest{Devices: []string{"master"}, Slots: []int{1}, EntryID: "123", MVAdjust: -3.5, SkipSearch: true}
+	assert.NoError(c.LoadBeqProfile(context.Background(), m))
+	assert.Equal(-10.0, dev.mv)
+	assert.Nil(dev.patches[0].MasterVolume)
+	assert.Equal([]float64{-3.5, -3.5}, dev.patches[0].Slots[0].Gains)
+}
+
+func TestMasterVolumeLimits(t *testing.T) {
+	assert := assert.New(t)
+	t.Cleanup(func() {
+		config.Set("ezbeq.masterVolumeMin", defaultMasterVolumeMin)
+		config.Set("ezbeq.masterVolumeMax", defaultMasterVolumeMax)
+	})
+
+	// blank fields from the web UI use the defaults
+	config.Set("ezbeq.masterVolumeMin", "")
+	config.Set("ezbeq.masterVolumeMax", "")
+	min, max := masterVolumeLimits()
+	assert.Equal(defaultMasterVolumeMin, min)
+	assert.Equal(defaultMasterVolumeMax, max)
+
+	// so is anything that isn't a number, or limits which leave no range
+	config.Set("ezbeq.masterVolumeMin", "undefined")
+	config.Set("ezbeq.masterVolumeMax", "undefined")
+	min, max = masterVolumeLimits()
+	assert.Equal(defaultMasterVolumeMin, min)
+	assert.Equal(defaultMasterVolumeMax, max)
+	config.Set("ezbeq.masterVolumeMin", -10)
+	config.Set("ezbeq.masterVolumeMax", -10)
+	min, max = masterVolumeLimits()
+	assert.Equal(defaultMasterVolumeMin, min)
+	assert.Equal(defaultMasterVolumeMax, max)
+
+	config.Set("ezbeq.masterVolumeMin", "-30")
+	config.Set("ezbeq.masterVolumeMax", "-2.5")
+	assert.Equal(-30.0, clampMasterVolume(-45))
+	assert.Equal(-2.5, clampMasterVolume(3))
+	assert.Equal(-12.0, clampMasterVolume(-12))
+}
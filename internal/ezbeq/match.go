@@ -0,0 +1,367 @@
+package ezbeq
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// scoring weights, a perfect match scores 100
+const (
+	scoreIdentity       = 40
+	scoreTitleFuzzy     = 30
+	scoreYearExact      = 20
+	scoreYearNear       = 10
+	scoreCodecExact     = 25
+	scoreEditionExact   = 15
+	scoreEditionSimilar = 12
+	scoreEditionLoose   = 10
+)
+
+const (
+	defaultMatchThreshold = 80
+	defaultYearTolerance  = 1
+	// how close two normalized titles need to be to count as the same
+	titleSimilarity = 0.85
+)
+
+// MatchResult is a scored catalog entry and why it got that score
+type MatchResult struct {
+	Entry    models.BeqCatalog
+	Score    int
+	Accepted bool
+	// Rejected is set when the entry can't be loaded no matter the score, e.g a different movie
+	Rejected bool
+	Reasons  []string
+	// Codec is the ezbeq codec the entry matched on
+	Codec string
+	// AuthorReason explains why the author of the accepted entry was picked
+	AuthorReason string
+}
+
+func (r MatchResult) String() string {
+	status := "skipped"
+	switch {
+	case r.Accepted:
+		status = "accepted"
+	case r.Rejected:
+		status = "rejected"
+	}
+	return fmt.Sprintf("%s (%d) %v by %s [id %s]: %s with score %d - %s", r.Entry.Title, r.Entry.Year, r.Entry.AudioTypes, r.Entry.Author, r.Entry.ID, status, r.Score, strings.Join(r.Reasons, ", "))
+}
+
+func (r *MatchResult) add(score int, reason string, args ...interface{}) {
+	r.Score += score
+	r.Reasons = append(r.Reasons, fmt.Sprintf(reason, args...))
+}
+
+func (r *MatchResult) reject(reason string, args ...interface{}) {
+	r.Rejected = true
+	r.Reasons = append(r.Reasons, fmt.Sprintf(reason, args...))
+}
+
+// matchThreshold is the minimum score an entry needs to be loaded, from 1 to 100
+func matchThreshold() int {
+	if v, ok := config.LookupFloat64("ezbeq.matchThreshold"); ok && v >= 1 && v <= 100 {
+		return int(v)
+	}
+	return defaultMatchThreshold
+}
+
+// yearTolerance is how many years off an entry can be, since release years differ between plex, tmdb and the catalog
+func yearTolerance() int {
+	if v, ok := config.LookupFloat64("ezbeq.yearTolerance"); ok && v >= 0 {
+		return int(v)
+	}
+	return defaultYearTolerance
+}
+
+// matchCatalog scores every candidate and returns the best one above the threshold. codecs is in order of preference
+func matchCatalog(m *models.SearchRequest, payload []models.BeqCatalog, codecs []string) (models.BeqCatalog, []MatchResult, error) {
+	skipTMDB := config.GetBool("jellyfin.skiptmdb")
+	if skipTMDB && m.Title == "" {
+		return models.BeqCatalog{}, nil, errors.New("title is blank, can't skip TMDB")
+	}
+	threshold := matchThreshold()
+	tolerance := yearTolerance()
+	authors := authorPriority(m, codecs)
+
+	results := make([]MatchResult, 0, len(payload))
+	for _, val := range payload {
+		results = append(results, scoreEntry(m, val, codecs, skipTMDB, tolerance))
+	}
+	// the best match wins, preferred authors break ties. Stable so the rest keep catalog order
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Rejected != results[j].Rejected {
+			return !results[i].Rejected
+		}
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return authorRank(authors, results[i].Entry.Author) < authorRank(authors, results[j].Entry.Author)
+	})
+
+	if len(results) > 0 && !results[0].Rejected && results[0].Score >= threshold {
+		results[0].Accepted = true
+		results[0].AuthorReason = authorReason(authors, results[0].Entry.Author)
+	}
+	for _, r := range results {
+		log.Debugf("Beq match: %s", r)
+	}
+
+	if len(results) == 0 {
+		return models.BeqCatalog{}, results, errors.New("beq profile was not found in catalog: no candidates returned")
+	}
+	if !results[0].Accepted {
+		return models.BeqCatalog{}, results, fmt.Errorf("beq profile was not found in catalog: best candidate %s (threshold %d)", results[0], threshold)
+	}
+
+	log.Infof("Found a match in catalog from author %s (%s): %s", results[0].Entry.Author, results[0].AuthorReason, results[0])
+	return results[0].Entry, results, nil
+}
+
+// scoreEntry scores a single catalog entry against the request
+func scoreEntry(m *models.SearchRequest, val models.BeqCatalog, codecs []string, skipTMDB bool, tolerance int) MatchResult {
+	r := MatchResult{Entry: val}
+
+	// identity, much easier to match on tmdb since plex provides it. Fall back to titles if there is no tmdb to compare
+	switch {
+	case !skipTMDB && m.TMDB != "" && val.MovieDbID != "":
+		if val.MovieDbID != m.TMDB {
+			r.reject("tmdb %s does not match %s", val.MovieDbID, m.TMDB)
+			return r
+		}
+		r.add(scoreIdentity, "tmdb matched")
+	default:
+		score, reason := scoreTitle(m.Title, val)
+		if score == 0 {
+			r.reject("title %q does not match %q", val.Title, m.Title)
+			return r
+		}
+		r.add(score, reason)
+	}
+
+	// films and series can share a tmdb id, content type is only set in newer catalogs
+	episode := m.Season > 0
+	if val.ContentType != "" && isTV(val) != episode {
+		r.reject("entry is %s", val.ContentType)
+		return r
+	}
+
+	// episodes match on the season, the year of a season entry is not the series' year
+	if episode {
+		score, reason := scoreSeason(m.Season, m.Episode, val)
+		if score == 0 {
+			r.reject("%s", reason)
+			return r
+		}
+		r.add(score, reason)
+	} else {
+		// year
+		diff := val.Year - m.Year
+		if diff < 0 {
+			diff = -diff
+		}
+		switch {
+		case diff == 0:
+			r.add(scoreYearExact, "year matched")
+		case diff <= tolerance:
+			r.add(scoreYearNear, "year %d is within %d of %d", val.Year, tolerance, m.Year)
+		default:
+			r.reject("year %d is too far from %d", val.Year, m.Year)
+			return r
+		}
+	}
+
+	// codec, some entries have multiple audio types
+	score, codec, reason := scoreCodec(codecs, val.AudioTypes)
+	if score == 0 {
+		r.reject("%s", reason)
+		return r
+	}
+	r.Codec = codec
+	r.add(score, reason)
+
+	// edition
+	score, reason = scoreEdition(m.Edition, val.Edition)
+	if score == 0 {
+		r.reject("%s", reason)
+		return r
+	}
+	r.add(score, reason)
+
+	return r
+}
+
+// scoreTitle compares the title with the entry's title, alt title and sort title
+func scoreTitle(title string, val models.BeqCatalog) (int, string) {
+	want := normalizeTitle(title)
+	if want == "" {
+		return 0, ""
+	}
+	best := 0.0
+	for _, t := range []string{val.Title, val.AltTitle, val.SortTitle} {
+		got := normalizeTitle(t)
+		if got == "" {
+			continue
+		}
+		if got == want {
+			return scoreIdentity, fmt.Sprintf("title matched %q", t)
+		}
+		if s := similarity(want, got); s > best {
+			best = s
+		}
+	}
+	if best >= titleSimilarity {
+		return scoreTitleFuzzy, fmt.Sprintf("title is similar (%.0f%%)", best*100)
+	}
+
+	return 0, ""
+}
+
+// scoreCodec gives full points for the preferred codec, a bit less for each fallback. It also returns the ezbeq codec which matched.
+// A codec of the same family scores nothing since its filter is for a different mix, the reason says so for the logs
+func scoreCodec(codecs []string, audioTypes []string) (int, string, string) {
+	for i, want := range codecs {
+		for _, v := range audioTypes {
+			if strings.EqualFold(v, want) {
+				// each fallback is worth a bit less than the one before it
+				return scoreCodecExact - i, want, fmt.Sprintf("codec %s matched", v)
+			}
+		}
+	}
+	for _, want := range codecs {
+		for _, v := range audioTypes {
+			if fam := codecFamily(want); fam != "" && fam == codecFamily(v) {
+				return 0, "", fmt.Sprintf("codec %s is the same family as %s but not a match", v, want)
+			}
+		}
+	}
+
+	return 0, "", fmt.Sprintf("codecs %v do not match %v", audioTypes, codecs)
+}
+
+// codecFamily groups the ezbeq audio types which share a filter more often than not
+func codecFamily(codec string) string {
+	c := strings.ToLower(strings.TrimSpace(codec))
+	switch {
+	case c == "":
+		return ""
+	case strings.HasPrefix(c, "dd+"), strings.HasPrefix(c, "eac3"), strings.HasPrefix(c, "e-ac3"):
+		return "dd+"
+	// atmos is not truehd here, TrueHD 7.1 is only tried as Atmos through the AtmosMaybe fallbacks
+	case strings.HasPrefix(c, "truehd"):
+		return "truehd"
+	case strings.HasPrefix(c, "dts"):
+		return "dts"
+	case strings.HasPrefix(c, "lpcm"), strings.HasPrefix(c, "pcm"):
+		return "lpcm"
+	case strings.HasPrefix(c, "ac3"), strings.HasPrefix(c, "dd "), c == "dd":
+		return "ac3"
+	default:
+		return c
+	}
+}
+
+// map to Unrated, Ultimate, Theatrical, Extended, Director, Criterion
+func scoreEdition(want, got string) (int, string) {
+	w := normalizeEdition(want)
+	g := normalizeEdition(got)
+	switch {
+	case w == "" && g == "":
+		return scoreEditionExact, "no edition"
+	// if edition from beq is empty, any match will do
+	case g == "":
+		return scoreEditionLoose, fmt.Sprintf("entry has no edition, wanted %s", want)
+	case w == "":
+		return scoreEditionLoose, fmt.Sprintf("entry is %s edition, none requested", got)
+	// if the beq edition contains the string like Extended for "Extended Cut", its ok
+	case strings.Contains(g, w) || strings.Contains(w, g):
+		return scoreEditionExact, fmt.Sprintf("edition %s matched", got)
+	}
+	for _, a := range strings.Fields(w) {
+		for _, b := range strings.Fields(g) {
+			if a == b {
+				return scoreEditionSimilar, fmt.Sprintf("edition %s is similar to %s", got, want)
+			}
+		}
+	}
+
+	return 0, fmt.Sprintf("edition %s does not match %s", got, want)
+}
+
+// normalizeTitle lowercases and drops punctuation and a leading "the"
+func normalizeTitle(s string) string {
+	s = strings.ToLower(strings.ReplaceAll(s, "&", " and "))
+	s = strings.Join(strings.Fields(stripPunct(s)), " ")
+	return strings.TrimPrefix(s, "the ")
+}
+
+// normalizeEdition reduces "Director's Cut" and "Directors Edition" to "director"
+func normalizeEdition(s string) string {
+	s = strings.ToLower(strings.ReplaceAll(s, "'s", ""))
+	var words []string
+	for _, w := range strings.Fields(stripPunct(s)) {
+		switch w {
+		case "cut", "edition", "version", "the":
+			continue
+		case "dc":
+			w = "director"
+		}
+		if len(w) > 4 && strings.HasSuffix(w, "s") {
+			w = strings.TrimSuffix(w, "s")
+		}
+		words = append(words, w)
+	}
+	return strings.Join(words, " ")
+}
+
+func stripPunct(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			return r
+		}
+		if r == '-' || r == ':' || r == '_' {
+			return ' '
+		}
+		return -1
+	}, s)
+}
+
+// similarity is 1 minus the edit distance relative to the longer string
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,173 @@
+package ezbeq
+
+import (
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchCatalog(t *testing.T) {
+	assert := assert.New(t)
+	entries := append(testCatalogEntries(),
+		models.BeqCatalog{ID: "6", Title: "Blade Runner", AltTitle: "Blade Runner: The Final Cut", Year: 1982, AudioTypes: []string{"TrueHD 5.1"}, MovieDbID: "78", Author: "aron7awol", Edition: "Director's Cut"},
+		models.BeqCatalog{ID: "7", Title: "Dune", Year: 2021, AudioTypes: []string{"Atmos"}, MovieDbID: "438631", Author: "aron7awol"},
+	)
+
+	type testStruct struct {
+		name string
+		m    models.SearchRequest
+		id   string
+	}
+	tt := []testStruct{
+		{name: "exact", m: models.SearchRequest{TMDB: "51497", Year: 2011, Codec: "DTS-X", Edition: "Extended"}, id: "1"},
+		{name: "year off by one", m: models.SearchRequest{TMDB: "51497", Year: 2012, Codec: "DTS-X", Edition: "Extended"}, id: "1"},
+		{name: "year too far", m: models.SearchRequest{TMDB: "51497", Year: 2014, Codec: "DTS-X", Edition: "Extended"}},
+		{name: "exact codec beats family", m: models.SearchRequest{TMDB: "51497", Year: 2011, Codec: "DTS-HD MA 5.1"}, id: "2"},
+		// a filter for another mix is never loaded
+		{name: "codec family", m: models.SearchRequest{TMDB: "843794", Year: 2023, Codec: "DD+ 5.1"}},
+		{name: "truehd is not atmos", m: models.SearchRequest{TMDB: "438631", Year: 2021, Codec: "TrueHD 7.1"}},
+		{name: "dts family", m: models.SearchRequest{TMDB: "51497", Year: 2011, Codec: "DTS 5.1"}},
+		{name: "wrong codec family", m: models.SearchRequest{TMDB: "843794", Year: 2023, Codec: "DTS-X"}},
+		{name: "edition written differently", m: models.SearchRequest{TMDB: "78", Year: 1982, Codec: "TrueHD 5.1", Edition: "Directors Edition"}, id: "6"},
+		{name: "edition mismatch", m: models.SearchRequest{TMDB: "78", Year: 1982, Codec: "TrueHD 5.1", Edition: "Theatrical"}},
+		// ties keep catalog order
+		{name: "multiple audio types", m: models.SearchRequest{TMDB: "429351", Year: 2018, Codec: "DTS-HD MA 7.1"}, id: "3"},
+		{name: "unknown tmdb", m: models.SearchRequest{TMDB: "ojdsfojnekfw", Year: 2018, Codec: "DTS-HD MA 5.1"}},
+		// soft misses fall below the threshold
+		{name: "below threshold", m: models.SearchRequest{Title: "Fast Fives", Year: 2012, Codec: "DTS-X"}},
+	}
+	for _, tc := range tt {
+		res, results, err := matchCatalog(&tc.m, entries, []string{tc.m.Codec})
+		assert.Len(results, len(entries), tc.name)
+		if tc.id == "" {
+			assert.Error(err, tc.name)
+			assert.Contains(err.Error(), "beq profile was not found in catalog", tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.id, res.ID, tc.name)
+		assert.True(results[0].Accepted, tc.name)
+		assert.NotEmpty(results[0].Reasons, tc.name)
+	}
+}
+
+func TestMatchCatalogSkipTMDB(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("jellyfin.skiptmdb", true)
+	t.Cleanup(func() { config.Set("jellyfin.skiptmdb", false) })
+
+	entries := []models.BeqCatalog{
+		{ID: "1", Title: "Star Wars", AltTitle: "Star Wars: Episode IV - A New Hope", Year: 1977, AudioTypes: []string{"DTS-HD MA 6.1"}, Author: "aron7awol"},
+		{ID: "2", Title: "The Lord of the Rings: The Fellowship of the Ring", Year: 2001, AudioTypes: []string{"DTS-X"}, Author: "aron7awol"},
+	}
+
+	type testStruct struct {
+		title string
+		year  int
+		codec string
+		id    string
+	}
+	tt := []testStruct{
+		{title: "Star Wars: Episode IV - A New Hope", year: 1977, codec: "DTS-HD MA 6.1", id: "1"},
+		{title: "star wars", year: 1977, codec: "DTS-HD MA 6.1", id: "1"},
+		{title: "Lord of the Rings - The Fellowship of the Ring", year: 2001, codec: "DTS-X", id: "2"},
+		{title: "The Lord of the Rings: The Two Towers", year: 2002, codec: "DTS-X"},
+	}
+	for _, tc := range tt {
+		res, _, err := matchCatalog(&models.SearchRequest{Title: tc.title, Year: tc.year, Codec: tc.codec}, entries, []string{tc.codec})
+		if tc.id == "" {
+			assert.Error(err, tc.title)
+			continue
+		}
+		assert.NoError(err, tc.title)
+		assert.Equal(tc.id, res.ID, tc.title)
+	}
+
+	_, _, err := matchCatalog(&models.SearchRequest{Year: 1977, Codec: "DTS-HD MA 6.1"}, entries, []string{"DTS-HD MA 6.1"})
+	assert.Error(err)
+}
+
+func TestMatchThreshold(t *testing.T) {
+	assert := assert.New(t)
+	t.Cleanup(func() { config.Set("ezbeq.matchThreshold", defaultMatchThreshold) })
+
+	m := &models.SearchRequest{Title: "Fast Fives", Year: 2012, Codec: "DTS-X"}
+	_, results, err := matchCatalog(m, testCatalogEntries(), []string{m.Codec})
+	assert.Error(err)
+	// the reasons say why it was not loaded
+	assert.Equal("1", results[0].Entry.ID)
+	assert.Equal(scoreTitleFuzzy+scoreYearNear+scoreCodecExact+scoreEditionLoose, results[0].Score)
+	assert.Contains(results[0].String(), "similar")
+
+	config.Set("ezbeq.matchThreshold", 70)
+	res, _, err := matchCatalog(m, testCatalogEntries(), []string{m.Codec})
+	assert.NoError(err)
+	assert.Equal("1", res.ID)
+
+	// a codec of the same family is rejected whatever the threshold
+	config.Set("ezbeq.matchThreshold", 1)
+	m = &models.SearchRequest{TMDB: "843794", Year: 2023, Codec: "DD+ 5.1"}
+	_, results, err = matchCatalog(m, testCatalogEntries(), []string{m.Codec})
+	assert.Error(err)
+	assert.Equal("5", results[0].Entry.ID)
+	assert.True(results[0].Rejected)
+	assert.Contains(results[0].String(), "same family")
+}
+
+func TestMatchSettingsInvalid(t *testing.T) {
+	assert := assert.New(t)
+	t.Cleanup(func() {
+		config.Set("ezbeq.matchThreshold", defaultMatchThreshold)
+		config.Set("ezbeq.yearTolerance", defaultYearTolerance)
+	})
+
+	// anything that isn't a number in range uses the default, 0 would load any candidate
+	for _, v := range []interface{}{"undefined", "", 0, 101, "-5"} {
+		config.Set("ezbeq.matchThreshold", v)
+		assert.Equal(defaultMatchThreshold, matchThreshold(), "%v", v)
+	}
+	config.Set("ezbeq.matchThreshold", "70")
+	assert.Equal(70, matchThreshold())
+
+	for _, v := range []interface{}{"undefined", "", -1} {
+		config.Set("ezbeq.yearTolerance", v)
+		assert.Equal(defaultYearTolerance, yearTolerance(), "%v", v)
+	}
+	config.Set("ezbeq.yearTolerance", 0)
+	assert.Equal(0, yearTolerance())
+}
+
+func TestScoreEdition(t *testing.T) {
+	assert := assert.New(t)
+	type testStruct struct {
+		want, got string
+		score     int
+	}
+	tt := []testStruct{
+		{want: "", got: "", score: scoreEditionExact},
+		{want: "Extended", got: "", score: scoreEditionLoose},
+		{want: "", got: "Extended", score: scoreEditionLoose},
+		{want: "Extended", got: "Extended Cut", score: scoreEditionExact},
+		{want: "Director", got: "Director's Cut", score: scoreEditionExact},
+		{want: "Directors Cut", got: "Director's Cut", score: scoreEditionExact},
+		{want: "Ultimate Extended", got: "Extended", score: scoreEditionExact},
+		{want: "Extended Unrated", got: "Unrated Extended Cut", score: scoreEditionSimilar},
+		{want: "Theatrical", got: "Extended", score: 0},
+	}
+	for _, tc := range tt {
+		score, _ := scoreEdition(tc.want, tc.got)
+		assert.Equal(tc.score, score, "%s vs %s", tc.want, tc.got)
+	}
+}
+
+func TestCodecFamily(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(codecFamily("DD+ Atmos"), codecFamily("DD+ 5.1"))
+	assert.NotEqual(codecFamily("Atmos"), codecFamily("TrueHD 7.1"))
+	assert.Equal(codecFamily("DTS-X"), codecFamily("DTS-HD MA 5.1"))
+	assert.NotEqual(codecFamily("DTS-X"), codecFamily("Atmos"))
+	assert.NotEqual(codecFamily("AC3 5.1"), codecFamily("DD+"))
+	assert.Equal("", codecFamily(" "))
+}
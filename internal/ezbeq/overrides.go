@@ -0,0 +1,88 @@
+package ezbeq
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// ErrBlocked is returned when an override says never to load BEQ for a title
+var ErrBlocked = errors.New("BEQ is blocked for this title")
+
+// Override changes how a title is matched. It applies by TMDB ID, or by title and year if there is no TMDB ID
+type Override struct {
+	TMDB  string `mapstructure:"tmdb" json:"tmdb"`
+	Title string `mapstructure:"title" json:"title"`
+	// Year is optional with a title, 0 matches any year
+	Year int `mapstructure:"year" json:"year"`
+	// EntryID loads this catalog entry instead of searching
+	EntryID string `mapstructure:"entryId" json:"entryId"`
+	// MVAdjust is used with EntryID when the entry is not in the local catalog
+	MVAdjust float64 `mapstructure:"mvAdjust" json:"mvAdjust"`
+	Codec    string  `mapstructure:"codec" json:"codec"`
+	Edition  string  `mapstructure:"edition" json:"edition"`
+	// Block never loads BEQ for the title
+	Block bool `mapstructure:"block" json:"block"`
+}
+
+// getOverrides reads ezbeq.overrides from the config
+func getOverrides() []Override {
+	var overrides []Override
+	if err := config.UnmarshalKey("ezbeq.overrides", &overrides); err != nil {
+		log.Errorf("Error reading ezbeq overrides, ignoring them: %v", err)
+		return nil
+	}
+	return overrides
+}
+
+// matches reports if the override is for the title being played
+func (o Override) matches(m *models.SearchRequest) bool {
+	if o.TMDB != "" {
+		return o.TMDB == m.TMDB
+	}
+	if o.Title == "" || normalizeTitle(o.Title) != normalizeTitle(m.Title) {
+		return false
+	}
+	return o.Year == 0 || o.Year == m.Year
+}
+
+// findOverride returns the first override for the title being played
+func findOverride(m *models.SearchRequest) (Override, bool) {
+	for _, o := range getOverrides() {
+		if o.matches(m) {
+			return o, true
+		}
+	}
+	return Override{}, false
+}
+
+// applyOverride changes the search request with the override for its title. It returns the entry to load if one is forced
+func (c *BeqClient) applyOverride(m *models.SearchRequest) (models.BeqCatalog, bool, error) {
+	o, ok := findOverride(m)
+	if !ok {
+		return models.BeqCatalog{}, false, nil
+	}
+	if o.Block {
+		return models.BeqCatalog{}, false, fmt.Errorf("%w: %s (%d)", ErrBlocked, m.Title, m.Year)
+	}
+	if o.Codec != "" {
+		log.Infof("Override for %s: using codec %s instead of %s", m.Title, o.Codec, m.Codec)
+		m.Codec = o.Codec
+	}
+	if o.Edition != "" {
+		log.Infof("Override for %s: using edition %s instead of %s", m.Title, o.Edition, m.Edition)
+		m.Edition = o.Edition
+	}
+	if o.EntryID == "" {
+		return models.BeqCatalog{}, false, nil
+	}
+
+	log.Infof("Override for %s: loading entry %s", m.Title, o.EntryID)
+	if e, ok := c.Entry(o.EntryID); ok {
+		return e, true, nil
+	}
+	// the title is unknown so the read back only checks the slot is not empty
+	return models.BeqCatalog{ID: o.EntryID, MvAdjust: o.MVAdjust}, true, nil
+}
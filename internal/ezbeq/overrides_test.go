@@ -0,0 +1,79 @@
+package ezbeq
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/state"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindOverride(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.overrides", []map[string]interface{}{
+		{"tmdb": "51497", "codec": "DTS-X"},
+		{"title": "The Batman", "year": 2022, "block": true},
+		{"title": "Jung_E", "entryId": "5"},
+	})
+	t.Cleanup(func() { config.Set("ezbeq.overrides", []map[string]interface{}{}) })
+
+	type testStruct struct {
+		name  string
+		m     models.SearchRequest
+		found bool
+		codec string
+	}
+	tt := []testStruct{
+		{name: "tmdb", m: models.SearchRequest{TMDB: "51497", Title: "Fast 5"}, found: true, codec: "DTS-X"},
+		{name: "tmdb wins over title", m: models.SearchRequest{TMDB: "1", Title: "Fast Five"}},
+		{name: "title and year", m: models.SearchRequest{Title: "batman", Year: 2022}, found: true},
+		{name: "wrong year", m: models.SearchRequest{Title: "The Batman", Year: 2004}},
+		{name: "any year", m: models.SearchRequest{Title: "jung_e", Year: 2023}, found: true},
+	}
+	for _, tc := range tt {
+		o, ok := findOverride(&tc.m)
+		assert.Equal(tc.found, ok, tc.name)
+		assert.Equal(tc.codec, o.Codec, tc.name)
+	}
+}
+
+func TestLoadWithOverrides(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.enabled", true)
+	config.Set("ezbeq.overrides", []map[string]interface{}{
+		{"tmdb": "51497", "codec": "DTS-HD MA 5.1", "edition": ""},
+		{"tmdb": "429351", "block": true},
+		{"title": "Wrong Metadata", "year": 2023, "entryId": "5"},
+	})
+	t.Cleanup(func() { config.Set("ezbeq.overrides", []map[string]interface{}{}) })
+
+	dev := &fakeDevice{}
+	c := newTestClient(t, dev)
+	store, err := state.NewStore(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(err)
+	c.State = store
+	c.Catalog = NewCatalog(filepath.Join(t.TempDir(), "catalog.json"))
+	c.Catalog.Load(testCatalogEntries())
+
+	// the forced codec picks the 5.1 entry
+	m := &models.SearchRequest{Devices: []string{"master"}, Slots: []int{1}, TMDB: "51497", Title: "Fast Five", Year: 2011, Codec: "DTS-X"}
+	assert.NoError(c.LoadBeqProfile(context.Background(), m))
+	assert.Equal("2", m.EntryID)
+	assert.Equal("DTS-HD MA 5.1", m.Codec)
+
+	// blocked titles are not loaded
+	m = &models.SearchRequest{Devices: []string{"master"}, Slots: []int{1}, TMDB: "429351", Title: "12 Strong", Year: 2018, Codec: "DTS-HD MA 7.1"}
+	err = c.LoadBeqProfile(context.Background(), m)
+	assert.True(errors.Is(err, ErrBlocked))
+	assert.Empty(m.EntryID)
+
+	// a forced entry is loaded even if nothing would match
+	m = &models.SearchRequest{Devices: []string{"master"}, Slots: []int{1}, TMDB: "999", Title: "Wrong Metadata", Year: 2023, Codec: "DTS-X"}
+	assert.NoError(c.LoadBeqProfile(context.Background(), m))
+	assert.Equal("5", m.EntryID)
+	assert.Equal("Jung_E", dev.slots["1"])
+}
@@ -0,0 +1,48 @@
+package ezbeq
+
+import (
+	"errors"
+
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// Entry returns a catalog entry by ID. It needs the local catalog
+func (c *BeqClient) Entry(id string) (models.BeqCatalog, bool) {
+	if c.Catalog == nil {
+		return models.BeqCatalog{}, false
+	}
+	return c.Catalog.Get(id)
+}
+
+// Pin saves an entry for a TMDB ID so it is loaded instead of searching the next time the title plays.
+// mvAdjust is only used if the entry is not in the local catalog
+func (c *BeqClient) Pin(tmdb, entryID string, mvAdjust float64) (models.PinState, error) {
+	if c.State == nil {
+		return models.PinState{}, errors.New("no state store to save the pin in")
+	}
+	if entryID == "" {
+		return models.PinState{}, errors.New("no entry ID to pin")
+	}
+	p := models.PinState{TMDB: tmdb, EntryID: entryID, MVAdjust: mvAdjust}
+	if e, ok := c.Entry(entryID); ok {
+		p.Title, p.Author, p.MVAdjust = e.Title, e.Author, e.MvAdjust
+	}
+	if err := c.State.SetPin(p); err != nil {
+		return models.PinState{}, err
+	}
+	log.Infof("Pinned entry %s (%s) to TMDB %s", entryID, p.Title, tmdb)
+
+	return p, nil
+}
+
+// pinnedEntry returns the entry pinned to the title being played, if any
+func (c *BeqClient) pinnedEntry(m *models.SearchRequest) (models.BeqCatalog, bool) {
+	if c.State == nil || m.TMDB == "" {
+		return models.BeqCatalog{}, false
+	}
+	p, ok := c.State.GetPin(m.TMDB)
+	if !ok {
+		return models.BeqCatalog{}, false
+	}
+	return models.BeqCatalog{ID: p.EntryID, Title: p.Title, Author: p.Author, MvAdjust: p.MVAdjust, MovieDbID: p.TMDB}, true
+}
@@ -0,0 +1,66 @@
+package ezbeq
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/state"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinnedEntry(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.enabled", true)
+
+	dev := &fakeDevice{}
+	c := newTestClient(t, dev)
+	store, err := state.NewStore(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(err)
+	c.State = store
+	c.Catalog = NewCatalog(filepath.Join(t.TempDir(), "catalog.json"))
+	c.Catalog.Load(testCatalogEntries())
+
+	// details come from the local catalog when it has the entry
+	e := testCatalogEntries()[1]
+	pin, err := c.Pin("1", e.ID, 0)
+	assert.NoError(err)
+	assert.Equal(e.Title, pin.Title)
+	assert.Equal(e.MvAdjust, pin.MVAdjust)
+
+	// the fake device can't search, so this only works if the pin is used
+	m := &models.SearchRequest{Devices: []string{"master"}, Slots: []int{1}, TMDB: "1", Codec: "DTS-X"}
+	assert.NoError(c.LoadBeqProfile(context.Background(), m))
+	assert.Equal(e.ID, m.EntryID)
+	assert.Equal(e.Title, dev.slots["1"])
+	assert.Equal(e.Title, store.GetProfiles()["master"].Title)
+
+	// other titles still search
+	m = &models.SearchRequest{Devices: []string{"master"}, Slots: []int{1}, TMDB: "2", Codec: "DTS-X"}
+	assert.Error(c.LoadBeqProfile(context.Background(), m))
+
+	_, err = c.Pin("1", "", 0)
+	assert.Error(err)
+}
+
+func TestManualLoadSkipsSearch(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.enabled", true)
+
+	dev := &fakeDevice{}
+	c := newTestClient(t, dev)
+	store, err := state.NewStore(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(err)
+	c.State = store
+	c.Catalog = NewCatalog(filepath.Join(t.TempDir(), "catalog.json"))
+	c.Catalog.Load(testCatalogEntries())
+
+	// an offset of 0 is valid for a manual load
+	e := testCatalogEntries()[0]
+	m := &models.SearchRequest{Devices: []string{"master"}, Slots: []int{2}, EntryID: e.ID, SkipSearch: true}
+	assert.NoError(c.LoadBeqProfile(context.Background(), m))
+	assert.Equal(e.Title, dev.slots["2"])
+	assert.Equal(e.Title, store.GetProfiles()["master"].Title)
+}
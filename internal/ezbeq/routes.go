@@ -0,0 +1,80 @@
+package ezbeq
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+)
+
+// Route sends a player's events to specific ezbeq devices and slots, e.g one per room
+type Route struct {
+	// Player is the plex player UUID or the jellyfin device ID, device name or client name
+	Player  string   `mapstructure:"player" json:"player"`
+	Devices []string `mapstructure:"devices" json:"devices"`
+	Slots   []int    `mapstructure:"slots" json:"slots"`
+}
+
+// getRoutes reads ezbeq.routes from the config
+func getRoutes() []Route {
+	var routes []Route
+	if err := config.UnmarshalKey("ezbeq.routes", &routes); err != nil {
+		log.Errorf("Error reading ezbeq routes, sending every player to every device: %v", err)
+		return nil
+	}
+	return routes
+}
+
+// deviceNames returns every device ezbeq knows about
+func (c *BeqClient) deviceNames() []string {
+	var names []string
+	for _, k := range c.Devices() {
+		names = append(names, k.Name)
+	}
+	return names
+}
+
+// RouteFor returns the devices and slots a player should load BEQ into. Players without a route use every device and ezbeq.slots.
+// Any of the ids can match so jellyfin can be routed by device or client
+func (c *BeqClient) RouteFor(ids ...string) ([]string, []int) {
+	devices := c.deviceNames()
+	slots := config.GetIntSlice("ezbeq.slots")
+
+	for _, r := range getRoutes() {
+		for _, id := range ids {
+			if id == "" || !strings.EqualFold(strings.TrimSpace(r.Player), strings.TrimSpace(id)) {
+				continue
+			}
+			log.Debugf("Routing player %s to devices %v slots %v", id, r.Devices, r.Slots)
+			if len(r.Devices) > 0 {
+				devices = r.Devices
+			}
+			if len(r.Slots) > 0 {
+				slots = r.Slots
+			}
+			return devices, slots
+		}
+	}
+
+	return devices, slots
+}
+
+// AllSlots returns ezbeq.slots and every slot used by a route, e.g to unload everything on startup
+func AllSlots() []int {
+	seen := make(map[int]bool)
+	var slots []int
+	add := func(s []int) {
+		for _, v := range s {
+			if !seen[v] {
+				seen[v] = true
+				slots = append(slots, v)
+			}
+		}
+	}
+	add(config.GetIntSlice("ezbeq.slots"))
+	for _, r := range getRoutes() {
+		add(r.Slots)
+	}
+	sort.Ints(slots)
+	return slots
+}
@@ -0,0 +1,42 @@
+package ezbeq
+
+import (
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteFor(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.slots", []int{1})
+	config.Set("ezbeq.routes", []map[string]interface{}{
+		{"player": "theater-uuid", "devices": []string{"master"}, "slots": []int{1, 2}},
+		{"player": "Living Room TV", "devices": []string{"living"}},
+		{"player": "bedroom", "slots": []int{4}},
+	})
+	t.Cleanup(func() { config.Set("ezbeq.routes", []map[string]interface{}{}) })
+
+	c := &BeqClient{DeviceInfo: []models.BeqDevices{{Name: "master"}, {Name: "living"}}}
+
+	type testStruct struct {
+		name    string
+		ids     []string
+		devices []string
+		slots   []int
+	}
+	tt := []testStruct{
+		{name: "plex uuid", ids: []string{"theater-uuid"}, devices: []string{"master"}, slots: []int{1, 2}},
+		{name: "global slots", ids: []string{"", "", "living room tv"}, devices: []string{"living"}, slots: []int{1}},
+		{name: "all devices", ids: []string{"bedroom"}, devices: []string{"master", "living"}, slots: []int{4}},
+		{name: "no route", ids: []string{"unknown"}, devices: []string{"master", "living"}, slots: []int{1}},
+	}
+	for _, tc := range tt {
+		devices, slots := c.RouteFor(tc.ids...)
+		assert.Equal(tc.devices, devices, tc.name)
+		assert.Equal(tc.slots, slots, tc.name)
+	}
+
+	assert.Equal([]int{1, 2, 4}, AllSlots())
+}
@@ -0,0 +1,82 @@
+package ezbeq
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// how well a TV entry covers the season being played, in place of the year for movies
+const (
+	scoreSeasonExact  = 20
+	scoreSeasonRange  = 15
+	scoreSeasonSeries = 10
+)
+
+// isTV reports if a catalog entry is for a series rather than a film
+func isTV(val models.BeqCatalog) bool {
+	return strings.EqualFold(strings.TrimSpace(val.ContentType), "tv")
+}
+
+// parseNumbers reads a list of numbers and ranges like 1, 1-3 or 1-3,5 as written in the catalog.
+// It returns false if it is blank or can't be read, which is treated as covering everything
+func parseNumbers(s string) ([][2]int, bool) {
+	var out [][2]int
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		from, err := parseNumber(lo)
+		if err != nil {
+			return nil, false
+		}
+		to := from
+		if isRange {
+			to, err = parseNumber(hi)
+			if err != nil {
+				return nil, false
+			}
+		}
+		out = append(out, [2]int{from, to})
+	}
+	return out, len(out) > 0
+}
+
+// parseNumber reads a season or episode number, which may be written like S01 or E01
+func parseNumber(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "s"), "e")
+	return strconv.Atoi(s)
+}
+
+// covers reports if n is in one of the ranges
+func covers(ranges [][2]int, n int) bool {
+	for _, r := range ranges {
+		if n >= r[0] && n <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// scoreSeason scores how well a TV entry covers the season and episode, 0 if it doesn't
+func scoreSeason(season, episode int, val models.BeqCatalog) (int, string) {
+	if episodes, ok := parseNumbers(val.Episodes); ok && episode > 0 && !covers(episodes, episode) {
+		return 0, fmt.Sprintf("episodes %s do not include episode %d", val.Episodes, episode)
+	}
+
+	seasons, ok := parseNumbers(val.Season)
+	switch {
+	case !ok:
+		return scoreSeasonSeries, "entry covers the whole series"
+	case !covers(seasons, season):
+		return 0, fmt.Sprintf("seasons %s do not include season %d", val.Season, season)
+	case len(seasons) == 1 && seasons[0][0] == seasons[0][1]:
+		return scoreSeasonExact, fmt.Sprintf("season %d matched", season)
+	default:
+		return scoreSeasonRange, fmt.Sprintf("season %d is in seasons %s", season, val.Season)
+	}
+}
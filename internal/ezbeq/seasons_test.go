@@ -0,0 +1,77 @@
+package ezbeq
+
+import (
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScoreSeason(t *testing.T) {
+	assert := assert.New(t)
+
+	type testStruct struct {
+		season   string
+		episodes string
+		episode  int
+		score    int
+	}
+	tt := []testStruct{
+		{season: "2", score: scoreSeasonExact},
+		{season: "S02", score: scoreSeasonExact},
+		{season: "1-3", score: scoreSeasonRange},
+		{season: "1, 2", score: scoreSeasonRange},
+		{season: "3", score: 0},
+		{season: "3-5", score: 0},
+		{season: "", score: scoreSeasonSeries},
+		{season: "2", episodes: "1-8", episode: 4, score: scoreSeasonExact},
+		{season: "2", episodes: "1-8", episode: 9, score: 0},
+		// unreadable is treated as covering everything
+		{season: "2", episodes: "all", episode: 9, score: scoreSeasonExact},
+	}
+	for _, tc := range tt {
+		episode := tc.episode
+		if episode == 0 {
+			episode = 1
+		}
+		score, reason := scoreSeason(2, episode, models.BeqCatalog{Season: tc.season, Episodes: tc.episodes})
+		assert.Equal(tc.score, score, "%s %s: %s", tc.season, tc.episodes, reason)
+	}
+}
+
+func TestMatchCatalogEpisodes(t *testing.T) {
+	assert := assert.New(t)
+	entries := []models.BeqCatalog{
+		{ID: "1", Title: "The Mandalorian", Year: 2019, AudioTypes: []string{"Atmos"}, MovieDbID: "82856", Author: "aron7awol", ContentType: "TV", Season: "1"},
+		{ID: "2", Title: "The Mandalorian", Year: 2020, AudioTypes: []string{"Atmos"}, MovieDbID: "82856", Author: "aron7awol", ContentType: "TV", Season: "2"},
+		{ID: "3", Title: "Chernobyl", Year: 2019, AudioTypes: []string{"DD+ Atmos"}, MovieDbID: "87108", Author: "mobe1969", ContentType: "TV"},
+		// a film with the same tmdb id as a series
+		{ID: "4", Title: "Some Film", Year: 2019, AudioTypes: []string{"Atmos"}, MovieDbID: "82856", Author: "aron7awol", ContentType: "film"},
+		{ID: "5", Title: "Andor", Year: 2022, AudioTypes: []string{"Atmos"}, MovieDbID: "83867", Author: "aron7awol", ContentType: "TV", Season: "1-2"},
+	}
+
+	type testStruct struct {
+		name string
+		m    models.SearchRequest
+		id   string
+	}
+	tt := []testStruct{
+		// the season picks the entry even though its year is not the series' year
+		{name: "season", m: models.SearchRequest{TMDB: "82856", Year: 2019, Season: 2, Episode: 3, Codec: "Atmos"}, id: "2"},
+		{name: "whole series", m: models.SearchRequest{TMDB: "87108", Year: 2019, Season: 1, Episode: 5, Codec: "DD+ Atmos"}, id: "3"},
+		{name: "season range", m: models.SearchRequest{TMDB: "83867", Year: 2022, Season: 2, Episode: 1, Codec: "Atmos"}, id: "5"},
+		{name: "missing season", m: models.SearchRequest{TMDB: "82856", Year: 2019, Season: 3, Episode: 1, Codec: "Atmos"}},
+		{name: "by title", m: models.SearchRequest{Title: "The Mandalorian", Year: 2019, Season: 1, Episode: 1, Codec: "Atmos"}, id: "1"},
+		// a movie never gets a TV entry
+		{name: "film", m: models.SearchRequest{TMDB: "82856", Year: 2019, Codec: "Atmos"}, id: "4"},
+	}
+	for _, tc := range tt {
+		res, _, err := matchCatalog(&tc.m, entries, []string{tc.m.Codec})
+		if tc.id == "" {
+			assert.Error(err, tc.name)
+			continue
+		}
+		assert.NoError(err, tc.name)
+		assert.Equal(tc.id, res.ID, tc.name)
+	}
+}
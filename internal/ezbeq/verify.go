@@ -0,0 +1,122 @@
+package ezbeq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/mqtt"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// the device can take a moment to report a new filter
+var (
+	verifyAttempts = 3
+	verifyDelay    = 1 * time.Second
+)
+
+// emptySlot is what ezbeq reports for a slot with no filter
+const emptySlot = "Empty"
+
+// VerifyError is returned when a device does not show what was loaded or unloaded
+type VerifyError struct {
+	// Unload is true if the filters were supposed to be removed
+	Unload     bool
+	Mismatches []string
+}
+
+func (e *VerifyError) Error() string {
+	action := "load"
+	if e.Unload {
+		action = "unload"
+	}
+	return fmt.Sprintf("ezbeq %s did not apply: %s", action, strings.Join(e.Mismatches, "; "))
+}
+
+// verifySlots reads back every targeted slot and checks it has the title loaded, or nothing if title is empty and unload is set
+func (c *BeqClient) verifySlots(ctx context.Context, m *models.SearchRequest, title string, unload bool) error {
+	var mismatches []string
+	for attempt := 1; attempt <= verifyAttempts; attempt++ {
+		devices, err := c.getDevices(ctx)
+		if err != nil {
+			return fmt.Errorf("error reading back ezbeq devices: %v", err)
+		}
+		mismatches = checkSlots(devices, m.Devices, m.Slots, title, unload)
+		if len(mismatches) == 0 {
+			log.Debugf("Verified ezbeq slots %v on %v", m.Slots, m.Devices)
+			return nil
+		}
+		log.Debugf("ezbeq slots not applied yet (attempt %d): %v", attempt, mismatches)
+		if attempt < verifyAttempts {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(verifyDelay):
+			}
+		}
+	}
+
+	return &VerifyError{Unload: unload, Mismatches: mismatches}
+}
+
+// checkSlots compares the device state with what is expected and describes each difference
+func checkSlots(devices map[string]models.BeqDevices, names []string, slots []int, title string, unload bool) []string {
+	var mismatches []string
+	for _, name := range names {
+		d, ok := devices[name]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("device %s not found", name))
+			continue
+		}
+		for _, id := range slots {
+			slot, ok := findSlot(d, id)
+			if !ok {
+				mismatches = append(mismatches, fmt.Sprintf("%s slot %d not found", name, id))
+				continue
+			}
+			empty := slot.Last == "" || strings.EqualFold(slot.Last, emptySlot)
+			switch {
+			case unload && !empty:
+				mismatches = append(mismatches, fmt.Sprintf("%s slot %d still has %s", name, id, slot.Last))
+			case !unload && empty:
+				mismatches = append(mismatches, fmt.Sprintf("%s slot %d is empty", name, id))
+			case !unload && title != "" && !strings.Contains(strings.ToLower(slot.Last), strings.ToLower(title)):
+				mismatches = append(mismatches, fmt.Sprintf("%s slot %d has %s instead of %s", name, id, slot.Last, title))
+			}
+		}
+	}
+	return mismatches
+}
+
+func findSlot(d models.BeqDevices, id int) (models.BeqSlots, bool) {
+	for _, s := range d.Slots {
+		if s.ID == fmt.Sprint(id) {
+			return s, true
+		}
+	}
+	return models.BeqSlots{}, false
+}
+
+// publishVerifyState sends the result of a read back to mqtt so automations can react to a half applied load or unload
+func publishVerifyState(unload bool, err error) {
+	topic := config.GetString("mqtt.topicBeqError")
+	if topic == "" {
+		return
+	}
+	action := "load"
+	if unload {
+		action = "unload"
+	}
+	msg := map[string]string{"state": "ok", "action": action}
+	if err != nil {
+		msg["state"] = "error"
+		msg["message"] = err.Error()
+	}
+	b, _ := json.Marshal(msg)
+	if err := mqtt.Publish(b, topic); err != nil {
+		log.Errorf("Error publishing beq verification state: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package ezbeq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSlots(t *testing.T) {
+	assert := assert.New(t)
+	devices := map[string]models.BeqDevices{
+		"master": {Name: "master", Slots: []models.BeqSlots{{ID: "1", Last: "Fast Five"}, {ID: "2", Last: "Empty"}}},
+	}
+
+	assert.Empty(checkSlots(devices, []string{"master"}, []int{1}, "Fast Five", false))
+	assert.Empty(checkSlots(devices, []string{"master"}, []int{1}, "", false))
+	assert.Empty(checkSlots(devices, []string{"master"}, []int{2}, "", true))
+
+	assert.Equal([]string{"master slot 1 has Fast Five instead of Jung_E"}, checkSlots(devices, []string{"master"}, []int{1}, "Jung_E", false))
+	assert.Equal([]string{"master slot 2 is empty"}, checkSlots(devices, []string{"master"}, []int{2}, "Fast Five", false))
+	assert.Equal([]string{"master slot 1 still has Fast Five"}, checkSlots(devices, []string{"master"}, []int{1, 2}, "", true))
+	assert.Equal([]string{"master slot 3 not found"}, checkSlots(devices, []string{"master"}, []int{3}, "", true))
+	assert.Equal([]string{"device living not found"}, checkSlots(devices, []string{"living"}, []int{1}, "", true))
+}
+
+func TestVerifyLoadUnload(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.enabled", true)
+	verifyDelay = time.Millisecond
+	t.Cleanup(func() { verifyDelay = time.Second })
+
+	dev := &fakeDevice{}
+	c := newTestClient(t, dev)
+	m := &models.SearchRequest{Devices: []string{"master"}, Slots: []int{1, 2}, EntryID: "123", MVAdjust: -1, SkipSearch: true}
+
+	assert.NoError(c.LoadBeqProfile(context.Background(), m))
+	assert.NoError(c.UnloadBeqProfile(context.Background(), m))
+
+	// the device ignores the change
+	assert.NoError(c.LoadBeqProfile(context.Background(), m))
+	dev.mu.Lock()
+	dev.ignore = true
+	dev.mu.Unlock()
+
+	err := c.UnloadBeqProfile(context.Background(), m)
+	var verr *VerifyError
+	assert.True(errors.As(err, &verr))
+	assert.True(verr.Unload)
+	assert.Len(verr.Mismatches, 2)
+	assert.Contains(err.Error(), "master slot 1 still has Entry 123")
+
+	dev.mu.Lock()
+	dev.slots = nil
+	dev.mu.Unlock()
+	err = c.LoadBeqProfile(context.Background(), m)
+	assert.True(errors.As(err, &verr))
+	assert.False(verr.Unload)
+}
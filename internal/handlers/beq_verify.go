@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/ezbeq"
+	"github.com/iloveicedgreentea/go-plex/internal/homeassistant"
+	"github.com/iloveicedgreentea/go-plex/internal/plan"
+)
+
+// eventTimeout bounds the ezbeq calls for one event, so a down ezbeq can't hold up the worker for the next one.
+// It is long enough to wait for HDMI sync before loading
+const eventTimeout = 2 * time.Minute
+
+// eventContext returns the context for handling one event
+func eventContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), eventTimeout)
+}
+
+// notifyBeqError sends a notification when ezbeq did not apply a load or unload. It is sent even without notifyOnLoad since it is a safety issue.
+// Returns true if err was a verification error
+func notifyBeqError(ctx context.Context, haClient *homeassistant.HomeAssistantClient, err error) bool {
+	var verr *ezbeq.VerifyError
+	if !errors.As(err, &verr) {
+		return false
+	}
+	if haClient == nil || !config.GetBool("homeAssistant.enabled") {
+		return true
+	}
+
+	msg := fmt.Sprintf("BEQ verification failed: %v", verr)
+	if verr.Unload {
+		msg += " -- Unsafe to play movies!"
+	}
+	if err := notify(ctx, haClient, msg); err != nil {
+		log.Errorf("Error sending notification: %v", err)
+	}
+	return true
+}
+
+// handleLoadError logs why a profile was not loaded and notifies if it is a safety issue. A blocked title is not an error
+func handleLoadError(ctx context.Context, haClient *homeassistant.HomeAssistantClient, err error) {
+	if errors.Is(err, ezbeq.ErrBlocked) {
+		log.Info(err)
+		plan.Record(ctx, plan.Skip, err.Error(), nil)
+		return
+	}
+	log.Error(err)
+	plan.Record(ctx, plan.Error, err.Error(), nil)
+	notifyBeqError(ctx, haClient, err)
+}
@@ -2,8 +2,31 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 	"github.com/gin-gonic/gin"
+	"github.com/iloveicedgreentea/go-plex/internal/avr"
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/ezbeq"
+	"github.com/iloveicedgreentea/go-plex/internal/mqtt"
 )
 func ProcessHealthcheckWebhookGin(c *gin.Context) {
 	c.String(http.StatusOK, "ok")
-}
\ No newline at end of file
+}
+
+// ProcessHealthStatusGin reports the state of the services we depend on
+func ProcessHealthStatusGin(c *gin.Context) {
+	status := gin.H{"ezbeq": ezbeq.Status()}
+	if config.GetBool("mqtt.enabled") {
+		status["mqtt"] = mqtt.Status()
+	}
+	if d := avr.LastDetection(); !d.At.IsZero() {
+		status["avrCodec"] = gin.H{
+			"codec":   d.Codec,
+			"reading": d.Reading,
+			"stable":  d.Stable,
+			"took":    d.Took.Round(time.Millisecond).String(),
+			"at":      d.At,
+		}
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,12 +11,12 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/iloveicedgreentea/go-plex/internal/avr"
-	"github.com/iloveicedgreentea/go-plex/internal/common"
 	"github.com/iloveicedgreentea/go-plex/internal/config"
 	"github.com/iloveicedgreentea/go-plex/internal/ezbeq"
 	"github.com/iloveicedgreentea/go-plex/internal/homeassistant"
 	"github.com/iloveicedgreentea/go-plex/internal/jellyfin"
-	"github.com/iloveicedgreentea/go-plex/internal/mqtt"
+	"github.com/iloveicedgreentea/go-plex/internal/plan"
+	"github.com/iloveicedgreentea/go-plex/internal/state"
 	"github.com/iloveicedgreentea/go-plex/models"
 )
 
@@ -44,7 +45,15 @@ func ProcessJfWebhook(jfChan chan<- models.JellyfinWebhook, c *gin.Context) {
 	jfChan <- payload
 }
 
-func jfEventRouter(jfClient *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.JellyfinWebhook, model *models.SearchRequest, skipActions *bool) {
+// jfPlayerID identifies the player a webhook came from
+func jfPlayerID(payload models.JellyfinWebhook) string {
+	if payload.DeviceID != "" {
+		return payload.DeviceID
+	}
+	return payload.ClientName
+}
+
+func jfEventRouter(ctx context.Context, jfClient *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.JellyfinWebhook, model *models.SearchRequest, skipActions *bool) {
 	// perform function via worker
 
 	clientUUID := payload.ClientName
@@ -52,6 +61,7 @@ func jfEventRouter(jfClient *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient
 
 	if !checkUUID(clientUUID, config.GetString("jellyfin.deviceUUIDFilter")) {
 		log.Infof("Got a webhook but Client UUID '%s' does not match enabled filter", clientUUID)
+		plan.Record(ctx, plan.Skip, fmt.Sprintf("client %s does not match the UUID filter", clientUUID), nil)
 		return
 	}
 
@@ -63,6 +73,7 @@ func jfEventRouter(jfClient *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient
 	data, err = jfClient.GetMetadata(payload.UserID, payload.ItemID)
 	if err != nil {
 		log.Errorf("Error getting metadata from jellyfin API: %v", err)
+		plan.Record(ctx, plan.Error, "could not get metadata from jellyfin", err.Error())
 		return
 	}
 
@@ -81,25 +92,35 @@ func jfEventRouter(jfClient *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient
 	model.Year = year
 	model.MediaType = data.Type
 	model.Edition = editionName
-	// this should be updated with every event
-	model.EntryID = beqClient.CurrentProfile
-	model.MVAdjust = beqClient.MasterVolume
+	// only set for episodes once the series is looked up
+	model.Season = 0
+	model.Episode = 0
+	// events always search (or use a pin), only manual loads skip it
+	model.SkipSearch = false
+	// only drive the devices and slots for this player's room
+	model.Devices, model.Slots = beqClient.RouteFor(payload.DeviceID, payload.DeviceName, payload.ClientName)
+	// this should be updated with every event, from what this room loaded
+	model.EntryID, model.MVAdjust = savedEntry(state.GetStore(), jfPlayerID(payload), model.Devices)
 
 	log.Debugf("Event Router: Using search model: %#v", model)
 	log.Debugf("Got notification type %s", payload.NotificationType)
 	if config.GetBool("ezbeq.useAVRCodecSearch") {
 		// TODO: rewrite this
 		c := avr.GetAVRClient(config.GetString("ezbeq.DenonIP"))
-		if c != nil {
-			codec, err = c.GetCodec()
+		// only starting needs the AVR ready, stop events just unload
+		ready := c != nil && (payload.NotificationType != "PlaybackStart" || preflightAVR(ctx, c, payload.DeviceID, payload.DeviceName, payload.ClientName))
+		if ready {
+			if payload.NotificationType == "PlaybackStart" {
+				codec, err = detectAVRCodec(ctx, c)
+			} else {
+				codec, err = avr.ReadCodec(c, avr.GetCodecMapper(config.GetString("ezbeq.avrbrand")))
+			}
 			if err != nil {
 				log.Errorf("Error getting codec from AVR: %v", err)
 			}
 			log.Debugf("Got codec from AVR: %s", codec)
-			// TODO: make generic function that looks at which AVR and maps correctly
-			codec = mapDenonToBeq(codec)
 		} else {
-			log.Error("Error getting AVR client. Trying to poll jellyfin")
+			log.Error("AVR is not available. Trying to poll jellyfin")
 			codec, err = jfClient.GetAudioCodec(data)
 			if err != nil {
 				log.Errorf("Error getting codec from jellyfin: %v", err)
@@ -117,64 +138,72 @@ func jfEventRouter(jfClient *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient
 	model.Codec = codec
 	// add title
 	model.Title = data.OriginalTitle
+	plan.Record(ctx, plan.Metadata, fmt.Sprintf("%s: %s (%d)", payload.NotificationType, model.Title, model.Year), model)
+	plan.Record(ctx, plan.Codec, model.Codec, nil)
 
 	switch payload.NotificationType {
 	// unload BEQ on pause OR stop because I never press stop, just pause and then back.
 	case "PlaybackStart":
 		// TODO: test start/resume/pause
-		jfMediaPlay(jfClient, beqClient, haClient, payload, model, false, data, skipActions)
+		jfMediaPlay(ctx, jfClient, beqClient, haClient, payload, model, false, data, skipActions)
 	case "PlaybackStop":
-		jfMediaStop(jfClient, beqClient, haClient, payload, model, false, data, skipActions)
+		jfMediaStop(ctx, jfClient, beqClient, haClient, payload, model, false, data, skipActions)
 	// really annoyingly jellyfin doesnt send a pause or resume event only progress every X seconds with a isPaused flag
 	// Jellyfin playback progress is way too buggy to support and makes absolutely no sense anyway
 	// case "PlaybackProgress":
 	// 	if payload.IsPaused == "true" {
-	// 		jfMediaPause(beqClient, haClient, payload, model, skipActions)
+	// 		jfMediaPause(ctx, beqClient, haClient, payload, model, skipActions)
 	// 	} else {
-	// 		jfMediaResume(jfClient, beqClient, haClient, payload, model, false, data, skipActions)
+	// 		jfMediaResume(ctx, jfClient, beqClient, haClient, payload, model, false, data, skipActions)
 	// 	}
 	default:
 		log.Warnf("Received unsupported webhook event. Nothing to do: %s", payload.NotificationType)
+		plan.Record(ctx, plan.Skip, fmt.Sprintf("event %s is not supported", payload.NotificationType), nil)
 	}
 }
 
-func jfMediaPlay(client *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.JellyfinWebhook, m *models.SearchRequest, useDenonCodec bool, data models.JellyfinMetadata, skipActions *bool) {
+func jfMediaPlay(ctx context.Context, client *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.JellyfinWebhook, m *models.SearchRequest, useDenonCodec bool, data models.JellyfinMetadata, skipActions *bool) {
 	log.Debug("Processing media play event")
 	wg := &sync.WaitGroup{}
 
 	// stop processing webhooks
 	*skipActions = true
-	err := mqtt.PublishWrapper(config.GetString("mqtt.topicplayingstatus"), "true")
+	err := publish(ctx, config.GetString("mqtt.topicplayingstatus"), "true")
 	if err != nil {
 		log.Error(err)
 	}
-	go common.ChangeLight("off")
+	changeLight(ctx, "off")
 	// go changeAspect(client, payload, wg)
-	go common.ChangeMasterVolume(m.MediaType)
+	changeMasterVolume(ctx, m.MediaType)
+	// the codec was already read from the AVR, so the sound mode can change now
+	applyAVRMediaSettings(ctx, m.MediaType)
 
 	// if not using denoncodec, do this in background
 	if !useDenonCodec {
 		wg.Add(1)
 		// sets skipActions to false on completion
-		go common.WaitForHDMISync(wg, skipActions, haClient, client)
+		go waitForHDMISync(ctx, wg, skipActions, haClient, client)
 	}
 
 	// always unload in case something is loaded from movie for tv
-	err = beqClient.UnloadBeqProfile(m)
+	err = beqClient.UnloadBeqProfile(ctx, m)
 	if err != nil {
 		log.Errorf("Error unloading beq on startup!! : %v", err)
+		notifyBeqError(ctx, haClient, err)
 		return
 	}
 
 	// if its a show and you dont want beq enabled, exit
-	if data.Type == showItemTitle {
+	if isEpisode(data.Type) {
 		if !config.GetBool("ezbeq.enableTvBeq") {
+			plan.Record(ctx, plan.Skip, "BEQ for TV shows is disabled", nil)
 			return
 		}
 	}
 
-	m.TMDB, err = client.GetJfTMDB(data)
-	if err != nil {
+	if isEpisode(data.Type) {
+		jfSetSeries(client, payload, data, m)
+	} else if m.TMDB, err = client.GetJfTMDB(data); err != nil {
 		if config.GetBool("jellyfin.skiptmdb") {
 			log.Warn("TMDB data not found. TMDB is allowed to be skipped")
 		} else {
@@ -182,16 +211,17 @@ func jfMediaPlay(client *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient, ha
 			return
 		}
 	}
-	err = beqClient.LoadBeqProfile(m)
+	err = beqClient.LoadBeqProfile(ctx, m)
 	if err != nil {
-		log.Error(err)
+		handleLoadError(ctx, haClient, err)
 		return
 	}
 	log.Info("BEQ profile loaded")
+	savePlayerState(ctx, jfPlayerID(payload), m)
 
 	// send notification of it loaded
 	if config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
-		err := haClient.SendNotification(fmt.Sprintf("BEQ Profile: Title - %s  (%s) // Codec %s", data.OriginalTitle, payload.Year, m.Codec))
+		err := notify(ctx, haClient, fmt.Sprintf("BEQ Profile: Title - %s  (%s) // Codec %s", data.OriginalTitle, payload.Year, m.Codec))
 		if err != nil {
 			log.Error()
 		}
@@ -202,42 +232,43 @@ func jfMediaPlay(client *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient, ha
 	log.Debug("goroutines complete")
 }
 
-func jfMediaStop(client *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.JellyfinWebhook, m *models.SearchRequest, useDenonCodec bool, data models.JellyfinMetadata, skipActions *bool) {
+func jfMediaStop(ctx context.Context, client *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.JellyfinWebhook, m *models.SearchRequest, useDenonCodec bool, data models.JellyfinMetadata, skipActions *bool) {
 	log.Debug("Processing media stop event")
-	err := mqtt.PublishWrapper(config.GetString("mqtt.topicplayingstatus"), "false")
+	err := publish(ctx, config.GetString("mqtt.topicplayingstatus"), "false")
 	if err != nil {
 		log.Error(err)
 	}
-	go common.ChangeLight("on")
+	changeLight(ctx, "on")
 
-	err = beqClient.UnloadBeqProfile(m)
+	err = beqClient.UnloadBeqProfile(ctx, m)
 	if err != nil {
 		log.Error(err)
-		if config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
-			err := haClient.SendNotification(fmt.Sprintf("Error UNLOADING profile: %v -- Unsafe to play movies!", err))
+		if !notifyBeqError(ctx, haClient, err) && config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
+			err := notify(ctx, haClient, fmt.Sprintf("Error UNLOADING profile: %v -- Unsafe to play movies!", err))
 			if err != nil {
 				log.Error()
 			}
 		}
 	}
 	log.Info("BEQ profile unloaded")
+	clearPlayerState(ctx, jfPlayerID(payload))
 }
 
-func jfMediaPause(beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.JellyfinWebhook, m *models.SearchRequest, skipActions *bool) {
+func jfMediaPause(ctx context.Context, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.JellyfinWebhook, m *models.SearchRequest, skipActions *bool) {
 	log.Debug("Processing media pause event")
 	if !*skipActions {
-		err := mqtt.PublishWrapper(config.GetString("mqtt.topicplayingstatus"), "false")
+		err := publish(ctx, config.GetString("mqtt.topicplayingstatus"), "false")
 		if err != nil {
 			log.Error(err)
 		}
 
-		go common.ChangeLight("on")
+		changeLight(ctx, "on")
 
-		err = beqClient.UnloadBeqProfile(m)
+		err = beqClient.UnloadBeqProfile(ctx, m)
 		if err != nil {
 			log.Error(err)
-			if config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
-				err := haClient.SendNotification(fmt.Sprintf("Error UNLOADING profile: %v -- Unsafe to play movies!", err))
+			if !notifyBeqError(ctx, haClient, err) && config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
+				err := notify(ctx, haClient, fmt.Sprintf("Error UNLOADING profile: %v -- Unsafe to play movies!", err))
 				if err != nil {
 					log.Error()
 				}
@@ -246,40 +277,42 @@ func jfMediaPause(beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssist
 		log.Info("BEQ profile unloaded")
 	}
 }
-func jfMediaResume(client *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.JellyfinWebhook, m *models.SearchRequest, useDenonCodec bool, data models.JellyfinMetadata, skipActions *bool) {
+func jfMediaResume(ctx context.Context, client *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.JellyfinWebhook, m *models.SearchRequest, useDenonCodec bool, data models.JellyfinMetadata, skipActions *bool) {
 	log.Debug("Processing media resume event")
 	if !*skipActions {
 		// mediaType string, codec string, edition string
 		// trigger lights
-		err := mqtt.PublishWrapper(config.GetString("mqtt.topicplayingstatus"), "true")
+		err := publish(ctx, config.GetString("mqtt.topicplayingstatus"), "true")
 		if err != nil {
 			log.Error(err)
 		}
-		go common.ChangeLight("off")
+		changeLight(ctx, "off")
 		// Changing on resume is disabled because its annoying if you changed it since playing
 		// go changeMasterVolume(vip, mediaType)
 
 		// allow skipping search to save time
 		// always unload in case something is loaded from movie for tv
-		err = beqClient.UnloadBeqProfile(m)
+		err = beqClient.UnloadBeqProfile(ctx, m)
 		if err != nil {
 			log.Errorf("Error on startup - unloading beq %v", err)
+			notifyBeqError(ctx, haClient, err)
 		}
-		if data.Type == showItemTitle {
+		if isEpisode(data.Type) {
 			if !config.GetBool("ezbeq.enableTvBeq") {
+				plan.Record(ctx, plan.Skip, "BEQ for TV shows is disabled", nil)
 				return
 			}
 		}
 		// get the tmdb id to match with ezbeq catalog
-		m.TMDB, err = client.GetJfTMDB(data)
-		if err != nil {
+		if isEpisode(data.Type) {
+			jfSetSeries(client, payload, data, m)
+		} else if m.TMDB, err = client.GetJfTMDB(data); err != nil {
 			log.Errorf("Error getting TMDB data from metadata: %v", err)
 			return
 		}
-		// if the server was restarted, cached data is lost
-		if m.Codec == "" {
-			log.Warn("No codec found in cache on resume. Was server restarted? Getting new codec")
-			log.Debug("Using jellyfin to get codec because its not cached")
+		// the model is shared by every player, so use what was saved for this one
+		if !restorePlayerState(jfPlayerID(payload), m) {
+			log.Debug("No saved playback state for this player, using jellyfin to get codec")
 			m.Codec, err = client.GetAudioCodec(data)
 			if err != nil {
 				log.Errorf("error getting codec from jellyfin, can't continue: %s", err)
@@ -291,16 +324,17 @@ func jfMediaResume(client *jellyfin.JellyfinClient, beqClient *ezbeq.BeqClient,
 			return
 		}
 
-		err = beqClient.LoadBeqProfile(m)
+		err = beqClient.LoadBeqProfile(ctx, m)
 		if err != nil {
-			log.Error(err)
+			handleLoadError(ctx, haClient, err)
 			return
 		}
 		log.Info("BEQ profile loaded")
+		savePlayerState(ctx, jfPlayerID(payload), m)
 
 		// send notification of it loaded
 		if config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
-			err := haClient.SendNotification(fmt.Sprintf("BEQ Profile: Title - %s  (%s) // Codec %s", data.OriginalTitle, payload.Year, m.Codec))
+			err := notify(ctx, haClient, fmt.Sprintf("BEQ Profile: Title - %s  (%s) // Codec %s", data.OriginalTitle, payload.Year, m.Codec))
 			if err != nil {
 				log.Error()
 			}
@@ -332,13 +366,13 @@ func JellyfinWorker(jfChan <-chan models.JellyfinWebhook, readyChan chan<- bool)
 	if err != nil {
 		log.Error(err)
 	}
-	log.Debugf("Discovered devices: %v", beqClient.DeviceInfo)
-	if len(beqClient.DeviceInfo) == 0 {
+	log.Debugf("Discovered devices: %v", beqClient.Devices())
+	if len(beqClient.Devices()) == 0 {
 		log.Error("No devices found. Please check your ezbeq settings!")
 	}
 
 	// get the device names from the API call
-	for _, k := range beqClient.DeviceInfo {
+	for _, k := range beqClient.Devices() {
 		log.Debugf("adding device %s", k.Name)
 		deviceNames = append(deviceNames, k.Name)
 	}
@@ -347,14 +381,17 @@ func JellyfinWorker(jfChan <-chan models.JellyfinWebhook, readyChan chan<- bool)
 	model = &models.SearchRequest{
 		DryrunMode: config.GetBool("ezbeq.dryRun"),
 		Devices:    deviceNames,
-		Slots:      config.GetIntSlice("ezbeq.slots"),
+		// every slot any player uses, events set their own route
+		Slots:      ezbeq.AllSlots(),
 		// try to skip by default
 		SkipSearch: true,
 		PreferredAuthor: config.GetString("ezbeq.preferredAuthor"),
 	}
 
 	// unload existing profile for safety
-	err = beqClient.UnloadBeqProfile(model)
+	ctx, cancel := eventContext()
+	err = beqClient.UnloadBeqProfile(ctx, model)
+	cancel()
 	if err != nil {
 		log.Errorf("Error on startup - unloading beq %v", err)
 	}
@@ -379,7 +416,9 @@ func JellyfinWorker(jfChan <-chan models.JellyfinWebhook, readyChan chan<- bool)
 		// if its not an empty struct
 		if i != (models.JellyfinWebhook{}) {
 			// get metadata
-			jfEventRouter(jellyfinClient, beqClient, haClient, i, model, skipActions)
+			ctx, cancel := eventContext()
+			jfEventRouter(ctx, jellyfinClient, beqClient, haClient, i, model, skipActions)
+			cancel()
 		} else {
 			log.Warning("Received empty payload, skipping")
 		}
@@ -388,3 +427,27 @@ func JellyfinWorker(jfChan <-chan models.JellyfinWebhook, readyChan chan<- bool)
 
 	log.Info("JellyfinWorker worker stopped")
 }
+
+// jfSetSeries points the search at the series an episode belongs to, see setPlexTitle
+func jfSetSeries(client *jellyfin.JellyfinClient, payload models.JellyfinWebhook, data models.JellyfinMetadata, m *models.SearchRequest) {
+	m.Title = data.SeriesName
+	m.Season = data.ParentIndexNumber
+	m.Episode = data.IndexNumber
+	// the episode's own ids and year are not in the catalog
+	m.TMDB = ""
+	series, err := client.GetMetadata(payload.UserID, data.SeriesID)
+	if err != nil {
+		log.Warnf("Could not get series metadata for %s, searching by title: %v", m.Title, err)
+		return
+	}
+	if series.OriginalTitle != "" {
+		m.Title = series.OriginalTitle
+	}
+	if series.ProductionYear != 0 {
+		m.Year = series.ProductionYear
+	}
+	if m.TMDB, err = client.GetJfTMDB(series); err != nil {
+		log.Warnf("TMDB id not found in Jellyfin for %s, searching by title", m.Title)
+	}
+	log.Debugf("Episode S%02dE%02d of %s (%d), tmdb %s", m.Season, m.Episode, m.Title, m.Year, m.TMDB)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"strings"
@@ -13,12 +14,12 @@ import (
 // https://minidsp-rs.pages.dev/cli/master/mute
 
 // based on event type, determine what to do
-func minidspRouter(payload models.MinidspRequest, beqClient *ezbeq.BeqClient) {
+func minidspRouter(ctx context.Context, payload models.MinidspRequest, beqClient *ezbeq.BeqClient) {
 	switch {
 	case strings.Contains(payload.Command, "off"):
-		muteOff(beqClient)
+		muteOff(ctx, beqClient)
 	case strings.Contains(payload.Command, "on"):
-		muteOn(beqClient)
+		muteOn(ctx, beqClient)
 	default:
 		log.Warnf("Minidsp: unknown command %s", payload.Command)
 	}
@@ -50,15 +51,15 @@ func minidspRouter(payload models.MinidspRequest, beqClient *ezbeq.BeqClient) {
 // }
 
 // muteOn mutes all inputs for minidsp
-func muteOn(beqClient *ezbeq.BeqClient) {
+func muteOn(ctx context.Context, beqClient *ezbeq.BeqClient) {
 	log.Debug("Minidsp: running mute on")
-	beqClient.MuteCommand(true)
+	beqClient.MuteCommand(ctx, true)
 }
 
 // muteOff unmutes all inputs for minidsp
-func muteOff(beqClient *ezbeq.BeqClient) {
+func muteOff(ctx context.Context, beqClient *ezbeq.BeqClient) {
 	log.Debug("Minidsp: running mute off")
-	beqClient.MuteCommand(false)
+	beqClient.MuteCommand(ctx, false)
 }
 
 // process webhook 
@@ -96,6 +97,8 @@ func MiniDspWorker(minidspChan <-chan models.MinidspRequest, readyChan chan<- bo
 	// block forever until closed so it will wait in background for work
 	for i := range minidspChan {
 		// determine what to do
-		minidspRouter(i, beqClient)
+		ctx, cancel := eventContext()
+		minidspRouter(ctx, i, beqClient)
+		cancel()
 	}
 }
\ No newline at end of file
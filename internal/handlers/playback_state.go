@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/iloveicedgreentea/go-plex/internal/plan"
+	"github.com/iloveicedgreentea/go-plex/internal/state"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// the search model is shared per worker and lost on restart, so what each player loaded is also kept on disk
+
+// savePlayerState persists what was loaded for a player so resume works after a restart
+func savePlayerState(ctx context.Context, playerID string, m *models.SearchRequest) {
+	if playerID == "" {
+		return
+	}
+	p := models.PlayerState{
+		Title:     m.Title,
+		TMDB:      m.TMDB,
+		Year:      m.Year,
+		Codec:     m.Codec,
+		Edition:   m.Edition,
+		EntryID:   m.EntryID,
+		MVAdjust:  m.MVAdjust,
+		MediaType: m.MediaType,
+		Season:    m.Season,
+	}
+	if plan.Record(ctx, plan.State, "save playback state for "+playerID, p) {
+		return
+	}
+	err := state.GetStore().SetPlayer(playerID, p)
+	if err != nil {
+		log.Warnf("Could not save playback state for %s: %v", playerID, err)
+	}
+}
+
+// restorePlayerState fills in the codec and entry saved for a player if it was playing the same title. Returns true if restored
+func restorePlayerState(playerID string, m *models.SearchRequest) bool {
+	p, ok := state.GetStore().GetPlayer(playerID)
+	if !ok {
+		return false
+	}
+	// don't resume with a profile from a different title
+	if p.TMDB != m.TMDB || p.Year != m.Year || p.Season != m.Season {
+		log.Debugf("Saved state for %s is for %s (%d), not resuming with it", playerID, p.Title, p.Year)
+		return false
+	}
+	log.Infof("Restored saved playback state for %s: codec %s, entry %s", playerID, p.Codec, p.EntryID)
+	m.Codec = p.Codec
+	m.EntryID = p.EntryID
+	m.MVAdjust = p.MVAdjust
+	if m.Edition == "" {
+		m.Edition = p.Edition
+	}
+
+	return true
+}
+
+// savedEntry returns the entry and master volume adjustment last loaded for a player, or into one of its devices if the player
+// has nothing saved. The client only knows what was loaded last in any room
+func savedEntry(s *state.Store, playerID string, devices []string) (string, float64) {
+	if p, ok := s.GetPlayer(playerID); ok && p.EntryID != "" {
+		return p.EntryID, p.MVAdjust
+	}
+	profiles := s.GetProfiles()
+	for _, d := range devices {
+		if p, ok := profiles[d]; ok && p.EntryID != "" {
+			return p.EntryID, p.MVAdjust
+		}
+	}
+	return "", 0
+}
+
+// clearPlayerState forgets a player once it stopped
+func clearPlayerState(ctx context.Context, playerID string) {
+	if playerID == "" {
+		return
+	}
+	if plan.Record(ctx, plan.State, "clear playback state for "+playerID, nil) {
+		return
+	}
+	if err := state.GetStore().DeletePlayer(playerID); err != nil {
+		log.Warnf("Could not clear playback state for %s: %v", playerID, err)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/state"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSavedEntry(t *testing.T) {
+	assert := assert.New(t)
+	s, err := state.NewStore(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(err)
+	assert.NoError(s.SetPlayer("room-a", models.PlayerState{EntryID: "a_1", MVAdjust: -1}))
+	assert.NoError(s.SetProfile("living", models.ProfileState{EntryID: "b_2", MVAdjust: -2, Loaded: true}))
+	// room b loaded last, room a keeps its own entry
+	assert.NoError(s.SetProfile("master", models.ProfileState{EntryID: "b_3", MVAdjust: -3, Loaded: true}))
+
+	entry, mv := savedEntry(s, "room-a", []string{"master"})
+	assert.Equal("a_1", entry)
+	assert.Equal(-1.0, mv)
+
+	// a player with nothing saved uses what is in its devices
+	entry, mv = savedEntry(s, "room-b", []string{"living"})
+	assert.Equal("b_2", entry)
+	assert.Equal(-2.0, mv)
+
+	entry, mv = savedEntry(s, "room-c", []string{"other"})
+	assert.Empty(entry)
+	assert.Zero(mv)
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"context"
 	// "encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	// "strconv"
 	"strings"
@@ -18,8 +20,9 @@ import (
 	"github.com/iloveicedgreentea/go-plex/internal/ezbeq"
 	"github.com/iloveicedgreentea/go-plex/internal/homeassistant"
 	"github.com/iloveicedgreentea/go-plex/internal/logger"
-	"github.com/iloveicedgreentea/go-plex/internal/mqtt"
+	"github.com/iloveicedgreentea/go-plex/internal/plan"
 	"github.com/iloveicedgreentea/go-plex/internal/plex"
+	"github.com/iloveicedgreentea/go-plex/internal/state"
 	"github.com/iloveicedgreentea/go-plex/models"
 	"golang.org/x/exp/slices"
 )
@@ -27,6 +30,16 @@ import (
 const showItemTitle = "Episode"
 const movieItemTitle = "Movie"
 
+// isEpisode compares without case since plex sends episode and jellyfin Episode
+func isEpisode(mediaType string) bool {
+	return strings.EqualFold(mediaType, showItemTitle)
+}
+
+// isMovie compares without case since plex sends movie and jellyfin Movie
+func isMovie(mediaType string) bool {
+	return strings.EqualFold(mediaType, movieItemTitle)
+}
+
 var log = logger.GetLogger()
 
 // Sends the payload to the channel for background processing
@@ -57,7 +70,7 @@ func ProcessWebhook(plexChan chan<- models.PlexWebhookPayload, c *gin.Context) {
 		// only respond to events on a particular account if you share servers and only for movies and shows
 		// TODO: decodedPayload.Account.Title seems to always map to server owner not player account
 		if userID == "" || decodedPayload.Account.Title == userID {
-			if decodedPayload.Metadata.Type == movieItemTitle || decodedPayload.Metadata.Type == showItemTitle {
+			if isMovie(decodedPayload.Metadata.Type) || isEpisode(decodedPayload.Metadata.Type) {
 				select {
 				case plexChan <- decodedPayload:
 					// send succeeded
@@ -81,41 +94,42 @@ func ProcessWebhook(plexChan chan<- models.PlexWebhookPayload, c *gin.Context) {
 }
 
 // does plex send stop if you exit with back button? - Yes, with X for mobile player as well
-func mediaStop(beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.PlexWebhookPayload, m *models.SearchRequest) {
-	err := mqtt.PublishWrapper(config.GetString("mqtt.topicplayingstatus"), "false")
+func mediaStop(ctx context.Context, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.PlexWebhookPayload, m *models.SearchRequest) {
+	err := publish(ctx, config.GetString("mqtt.topicplayingstatus"), "false")
 	if err != nil {
 		log.Error(err)
 	}
-	go common.ChangeLight("on")
+	changeLight(ctx, "on")
 
-	err = beqClient.UnloadBeqProfile(m)
+	err = beqClient.UnloadBeqProfile(ctx, m)
 	if err != nil {
 		log.Error(err)
-		if config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
-			err := haClient.SendNotification(fmt.Sprintf("Error UNLOADING profile: %v -- Unsafe to play movies!", err))
+		if !notifyBeqError(ctx, haClient, err) && config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
+			err := notify(ctx, haClient, fmt.Sprintf("Error UNLOADING profile: %v -- Unsafe to play movies!", err))
 			if err != nil {
 				log.Error()
 			}
 		}
 	}
 	log.Info("BEQ profile unloaded")
+	clearPlayerState(ctx, payload.Player.UUID)
 }
 
 // pause only happens with literally pausing
-func mediaPause(beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.PlexWebhookPayload, m *models.SearchRequest, skipActions *bool) {
+func mediaPause(ctx context.Context, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.PlexWebhookPayload, m *models.SearchRequest, skipActions *bool) {
 	if !*skipActions {
-		err := mqtt.PublishWrapper(config.GetString("mqtt.topicplayingstatus"), "false")
+		err := publish(ctx, config.GetString("mqtt.topicplayingstatus"), "false")
 		if err != nil {
 			log.Error(err)
 		}
 
-		go common.ChangeLight("on")
+		changeLight(ctx, "on")
 
-		err = beqClient.UnloadBeqProfile(m)
+		err = beqClient.UnloadBeqProfile(ctx, m)
 		if err != nil {
 			log.Error(err)
-			if config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
-				err := haClient.SendNotification(fmt.Sprintf("Error UNLOADING profile: %v -- Unsafe to play movies!", err))
+			if !notifyBeqError(ctx, haClient, err) && config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
+				err := notify(ctx, haClient, fmt.Sprintf("Error UNLOADING profile: %v -- Unsafe to play movies!", err))
 				if err != nil {
 					log.Error()
 				}
@@ -141,25 +155,28 @@ func interfaceRemote(cmd string, c *homeassistant.HomeAssistantClient) error {
 }
 
 // play is both the "resume" button and play
-func mediaPlay(client *plex.PlexClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, avrClient avr.AVRClient, payload models.PlexWebhookPayload, m *models.SearchRequest, useAvrCodec bool, data models.MediaContainer, skipActions *bool, wg *sync.WaitGroup) {
-	go common.ChangeLight("off")
-	go common.ChangeMasterVolume(m.MediaType)
+func mediaPlay(ctx context.Context, client *plex.PlexClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, avrClient avr.AVRClient, payload models.PlexWebhookPayload, m *models.SearchRequest, useAvrCodec bool, data models.MediaContainer, skipActions *bool, wg *sync.WaitGroup) {
+	changeLight(ctx, "off")
+	changeMasterVolume(ctx, m.MediaType)
 	var err error
+	// the AVR reads garbage while it is off or on another input
+	if useAvrCodec && !preflightAVR(ctx, avrClient, payload.Player.UUID, payload.Player.Title) {
+		log.Warn("AVR is not ready, using plex to get codec")
+		useAvrCodec = false
+	}
 	// slower but more accurate
 	// TODO: abstract library this for any AVR
 	if useAvrCodec {
 		// TODO: make below a function
 		// wait for sync
 		wg.Add(1)
-		common.WaitForHDMISync(wg, skipActions, haClient, client)
-		// denon needs time to show mutli ch in as atmos
-		// TODO: test this
-		time.Sleep(5 * time.Second)
+		waitForHDMISync(ctx, wg, skipActions, haClient, client)
 
-		// get the codec from avr
-		m.Codec, err = avrClient.GetCodec()
+		// get the codec from avr once it settles, denon shows multi ch in for a while before atmos
+		m.Codec, err = detectAVRCodec(ctx, avrClient)
 		if err != nil {
 			log.Errorf("error getting codec from denon, can't continue: %s", err)
+			applyAVRMediaSettings(ctx, m.MediaType)
 			return
 		}
 
@@ -173,22 +190,27 @@ func mediaPlay(client *plex.PlexClient, beqClient *ezbeq.BeqClient, haClient *ho
 			// if enabled, stop playing
 			if config.GetBool("ezbeq.stopPlexIfMismatch") {
 				log.Debug("Stopping plex because codec is not playing")
-				err := common.PlaybackInterface("stop", client)
-				if err != nil {
-					log.Errorf("Error stopping plex: %v", err)
+				if !plan.Record(ctx, plan.Player, "stop playback, codec is not playing", nil) {
+					err := common.PlaybackInterface("stop", client)
+					if err != nil {
+						log.Errorf("Error stopping plex: %v", err)
+					}
 				}
 			}
 
 			log.Error("Expected codec is not playing! Please check your AVR and Plex settings!")
 			if config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
-				err := haClient.SendNotification(fmt.Sprintf("Wrong codec is playing. Expected codec %s but got %v", m.Codec, expectedCodec))
+				err := notify(ctx, haClient, fmt.Sprintf("Wrong codec is playing. Expected codec %s but got %v", m.Codec, expectedCodec))
 				if err != nil {
 					log.Error(err)
 				}
 			}
 		}
+		// the sound mode changes what the AVR reports, so it is set once the codec is read
+		applyAVRMediaSettings(ctx, m.MediaType)
 
 	} else {
+		applyAVRMediaSettings(ctx, m.MediaType)
 		log.Debug("Using plex to get codec")
 		m.Codec, err = client.GetAudioCodec(data)
 		if err != nil {
@@ -198,24 +220,27 @@ func mediaPlay(client *plex.PlexClient, beqClient *ezbeq.BeqClient, haClient *ho
 	}
 
 	log.Debugf("Found codec: %s", m.Codec)
+	plan.Record(ctx, plan.Codec, m.Codec, nil)
 	// if its a show and you dont want beq enabled, exit
-	if payload.Metadata.Type == showItemTitle {
+	if isEpisode(payload.Metadata.Type) {
 		if !config.GetBool("ezbeq.enableTvBeq") {
+			plan.Record(ctx, plan.Skip, "BEQ for TV shows is disabled", nil)
 			return
 		}
 	}
 
-	m.TMDB = getPlexMovieDb(payload)
-	err = beqClient.LoadBeqProfile(m)
+	setPlexTitle(client, payload, m)
+	err = beqClient.LoadBeqProfile(ctx, m)
 	if err != nil {
-		log.Error(err)
+		handleLoadError(ctx, haClient, err)
 		return
 	}
 	log.Info("BEQ profile loaded")
+	savePlayerState(ctx, payload.Player.UUID, m)
 
 	// send notification of it loaded
 	if config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
-		err := haClient.SendNotification(fmt.Sprintf("BEQ Profile: Title - %s  (%d) // Codec %s", payload.Metadata.Title, payload.Metadata.Year, m.Codec))
+		err := notify(ctx, haClient, fmt.Sprintf("BEQ Profile: Title - %s  (%d) // Codec %s", payload.Metadata.Title, payload.Metadata.Year, m.Codec))
 		if err != nil {
 			log.Error()
 		}
@@ -227,35 +252,36 @@ func mediaPlay(client *plex.PlexClient, beqClient *ezbeq.BeqClient, haClient *ho
 }
 
 // resume is only after pausing as long as the media item is still active
-func mediaResume(client *plex.PlexClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.PlexWebhookPayload, m *models.SearchRequest, data models.MediaContainer, skipActions *bool) {
+func mediaResume(ctx context.Context, client *plex.PlexClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, payload models.PlexWebhookPayload, m *models.SearchRequest, data models.MediaContainer, skipActions *bool) {
 	if !*skipActions {
 		// mediaType string, codec string, edition string
 		// trigger lights
-		err := mqtt.PublishWrapper(config.GetString("mqtt.topicplayingstatus"), "true")
+		err := publish(ctx, config.GetString("mqtt.topicplayingstatus"), "true")
 		if err != nil {
 			log.Error(err)
 		}
-		go common.ChangeLight("off")
+		changeLight(ctx, "off")
 		// Changing on resume is disabled because its annoying if you changed it since playing
 		// go changeMasterVolume(vip, mediaType)
 
 		// allow skipping search to save time
 		// always unload in case something is loaded from movie for tv
-		err = beqClient.UnloadBeqProfile(m)
+		err = beqClient.UnloadBeqProfile(ctx, m)
 		if err != nil {
 			log.Errorf("Error on startup - unloading beq %v", err)
+			notifyBeqError(ctx, haClient, err)
 		}
-		if payload.Metadata.Type == showItemTitle {
+		if isEpisode(payload.Metadata.Type) {
 			if !config.GetBool("ezbeq.enableTvBeq") {
+				plan.Record(ctx, plan.Skip, "BEQ for TV shows is disabled", nil)
 				return
 			}
 		}
 		// get the tmdb id to match with ezbeq catalog
-		m.TMDB = getPlexMovieDb(payload)
-		// if the server was restarted, cached data is lost
-		if m.Codec == "" {
-			log.Warn("No codec found in cache on resume. Was server restarted? Getting new codec")
-			log.Debug("Using plex to get codec because its not cached")
+		setPlexTitle(client, payload, m)
+		// the model is shared by every player, so use what was saved for this one
+		if !restorePlayerState(payload.Player.UUID, m) {
+			log.Debug("No saved playback state for this player, using plex to get codec")
 			m.Codec, err = client.GetAudioCodec(data)
 			if err != nil {
 				log.Errorf("error getting codec from plex, can't continue: %s", err)
@@ -267,16 +293,17 @@ func mediaResume(client *plex.PlexClient, beqClient *ezbeq.BeqClient, haClient *
 			return
 		}
 
-		err = beqClient.LoadBeqProfile(m)
+		err = beqClient.LoadBeqProfile(ctx, m)
 		if err != nil {
-			log.Error(err)
+			handleLoadError(ctx, haClient, err)
 			return
 		}
 		log.Info("BEQ profile loaded")
+		savePlayerState(ctx, payload.Player.UUID, m)
 
 		// send notification of it loaded
 		if config.GetBool("ezbeq.notifyOnLoad") && config.GetBool("homeAssistant.enabled") {
-			err := haClient.SendNotification(fmt.Sprintf("BEQ Profile: Title - %s  (%d) // Codec %s", payload.Metadata.Title, payload.Metadata.Year, m.Codec))
+			err := notify(ctx, haClient, fmt.Sprintf("BEQ Profile: Title - %s  (%d) // Codec %s", payload.Metadata.Title, payload.Metadata.Year, m.Codec))
 			if err != nil {
 				log.Error()
 			}
@@ -290,35 +317,6 @@ func mediaScrobble() {
 	// go changeLight(vip, "on")
 }
 
-// getEditionName tries to extract the edition from plex or file name. Assumes you have well named files
-// Returned types, Unrated, Ultimate, Theatrical, Extended, Director, Criterion
-func getEditionName(data models.MediaContainer) string {
-	edition := data.Video.EditionTitle
-	fileName := strings.ToLower(data.Video.Media.Part.File)
-
-	// if there is an edition from plex metadata, use it
-	if edition != "" {
-		return edition
-	}
-	// otherwise try to extract from file name
-	switch {
-	case strings.Contains(fileName, "extended"):
-		return "Extended"
-	case strings.Contains(fileName, "unrated"):
-		return "Unrated"
-	case strings.Contains(fileName, "theatrical"):
-		return "Theatrical"
-	case strings.Contains(fileName, "ultimate"):
-		return "Ultimate"
-	case strings.Contains(fileName, "director"):
-		return "Director"
-	case strings.Contains(fileName, "criterion"):
-		return "Criterion"
-	default:
-		return ""
-	}
-}
-
 // ensure the client matches so it doesnt trigger from unwanted clients
 func checkUUID(clientUUID string, filterConfig string) bool {
 
@@ -341,7 +339,7 @@ func checkUUID(clientUUID string, filterConfig string) bool {
 }
 
 // based on event type, determine what to do
-func eventRouter(plexClient *plex.PlexClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, avrClient avr.AVRClient, useAvrCodec bool, payload models.PlexWebhookPayload, model *models.SearchRequest, skipActions *bool) {
+func eventRouter(ctx context.Context, plexClient *plex.PlexClient, beqClient *ezbeq.BeqClient, haClient *homeassistant.HomeAssistantClient, avrClient avr.AVRClient, useAvrCodec bool, payload models.PlexWebhookPayload, model *models.SearchRequest, skipActions *bool) {
 	// perform function via worker
 
 	clientUUID := payload.Player.UUID
@@ -349,6 +347,7 @@ func eventRouter(plexClient *plex.PlexClient, beqClient *ezbeq.BeqClient, haClie
 
 	if !checkUUID(clientUUID, config.GetString("plex.deviceUUIDFilter")) {
 		log.Infof("Got a webhook but Client UUID '%s' does not match enabled filter", clientUUID)
+		plan.Record(ctx, plan.Skip, fmt.Sprintf("client %s does not match the UUID filter", clientUUID), nil)
 		return
 	}
 
@@ -368,10 +367,11 @@ func eventRouter(plexClient *plex.PlexClient, beqClient *ezbeq.BeqClient, haClie
 		} else {
 			log.Errorf("Error getting media data from plex: %s", err)
 		}
+		plan.Record(ctx, plan.Error, "could not get media data from plex", err.Error())
 		return
 	} else {
 		// get the edition name
-		editionName = getEditionName(data)
+		editionName = plexClient.GetEdition(data)
 		log.Debugf("Event Router: Found edition: %s", editionName)
 	}
 
@@ -379,11 +379,19 @@ func eventRouter(plexClient *plex.PlexClient, beqClient *ezbeq.BeqClient, haClie
 
 	// mutate with data from plex
 	model.Year = payload.Metadata.Year
+	model.Title = payload.Metadata.Title
 	model.MediaType = payload.Metadata.Type
 	model.Edition = editionName
-	// this should be updated with every event
-	model.EntryID = beqClient.CurrentProfile
-	model.MVAdjust = beqClient.MasterVolume
+	// only set for episodes once the series is looked up
+	model.Season = 0
+	model.Episode = 0
+	// events always search (or use a pin), only manual loads skip it
+	model.SkipSearch = false
+	// only drive the devices and slots for this player's room
+	model.Devices, model.Slots = beqClient.RouteFor(clientUUID)
+	// this should be updated with every event, from what this room loaded
+	model.EntryID, model.MVAdjust = savedEntry(state.GetStore(), clientUUID, model.Devices)
+	plan.Record(ctx, plan.Metadata, fmt.Sprintf("%s: %s (%d)", payload.Event, model.Title, model.Year), model)
 
 	log.Debugf("Event Router: Using search model: %#v", model)
 	switch payload.Event {
@@ -392,23 +400,52 @@ func eventRouter(plexClient *plex.PlexClient, beqClient *ezbeq.BeqClient, haClie
 	case "media.play":
 		log.Debug("Event Router: media.play received")
 		wg := &sync.WaitGroup{}
-		mediaPlay(plexClient, beqClient, haClient, avrClient, payload, model, useAvrCodec, data, skipActions, wg)
+		mediaPlay(ctx, plexClient, beqClient, haClient, avrClient, payload, model, useAvrCodec, data, skipActions, wg)
 	case "media.stop":
 		log.Debug("Event Router: media.stop received")
-		mediaStop(beqClient, haClient, payload, model)
+		mediaStop(ctx, beqClient, haClient, payload, model)
 	case "media.pause":
 		log.Debug("Event Router: media.pause received")
-		mediaPause(beqClient, haClient, payload, model, skipActions)
+		mediaPause(ctx, beqClient, haClient, payload, model, skipActions)
 	// Pressing the 'resume' button in plex is media.play
 	case "media.resume":
 		log.Debug("Event Router: media.resume received")
-		mediaResume(plexClient, beqClient, haClient, payload, model, data, skipActions)
+		mediaResume(ctx, plexClient, beqClient, haClient, payload, model, data, skipActions)
 	case "media.scrobble":
 		log.Debug("Scrobble received")
 		mediaScrobble()
 	default:
 		log.Debugf("Received unsupported event: %s", payload.Event)
+		plan.Record(ctx, plan.Skip, fmt.Sprintf("event %s is not supported", payload.Event), nil)
+	}
+}
+
+// setPlexTitle sets what the catalog is searched for. The catalog has entries per series and season, not per episode,
+// so for an episode it is the show's title, tmdb ID and year with the season and episode number
+func setPlexTitle(client *plex.PlexClient, payload models.PlexWebhookPayload, m *models.SearchRequest) {
+	if !isEpisode(payload.Metadata.Type) {
+		m.TMDB = getPlexMovieDb(payload)
+		return
+	}
+
+	m.Title = payload.Metadata.GrandparentTitle
+	m.Season = payload.Metadata.ParentIndex
+	m.Episode = payload.Metadata.Index
+	// the episode's own ids and year are not in the catalog
+	m.TMDB = ""
+	show, err := client.GetShowData(payload.Metadata.GrandparentKey)
+	if err != nil {
+		log.Warnf("Could not get show metadata for %s, searching by title: %v", m.Title, err)
+		return
+	}
+	m.TMDB = plex.ShowTMDB(show)
+	if m.TMDB == "" {
+		log.Warnf("TMDB id not found in Plex for %s, searching by title", m.Title)
+	}
+	if year, err := strconv.Atoi(show.Directory.Year); err == nil {
+		m.Year = year
 	}
+	log.Debugf("Episode S%02dE%02d of %s (%d), tmdb %s", m.Season, m.Episode, m.Title, m.Year, m.TMDB)
 }
 
 // get the imdb ID from plex metadata
@@ -506,13 +543,13 @@ func PlexWorker(plexChan <-chan models.PlexWebhookPayload, readyChan chan<- bool
 	if err != nil {
 		log.Error(err)
 	}
-	log.Debugf("Discovered devices: %v", beqClient.DeviceInfo)
-	if len(beqClient.DeviceInfo) == 0 {
+	log.Debugf("Discovered devices: %v", beqClient.Devices())
+	if len(beqClient.Devices()) == 0 {
 		log.Error("No devices found. Please check your ezbeq settings!")
 	}
 
 	// get the device names from the API call
-	for _, k := range beqClient.DeviceInfo {
+	for _, k := range beqClient.Devices() {
 		log.Debugf("adding device %s", k.Name)
 		deviceNames = append(deviceNames, k.Name)
 	}
@@ -521,14 +558,17 @@ func PlexWorker(plexChan <-chan models.PlexWebhookPayload, readyChan chan<- bool
 	model = &models.SearchRequest{
 		DryrunMode: config.GetBool("ezbeq.dryRun"),
 		Devices:    deviceNames,
-		Slots:      config.GetIntSlice("ezbeq.slots"),
+		// every slot any player uses, events set their own route
+		Slots:      ezbeq.AllSlots(),
 		// try to skip by default
 		SkipSearch: true,
 		PreferredAuthor: config.GetString("ezbeq.preferredAuthor"),
 	}
 
 	// unload existing profile for safety
-	err = beqClient.UnloadBeqProfile(model)
+	ctx, cancel := eventContext()
+	err = beqClient.UnloadBeqProfile(ctx, model)
+	cancel()
 	if err != nil {
 		log.Errorf("Error on startup - unloading beq %v", err)
 	}
@@ -554,7 +594,9 @@ func PlexWorker(plexChan <-chan models.PlexWebhookPayload, readyChan chan<- bool
 		log.Debugf("Current length of plexChan in PlexWorker: %d", len(plexChan))
 		// determine what to do
 		log.Debug("Sending new payload to eventRouter")
-		eventRouter(plexClient, beqClient, haClient, avrClient, useAvrCodec, i, model, skipActions)
+		ctx, cancel := eventContext()
+		eventRouter(ctx, plexClient, beqClient, haClient, avrClient, useAvrCodec, i, model, skipActions)
+		cancel()
 		log.Debug("eventRouter done processing payload")
 	}
 
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/plex"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetPlexTitle(t *testing.T) {
+	assert := assert.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/library/metadata/2958", r.URL.Path)
+		_, _ = w.Write([]byte(`<MediaContainer size="1"><Directory ratingKey="2958" type="show" title="Friends" year="1994"><Guid id="imdb://tt0108778"/><Guid id="tmdb://1668"/></Directory></MediaContainer>`))
+	}))
+	defer srv.Close()
+	i := strings.LastIndex(srv.URL, ":")
+	client := plex.NewClient(srv.URL[:i], srv.URL[i+1:], "", "")
+
+	// the episode's own tmdb ID and year are replaced with the show's
+	payload := models.PlexWebhookPayload{Metadata: models.Metadata{
+		Type:             "episode",
+		Title:            "The One Where Rachel Quits",
+		Year:             1996,
+		GUID0:            []models.GUID0{{ID: "tmdb://86334"}},
+		GrandparentKey:   "/library/metadata/2958",
+		GrandparentTitle: "Friends",
+		ParentIndex:      3,
+		Index:            10,
+	}}
+	m := &models.SearchRequest{Title: payload.Metadata.Title, Year: payload.Metadata.Year}
+	setPlexTitle(client, payload, m)
+	assert.Equal(models.SearchRequest{Title: "Friends", Year: 1994, TMDB: "1668", Season: 3, Episode: 10}, *m)
+
+	// movies use their own metadata
+	payload = models.PlexWebhookPayload{Metadata: models.Metadata{Type: "movie", Title: "2 Fast 2 Furious", Year: 2003, GUID0: []models.GUID0{{ID: "tmdb://584"}}}}
+	m = &models.SearchRequest{Title: payload.Metadata.Title, Year: payload.Metadata.Year}
+	setPlexTitle(client, payload, m)
+	assert.Equal(models.SearchRequest{Title: "2 Fast 2 Furious", Year: 2003, TMDB: "584"}, *m)
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iloveicedgreentea/go-plex/internal/avr"
+	"github.com/iloveicedgreentea/go-plex/internal/common"
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/ezbeq"
+	"github.com/iloveicedgreentea/go-plex/internal/homeassistant"
+	"github.com/iloveicedgreentea/go-plex/internal/jellyfin"
+	"github.com/iloveicedgreentea/go-plex/internal/mqtt"
+	"github.com/iloveicedgreentea/go-plex/internal/plan"
+	"github.com/iloveicedgreentea/go-plex/internal/plex"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// side effects of an event go through these so a simulated event records them instead
+
+// publish sends msg to an mqtt topic
+func publish(ctx context.Context, topic, msg string) error {
+	if plan.Record(ctx, plan.MQTT, topic, msg) {
+		return nil
+	}
+	return mqtt.PublishWrapper(topic, msg)
+}
+
+// notify sends a Home Assistant notification
+func notify(ctx context.Context, haClient *homeassistant.HomeAssistantClient, msg string) error {
+	if plan.Record(ctx, plan.HomeAssistant, "notification", msg) {
+		return nil
+	}
+	return haClient.SendNotification(msg)
+}
+
+// changeLight changes the lights in the background
+func changeLight(ctx context.Context, state string) {
+	if plan.From(ctx) == nil {
+		go common.ChangeLight(state)
+		return
+	}
+	if config.GetBool("homeassistant.triggerlightsonevent") && config.GetBool("homeassistant.enabled") {
+		plan.Record(ctx, plan.MQTT, config.GetString("mqtt.topiclights"), fmt.Sprintf("{\"state\":\"%s\"}", state))
+	}
+}
+
+// changeMasterVolume changes the AVR volume for the media type in the background through Home Assistant
+func changeMasterVolume(ctx context.Context, mediaType string) {
+	if plan.From(ctx) == nil {
+		go common.ChangeMasterVolume(mediaType)
+		return
+	}
+	if config.GetBool("homeassistant.triggeravrmastervolumechangeonevent") && config.GetBool("homeassistant.enabled") {
+		plan.Record(ctx, plan.MQTT, config.GetString("mqtt.topicvolume"), fmt.Sprintf("{\"type\":\"%s\"}", mediaType))
+	}
+}
+
+// applyAVRMediaSettings sets up the AVR directly for the media type. It waits for the AVR, so call it after the codec is read
+// since the sound mode changes what the AVR reports
+func applyAVRMediaSettings(ctx context.Context, mediaType string) {
+	settings, ok := avr.GetMediaSettings(mediaType)
+	if !ok || plan.Record(ctx, plan.AVR, settings.String(), settings) {
+		return
+	}
+	c := avr.GetConfiguredClient()
+	if c == nil {
+		return
+	}
+	if err := avr.ApplyMediaSettings(c, settings); err != nil {
+		log.Errorf("Error applying AVR media settings: %v", err)
+	}
+}
+
+// preflightAVR makes sure the AVR is on and on the player's input before the codec is read from it. False if it is not ready
+func preflightAVR(ctx context.Context, c avr.AVRClient, players ...string) bool {
+	if !config.GetBool("ezbeq.avrPreflight") {
+		return true
+	}
+	input, _ := avr.ExpectedInput(players...)
+	detail := "turn on if needed"
+	if input != "" {
+		detail += ", switch to input " + input
+	}
+	if plan.Record(ctx, plan.AVR, detail, nil) {
+		return true
+	}
+	if err := avr.Preflight(ctx, c, input); err != nil {
+		log.Errorf("AVR is not ready: %v", err)
+		return false
+	}
+	return true
+}
+
+// detectAVRCodec reads the codec once the AVR has reported the same thing for a while, see avr.DetectCodec. Simulations take the first reading
+func detectAVRCodec(ctx context.Context, c avr.AVRClient) (string, error) {
+	window, deadline := avr.DetectionSettings()
+	if plan.From(ctx) != nil {
+		window = 0
+	}
+	d, err := avr.DetectCodec(ctx, c, avr.GetCodecMapper(config.GetString("ezbeq.avrbrand")), window, deadline)
+	if err != nil {
+		return "", err
+	}
+	return d.Codec, nil
+}
+
+// waitForHDMISync pauses the player until the signal is back, see common.WaitForHDMISync
+func waitForHDMISync(ctx context.Context, wg *sync.WaitGroup, skipActions *bool, haClient *homeassistant.HomeAssistantClient, mediaClient common.Client) {
+	if plan.From(ctx) == nil {
+		common.WaitForHDMISync(wg, skipActions, haClient, mediaClient)
+		return
+	}
+	if config.GetBool("signal.enabled") {
+		plan.Record(ctx, plan.Player, fmt.Sprintf("pause, wait for HDMI sync from %s, then play", config.GetString("signal.source")), nil)
+	}
+	*skipActions = false
+	wg.Done()
+}
+
+// simulations share one ezbeq client, separate from the workers so their cached profile is not touched
+var (
+	simulateMu     sync.Mutex
+	simulateClient *ezbeq.BeqClient
+)
+
+// ProcessSimulateWebhook runs a plex or jellyfin webhook through the event pipeline without any side effects
+// and returns every step it would take. Metadata and ezbeq are only read
+func ProcessSimulateWebhook(c *gin.Context) {
+	// the simulation clients are shared, one at a time
+	simulateMu.Lock()
+	defer simulateMu.Unlock()
+
+	p := plan.New()
+	ctx, cancel := context.WithTimeout(plan.With(c.Request.Context(), p), eventTimeout)
+	defer cancel()
+
+	// the client is reused, only its devices are fetched again if ezbeq was down
+	if simulateClient == nil {
+		var err error
+		simulateClient, err = ezbeq.NewClient(config.GetString("ezbeq.url"), config.GetString("ezbeq.port"))
+		if err != nil {
+			p.Add(plan.Error, "could not connect to ezbeq", err.Error())
+		}
+	} else if len(simulateClient.Devices()) == 0 {
+		if err := simulateClient.GetStatus(ctx); err != nil {
+			p.Add(plan.Error, "could not connect to ezbeq", err.Error())
+		}
+	}
+	beqClient := simulateClient
+	var haClient *homeassistant.HomeAssistantClient
+	if config.GetBool("homeAssistant.enabled") {
+		haClient = homeassistant.NewClient(config.GetString("homeAssistant.url"), config.GetString("homeAssistant.port"), config.GetString("homeAssistant.token"), config.GetString("homeAssistant.remoteentityname"))
+	}
+	model := &models.SearchRequest{
+		DryrunMode:      true,
+		Slots:           ezbeq.AllSlots(),
+		PreferredAuthor: config.GetString("ezbeq.preferredAuthor"),
+	}
+	for _, d := range beqClient.Devices() {
+		model.Devices = append(model.Devices, d.Name)
+	}
+
+	switch c.Param("source") {
+	case "plex":
+		payload, err := decodeSimulatedPlex(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if userID := config.GetString("plex.ownerNameFilter"); userID != "" && payload.Account.Title != userID {
+			p.Add(plan.Skip, fmt.Sprintf("account %s does not match the owner filter", payload.Account.Title), nil)
+			break
+		}
+		if !isMovie(payload.Metadata.Type) && !isEpisode(payload.Metadata.Type) {
+			p.Add(plan.Skip, fmt.Sprintf("media type %s is not supported", payload.Metadata.Type), nil)
+			break
+		}
+		plexClient := plex.NewClient(config.GetString("plex.url"), config.GetString("plex.port"), config.GetString("plex.playerMachineIdentifier"), config.GetString("plex.playerIP"))
+		var avrClient avr.AVRClient
+		var useAvrCodec bool
+		if config.GetBool("ezbeq.useAVRCodecSearch") {
+			avrClient = avr.GetAVRClient(config.GetString("ezbeq.avrurl"))
+			useAvrCodec = avrClient != nil
+		}
+		eventRouter(ctx, plexClient, beqClient, haClient, avrClient, useAvrCodec, payload, model, new(bool))
+	case "jellyfin":
+		var payload models.JellyfinWebhook
+		if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		jfClient := jellyfin.NewClient(config.GetString("jellyfin.url"), config.GetString("jellyfin.port"), config.GetString("jellyfin.playerMachineIdentifier"), config.GetString("jellyfin.playerIP"))
+		jfEventRouter(ctx, jfClient, beqClient, haClient, payload, model, new(bool))
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "source must be plex or jellyfin"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"steps": p.Steps()})
+}
+
+// decodeSimulatedPlex accepts the multipart form plex sends, or the payload as plain JSON
+func decodeSimulatedPlex(c *gin.Context) (models.PlexWebhookPayload, error) {
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		if err := c.Request.ParseMultipartForm(0); err != nil {
+			return models.PlexWebhookPayload{}, err
+		}
+		payload, ok := c.Request.MultipartForm.Value["payload"]
+		if !ok {
+			return models.PlexWebhookPayload{}, fmt.Errorf("no payload found in request")
+		}
+		decoded, _, err := common.DecodeWebhook(payload)
+		return decoded, err
+	}
+
+	var payload models.PlexWebhookPayload
+	err := json.NewDecoder(c.Request.Body).Decode(&payload)
+	return payload, err
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/iloveicedgreentea/go-plex/internal/plan"
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulatedSideEffects(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("homeassistant.enabled", true)
+	config.Set("homeassistant.triggerlightsonevent", true)
+	config.Set("homeassistant.triggeravrmastervolumechangeonevent", false)
+	config.Set("mqtt.topiclights", "theater/lights/front")
+	t.Cleanup(func() {
+		config.Set("homeassistant.enabled", false)
+		config.Set("homeassistant.triggerlightsonevent", false)
+	})
+
+	p := plan.New()
+	ctx := plan.With(context.Background(), p)
+
+	// nothing is sent, so nil clients are fine
+	assert.NoError(publish(ctx, "theater/playing", "true"))
+	assert.NoError(notify(ctx, nil, "loaded"))
+	changeLight(ctx, "off")
+	changeMasterVolume(ctx, "movie")
+	savePlayerState(ctx, "player", &models.SearchRequest{Title: "Fast Five", Codec: "DTS-X"})
+
+	steps := p.Steps()
+	assert.Len(steps, 4)
+	assert.Equal(plan.Step{Kind: plan.MQTT, Detail: "theater/playing", Data: "true"}, steps[0])
+	assert.Equal(plan.HomeAssistant, steps[1].Kind)
+	assert.Equal(plan.Step{Kind: plan.MQTT, Detail: "theater/lights/front", Data: `{"state":"off"}`}, steps[2])
+	assert.Equal(plan.State, steps[3].Kind)
+	assert.Equal("DTS-X", steps[3].Data.(models.PlayerState).Codec)
+}
+
+func TestSimulatedAVRSettings(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.avrMediaSettings", map[string]interface{}{
+		"movie": map[string]interface{}{"input": "BD", "volume": -18},
+	})
+	t.Cleanup(func() { config.Set("ezbeq.avrMediaSettings", nil) })
+
+	p := plan.New()
+	ctx := plan.With(context.Background(), p)
+	applyAVRMediaSettings(ctx, "movie")
+	// no settings for shows
+	applyAVRMediaSettings(ctx, "episode")
+
+	steps := p.Steps()
+	assert.Len(steps, 1)
+	assert.Equal(plan.AVR, steps[0].Kind)
+	assert.Equal("input BD, volume -18.0dB", steps[0].Detail)
+}
+
+func TestSimulatedAVRPreflight(t *testing.T) {
+	assert := assert.New(t)
+	config.Set("ezbeq.avrPlayerInputs", []interface{}{
+		map[string]interface{}{"player": "shield", "input": "MPLAY"},
+	})
+	t.Cleanup(func() {
+		config.Set("ezbeq.avrPlayerInputs", nil)
+		config.Set("ezbeq.avrPreflight", false)
+	})
+
+	p := plan.New()
+	ctx := plan.With(context.Background(), p)
+	// disabled
+	assert.True(preflightAVR(ctx, nil, "shield"))
+	assert.Empty(p.Steps())
+
+	config.Set("ezbeq.avrPreflight", true)
+	assert.True(preflightAVR(ctx, nil, "", "Shield"))
+	assert.True(preflightAVR(ctx, nil, "other"))
+
+	steps := p.Steps()
+	assert.Len(steps, 2)
+	assert.Equal(plan.AVR, steps[0].Kind)
+	assert.Equal("turn on if needed, switch to input MPLAY", steps[0].Detail)
+	assert.Equal("turn on if needed", steps[1].Detail)
+}
@@ -65,10 +65,12 @@ func (c *JellyfinClient) GetAudioCodec(payload interface{}) (string, error) {
 
 // generic function to make a request
 func (c *JellyfinClient) makeRequest(endpoint string, method string) (io.ReadCloser, error) {
+	path, query, _ := strings.Cut(endpoint, "?")
 	u := url.URL{
-		Scheme: "http",
-		Host:   fmt.Sprintf("%v:%v", c.ServerURL, c.Port),
-		Path:   endpoint,
+		Scheme:   "http",
+		Host:     fmt.Sprintf("%v:%v", c.ServerURL, c.Port),
+		Path:     path,
+		RawQuery: query,
 	}
 	log.Debugf("Making request to %v", u.String())
 	// create request with auth
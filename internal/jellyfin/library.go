@@ -0,0 +1,33 @@
+package jellyfin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// fields the item list leaves out unless asked for, needed for the codec, edition and tmdb ID
+const libraryFields = "MediaStreams,MediaSources,ExternalUrls,Path"
+
+// GetLibraryItems returns every item of the given types, e.g Movie,Episode, in the user's libraries
+func (c *JellyfinClient) GetLibraryItems(userID, itemTypes string) ([]models.JellyfinMetadata, error) {
+	endpoint := fmt.Sprintf("/Users/%s/Items?Recursive=true&IncludeItemTypes=%s&Fields=%s", userID, itemTypes, libraryFields)
+	r, err := c.makeRequest(endpoint, "get")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var payload models.JellyfinItems
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return nil, err
+	}
+
+	return payload.Items, nil
+}
@@ -0,0 +1,314 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+)
+
+const (
+	defaultAvailabilityTopic = "gowatchit/availability"
+	defaultPublishTimeout    = 10 * time.Second
+)
+
+var (
+	// how many messages are kept while the broker is unreachable, the oldest are dropped first
+	queueSize = 100
+	// queued messages older than this are dropped, lights shouldn't change long after playback started
+	queueMaxAge = 5 * time.Minute
+	// a message is dropped after this many failed sends, since a message the broker rejects drops the connection every time
+	maxSendAttempts      = 2
+	connectRetryInterval = 5 * time.Second
+	maxReconnectInterval = 30 * time.Second
+)
+
+// Stats are the publish results since startup
+type Stats struct {
+	Connected   bool      `json:"connected"`
+	Published   int       `json:"published"`
+	Failed      int       `json:"failed"`
+	Queued      int       `json:"queued"`
+	Dropped     int       `json:"dropped"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt,omitempty"`
+	LastPublish time.Time `json:"lastPublish,omitempty"`
+}
+
+type message struct {
+	topic   string
+	payload []byte
+	at      time.Time
+	// attempts is how many sends failed
+	attempts int
+}
+
+// validTopic rejects topics the broker would disconnect us for, publishing can't use wildcards
+func validTopic(topic string) error {
+	if topic == "" {
+		return errors.New("mqtt topic is empty")
+	}
+	if strings.ContainsAny(topic, "+#\x00") {
+		return fmt.Errorf("mqtt topic %q is not valid for publishing", topic)
+	}
+	return nil
+}
+
+// manager keeps one connection to the broker for every publish and queues messages while it is down
+type manager struct {
+	mu     sync.Mutex
+	client paho.Client
+	// settings the client was made with, it is remade when they change
+	settings string
+	queue    []message
+	// flushing is set while the queue is sent, new messages wait behind it to keep the order
+	flushing bool
+	stats    Stats
+	// timeout is how long to wait for the broker to acknowledge a message
+	timeout time.Duration
+}
+
+var defaultManager = &manager{timeout: defaultPublishTimeout}
+
+// Status returns the publish results
+func Status() Stats {
+	return defaultManager.status()
+}
+
+func (m *manager) status() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stats
+	s.Queued = len(m.queue)
+	s.Connected = m.client != nil && m.client.IsConnectionOpen()
+	return s
+}
+
+// availabilityTopic is where online is published on connect, and offline by the broker if we go away
+func availabilityTopic() string {
+	if t := config.GetString("mqtt.topicAvailability"); t != "" {
+		return t
+	}
+	return defaultAvailabilityTopic
+}
+
+// clientID is unique per host so the broker doesn't confuse us with another instance
+func clientID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "gowatchit"
+	}
+	return "gowatchit-" + host
+}
+
+// getClient returns the client, connecting in the background on first use or when the broker settings change
+func (m *manager) getClient() paho.Client {
+	settings := fmt.Sprintf("%s|%s|%s|%s", config.GetString("mqtt.url"), config.GetString("mqtt.username"), config.GetString("mqtt.password"), availabilityTopic())
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.client != nil && m.settings == settings {
+		return m.client
+	}
+	if old := m.client; old != nil {
+		log.Info("MQTT settings changed, reconnecting")
+		go old.Disconnect(250)
+	}
+	m.client = paho.NewClient(m.options())
+	m.settings = settings
+	// retries until connected, queued messages are sent once it is
+	m.client.Connect()
+	return m.client
+}
+
+func (m *manager) options() *paho.ClientOptions {
+	opts := paho.NewClientOptions().AddBroker(config.GetString("mqtt.url"))
+	opts.SetClientID(clientID())
+	opts.SetUsername(config.GetString("mqtt.username"))
+	opts.SetPassword(config.GetString("mqtt.password"))
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(connectRetryInterval)
+	opts.SetMaxReconnectInterval(maxReconnectInterval)
+	opts.SetWill(availabilityTopic(), "offline", 1, true)
+	// the queue does the retrying, paho would resend a message the broker rejects on every reconnect
+	opts.SetStore(noResendStore{})
+	opts.SetOnConnectHandler(m.onConnect)
+	opts.SetConnectionLostHandler(func(_ paho.Client, err error) {
+		log.Warnf("Lost connection to MQTT broker, reconnecting: %v", err)
+		m.mu.Lock()
+		m.stats.LastError = err.Error()
+		m.stats.LastErrorAt = time.Now()
+		m.mu.Unlock()
+	})
+	return opts
+}
+
+// onConnect announces we are online and sends what was queued while disconnected
+func (m *manager) onConnect(c paho.Client) {
+	log.Info("Connected to MQTT broker")
+	token := c.Publish(availabilityTopic(), 1, true, "online")
+	if !token.WaitTimeout(m.timeout) || token.Error() != nil {
+		log.Warnf("Error publishing MQTT availability: %v", token.Error())
+	}
+	m.mu.Lock()
+	current := m.client == c
+	m.mu.Unlock()
+	// a client replaced after a settings change leaves the queue to the new one
+	if current {
+		m.flush(c)
+	}
+}
+
+// flush sends queued messages in order, including any queued while it runs. They are put back if the connection drops again
+func (m *manager) flush(c paho.Client) {
+	m.mu.Lock()
+	if m.flushing {
+		m.mu.Unlock()
+		return
+	}
+	m.flushing = true
+	queue := m.queue
+	m.queue = nil
+	m.mu.Unlock()
+	if len(queue) > 0 {
+		log.Infof("Sending %d queued MQTT messages", len(queue))
+	}
+
+	for len(queue) > 0 {
+		for i, msg := range queue {
+			if time.Since(msg.at) > queueMaxAge {
+				log.Warnf("Dropping MQTT message to %s queued %v ago", msg.topic, time.Since(msg.at).Round(time.Second))
+				m.mu.Lock()
+				m.stats.Dropped++
+				m.mu.Unlock()
+				continue
+			}
+			if !m.sendQueued(c, &queue[i]) {
+				m.mu.Lock()
+				m.queue = append(append([]message(nil), queue[i:]...), m.queue...)
+				m.flushing = false
+				m.mu.Unlock()
+				return
+			}
+		}
+		m.mu.Lock()
+		queue = m.queue
+		m.queue = nil
+		if len(queue) == 0 {
+			m.flushing = false
+		}
+		m.mu.Unlock()
+	}
+}
+
+// sendQueued sends a queued message, retrying once. It is dropped if that fails too, a message the broker rejects would drop
+// the connection on every reconnect otherwise. False if the connection is down and the message should stay queued
+func (m *manager) sendQueued(c paho.Client, msg *message) bool {
+	for {
+		err := m.send(c, *msg)
+		if err == nil {
+			return true
+		}
+		msg.attempts++
+		if msg.attempts >= maxSendAttempts {
+			log.Warnf("Dropping MQTT message to %s after %d failed sends: %v", msg.topic, msg.attempts, err)
+			m.mu.Lock()
+			m.stats.Dropped++
+			m.mu.Unlock()
+			return true
+		}
+		if !c.IsConnectionOpen() {
+			return false
+		}
+	}
+}
+
+// publish sends the message, or queues it if the broker is not connected or older messages are still queued
+func (m *manager) publish(topic string, payload []byte) error {
+	if err := validTopic(topic); err != nil {
+		return err
+	}
+	msg := message{topic: topic, payload: payload, at: time.Now()}
+	c := m.getClient()
+	m.mu.Lock()
+	connected := c.IsConnectionOpen()
+	if !connected || m.flushing || len(m.queue) > 0 {
+		if !connected {
+			log.Warnf("Not connected to MQTT broker, queueing message to %s", topic)
+		}
+		m.enqueueLocked(msg)
+		m.mu.Unlock()
+		// it may have connected in the meantime, a running flush picks it up otherwise
+		if c.IsConnectionOpen() {
+			m.flush(c)
+		}
+		return nil
+	}
+	m.mu.Unlock()
+
+	err := m.send(c, msg)
+	// it will be sent when the connection is back
+	if err != nil && !c.IsConnectionOpen() {
+		log.Warnf("MQTT connection dropped, queueing message to %s", topic)
+		msg.attempts++
+		m.enqueue(msg)
+		return nil
+	}
+	return err
+}
+
+func (m *manager) send(c paho.Client, msg message) error {
+	log.Debugf("Sending payload %v to topic %v", string(msg.payload), msg.topic)
+	token := c.Publish(msg.topic, 1, false, msg.payload)
+	var err error
+	if !token.WaitTimeout(m.timeout) {
+		err = errors.New("timeout when waiting for mqtt token")
+	} else {
+		err = token.Error()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.stats.Failed++
+		m.stats.LastError = err.Error()
+		m.stats.LastErrorAt = time.Now()
+		return fmt.Errorf("error publishing to %s: %w", msg.topic, err)
+	}
+	m.stats.Published++
+	m.stats.LastPublish = time.Now()
+	return nil
+}
+
+// enqueue keeps the message for later, dropping the oldest if the queue is full
+func (m *manager) enqueue(msg message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enqueueLocked(msg)
+}
+
+func (m *manager) enqueueLocked(msg message) {
+	if len(m.queue) >= queueSize {
+		log.Warnf("MQTT queue is full, dropping the oldest message to %s", m.queue[0].topic)
+		m.queue = m.queue[1:]
+		m.stats.Dropped++
+	}
+	m.queue = append(m.queue, msg)
+}
+
+// noResendStore keeps nothing, so paho doesn't resend messages in flight when it reconnects
+type noResendStore struct{}
+
+func (noResendStore) Open()                                 {}
+func (noResendStore) Put(_ string, _ packets.ControlPacket) {}
+func (noResendStore) Get(_ string) packets.ControlPacket    { return nil }
+func (noResendStore) All() []string                         { return nil }
+func (noResendStore) Del(_ string)                          {}
+func (noResendStore) Close()                                {}
+func (noResendStore) Reset()                                {}
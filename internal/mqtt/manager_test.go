@@ -0,0 +1,379 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePublish struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+type fakeConnect struct {
+	clientID    string
+	willTopic   string
+	willMessage string
+	willRetain  bool
+}
+
+// fakeBroker speaks enough MQTT 3.1.1 for publishing. While down it closes connections right away
+type fakeBroker struct {
+	ln net.Listener
+	mu sync.Mutex
+	up bool
+	// ackDelay slows down each PUBACK, like a busy broker
+	ackDelay time.Duration
+	// rejectTopic drops the connection instead of accepting a publish, like a broker refusing a topic
+	rejectTopic string
+	conns       []net.Conn
+	connects    []fakeConnect
+	published   []fakePublish
+}
+
+func newFakeBroker(t *testing.T, up bool) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln, up: up}
+	t.Cleanup(func() {
+		ln.Close()
+		b.setUp(false)
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			if !b.up {
+				b.mu.Unlock()
+				conn.Close()
+				continue
+			}
+			b.conns = append(b.conns, conn)
+			b.mu.Unlock()
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+// setUp starts or stops accepting clients, stopping drops every connection without a DISCONNECT
+func (b *fakeBroker) setUp(up bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.up = up
+	if !up {
+		for _, c := range b.conns {
+			c.Close()
+		}
+		b.conns = nil
+	}
+}
+
+func (b *fakeBroker) publishes(topic string) []fakePublish {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []fakePublish
+	for _, p := range b.published {
+		if p.topic == topic {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case 1:
+			c, err := parseConnect(body)
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.connects = append(b.connects, c)
+			b.mu.Unlock()
+			_, _ = conn.Write([]byte{0x20, 2, 0, 0})
+		case 3:
+			qos := (header >> 1) & 3
+			topic, rest := readString(body)
+			p := fakePublish{topic: topic, retain: header&1 == 1}
+			var id []byte
+			if qos > 0 {
+				id = rest[:2]
+				rest = rest[2:]
+			}
+			p.payload = string(rest)
+			b.mu.Lock()
+			if topic == b.rejectTopic {
+				b.mu.Unlock()
+				return
+			}
+			b.published = append(b.published, p)
+			delay := b.ackDelay
+			b.mu.Unlock()
+			if id != nil {
+				time.Sleep(delay)
+				_, _ = conn.Write([]byte{0x40, 2, id[0], id[1]})
+			}
+		case 12:
+			_, _ = conn.Write([]byte{0xd0, 0})
+		case 14:
+			return
+		}
+	}
+}
+
+// readPacket reads the remaining length and the rest of the packet
+func readPacket(r *bufio.Reader) ([]byte, error) {
+	length, mult := 0, 1
+	for {
+		d, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(d&127) * mult
+		if d&128 == 0 {
+			break
+		}
+		mult *= 128
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+func readString(b []byte) (string, []byte) {
+	n := int(binary.BigEndian.Uint16(b))
+	return string(b[2 : 2+n]), b[2+n:]
+}
+
+func parseConnect(b []byte) (fakeConnect, error) {
+	var c fakeConnect
+	proto, rest := readString(b)
+	if proto != "MQTT" || len(rest) < 4 {
+		return c, fmt.Errorf("unexpected protocol %s", proto)
+	}
+	flags := rest[1]
+	c.clientID, rest = readString(rest[4:])
+	if flags&0x04 != 0 {
+		c.willTopic, rest = readString(rest)
+		c.willMessage, _ = readString(rest)
+		c.willRetain = flags&0x20 != 0
+	}
+	return c, nil
+}
+
+func fastReconnect(t *testing.T, url string) {
+	retry, max := connectRetryInterval, maxReconnectInterval
+	connectRetryInterval, maxReconnectInterval = 20*time.Millisecond, 50*time.Millisecond
+	config.Set("mqtt.url", url)
+	t.Cleanup(func() {
+		connectRetryInterval, maxReconnectInterval = retry, max
+		config.Set("mqtt.url", nil)
+	})
+}
+
+func stopManager(t *testing.T, m *manager) {
+	t.Cleanup(func() {
+		m.mu.Lock()
+		c := m.client
+		m.mu.Unlock()
+		if c != nil {
+			c.Disconnect(0)
+		}
+	})
+}
+
+func TestManagerQueuesWhileDisconnected(t *testing.T) {
+	assert := assert.New(t)
+	b := newFakeBroker(t, false)
+	fastReconnect(t, b.url())
+	m := &manager{timeout: defaultPublishTimeout}
+	stopManager(t, m)
+
+	assert.NoError(m.publish("theater/lights", []byte("off")))
+	assert.NoError(m.publish("theater/lights", []byte("on")))
+	s := m.status()
+	assert.False(s.Connected)
+	assert.Equal(2, s.Queued)
+
+	// sent in order once the broker is back, after announcing we are online
+	b.setUp(true)
+	assert.Eventually(func() bool {
+		return len(b.publishes("theater/lights")) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal([]fakePublish{{topic: "theater/lights", payload: "off"}, {topic: "theater/lights", payload: "on"}}, b.publishes("theater/lights"))
+	assert.Equal([]fakePublish{{topic: defaultAvailabilityTopic, payload: "online", retain: true}}, b.publishes(defaultAvailabilityTopic))
+
+	b.mu.Lock()
+	connect := b.connects[0]
+	b.mu.Unlock()
+	assert.Equal(fakeConnect{clientID: clientID(), willTopic: defaultAvailabilityTopic, willMessage: "offline", willRetain: true}, connect)
+
+	s = m.status()
+	assert.True(s.Connected)
+	assert.Equal(0, s.Queued)
+	assert.Equal(2, s.Published)
+
+	// reconnects when the broker goes away
+	b.setUp(false)
+	assert.Eventually(func() bool { return !m.status().Connected }, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(m.publish("theater/lights", []byte("off")))
+	b.setUp(true)
+	assert.Eventually(func() bool {
+		return len(b.publishes("theater/lights")) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotEmpty(m.status().LastError)
+}
+
+func TestManagerQueueLimit(t *testing.T) {
+	assert := assert.New(t)
+	b := newFakeBroker(t, false)
+	fastReconnect(t, b.url())
+	size, age := queueSize, queueMaxAge
+	queueSize = 3
+	t.Cleanup(func() { queueSize, queueMaxAge = size, age })
+	m := &manager{timeout: defaultPublishTimeout}
+	stopManager(t, m)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(m.publish("theater/volume", []byte(fmt.Sprint(i))))
+	}
+	s := m.status()
+	assert.Equal(3, s.Queued)
+	assert.Equal(2, s.Dropped)
+
+	// the oldest were dropped, and stale ones are dropped when sending
+	m.mu.Lock()
+	m.queue[0].at = time.Now().Add(-time.Hour)
+	m.mu.Unlock()
+	b.setUp(true)
+	assert.Eventually(func() bool {
+		return len(b.publishes("theater/volume")) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal("3", b.publishes("theater/volume")[0].payload)
+	assert.Equal(3, m.status().Dropped)
+}
+
+func TestManagerPublishDuringFlush(t *testing.T) {
+	assert := assert.New(t)
+	b := newFakeBroker(t, false)
+	b.ackDelay = 20 * time.Millisecond
+	fastReconnect(t, b.url())
+	m := &manager{timeout: defaultPublishTimeout}
+	stopManager(t, m)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(m.publish("theater/lights", []byte(fmt.Sprint(i))))
+	}
+	b.setUp(true)
+	// the queue is being sent, a new message has to wait behind it
+	assert.Eventually(func() bool {
+		return len(b.publishes("theater/lights")) > 0
+	}, 5*time.Second, time.Millisecond)
+	assert.NoError(m.publish("theater/lights", []byte("new")))
+
+	assert.Eventually(func() bool {
+		return len(b.publishes("theater/lights")) == 6
+	}, 5*time.Second, 10*time.Millisecond)
+	var payloads []string
+	for _, p := range b.publishes("theater/lights") {
+		payloads = append(payloads, p.payload)
+	}
+	assert.Equal([]string{"0", "1", "2", "3", "4", "new"}, payloads)
+	assert.Equal(0, m.status().Queued)
+
+	// sent after the others, directly once the flush is done
+	assert.NoError(m.publish("theater/lights", []byte("last")))
+	assert.Eventually(func() bool {
+		return len(b.publishes("theater/lights")) == 7
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal("last", b.publishes("theater/lights")[6].payload)
+}
+
+func TestManagerInvalidTopic(t *testing.T) {
+	assert := assert.New(t)
+	b := newFakeBroker(t, false)
+	fastReconnect(t, b.url())
+	m := &manager{timeout: defaultPublishTimeout}
+	stopManager(t, m)
+
+	for _, topic := range []string{"", "theater/+", "theater/#"} {
+		assert.Error(m.publish(topic, []byte("on")), topic)
+	}
+	assert.Equal(0, m.status().Queued)
+}
+
+func TestManagerDropsRejectedMessage(t *testing.T) {
+	assert := assert.New(t)
+	b := newFakeBroker(t, false)
+	b.rejectTopic = "theater/rejected"
+	fastReconnect(t, b.url())
+	m := &manager{timeout: 200 * time.Millisecond}
+	stopManager(t, m)
+
+	assert.NoError(m.publish("theater/rejected", []byte("x")))
+	assert.NoError(m.publish("theater/lights", []byte("off")))
+	b.setUp(true)
+
+	// the rejected message is retried once then dropped, instead of blocking the queue
+	assert.Eventually(func() bool {
+		return len(b.publishes("theater/lights")) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(1, m.status().Dropped)
+	assert.Equal(0, m.status().Queued)
+}
+
+func TestManagerConcurrentPublishes(t *testing.T) {
+	b := newFakeBroker(t, true)
+	fastReconnect(t, b.url())
+	m := &manager{timeout: defaultPublishTimeout}
+	stopManager(t, m)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, m.publish("theater/jvc/aspectratio", []byte(fmt.Sprint(i))))
+		}(i)
+	}
+	wg.Wait()
+	assert.Eventually(t, func() bool {
+		return len(b.publishes("theater/jvc/aspectratio")) == 10
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// one connection for every publish
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	assert.Len(t, b.connects, 1)
+}
@@ -0,0 +1,81 @@
+// Package plan records what an event would do instead of doing it, so a webhook can be simulated
+package plan
+
+import (
+	"context"
+	"sync"
+)
+
+// kinds of steps
+const (
+	Metadata      = "metadata"
+	Codec         = "codec"
+	Candidates    = "candidates"
+	Entry         = "entry"
+	Ezbeq         = "ezbeq"
+	MQTT          = "mqtt"
+	HomeAssistant = "homeassistant"
+	AVR           = "avr"
+	Player        = "player"
+	State         = "state"
+	Skip          = "skip"
+	Error         = "error"
+)
+
+// Step is one thing the event did or would have done
+type Step struct {
+	Kind   string      `json:"kind"`
+	Detail string      `json:"detail"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// Plan is the list of steps of a simulated event
+type Plan struct {
+	mu    sync.Mutex
+	steps []Step
+}
+
+type ctxKey struct{}
+
+// New returns an empty plan
+func New() *Plan {
+	return &Plan{}
+}
+
+// With returns a context which simulates side effects and records them in p
+func With(ctx context.Context, p *Plan) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// From returns the plan of a simulated event, or nil if the event is real
+func From(ctx context.Context) *Plan {
+	if ctx == nil {
+		return nil
+	}
+	p, _ := ctx.Value(ctxKey{}).(*Plan)
+	return p
+}
+
+// Add appends a step
+func (p *Plan) Add(kind, detail string, data interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.steps = append(p.steps, Step{Kind: kind, Detail: detail, Data: data})
+}
+
+// Steps returns a copy of the steps so far
+func (p *Plan) Steps() []Step {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Step{}, p.steps...)
+}
+
+// Record adds a step if the event is simulated. It returns true if so, meaning the side effect must be skipped
+func Record(ctx context.Context, kind, detail string, data interface{}) bool {
+	p := From(ctx)
+	if p == nil {
+		return false
+	}
+	p.Add(kind, detail, data)
+	return true
+}
@@ -0,0 +1,29 @@
+package plan
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	assert := assert.New(t)
+
+	// a real event is not recorded, so the side effect runs
+	assert.Nil(From(context.Background()))
+	assert.False(Record(context.Background(), MQTT, "topic", "msg"))
+
+	p := New()
+	ctx := With(context.Background(), p)
+	assert.Equal(p, From(ctx))
+	assert.True(Record(ctx, MQTT, "topic", "msg"))
+	assert.True(Record(ctx, Skip, "done", nil))
+
+	steps := p.Steps()
+	assert.Equal([]Step{{Kind: MQTT, Detail: "topic", Data: "msg"}, {Kind: Skip, Detail: "done"}}, steps)
+
+	// steps is a copy
+	steps[0].Detail = "changed"
+	assert.Equal("topic", p.Steps()[0].Detail)
+}
@@ -0,0 +1,67 @@
+package plex
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+// plex library types
+const (
+	SectionMovie = "movie"
+	SectionShow  = "show"
+)
+
+// episodeType lists the episodes of a show library instead of the shows
+const episodeType = 4
+
+// GetLibrarySections returns the libraries on the server
+func (c *PlexClient) GetLibrarySections() (models.LibrarySections, error) {
+	var data models.LibrarySections
+	res, err := c.getPlexReq("/library/sections")
+	if err != nil {
+		return data, err
+	}
+
+	err = xml.Unmarshal(res, &data)
+	return data, err
+}
+
+// GetLibraryItems returns every movie in a movie library, or every episode in a show library
+func (c *PlexClient) GetLibraryItems(sectionKey, sectionType string) (models.AllMediaContainer, error) {
+	path := fmt.Sprintf("/library/sections/%s/all", sectionKey)
+	if sectionType == SectionShow {
+		path += fmt.Sprintf("?type=%d", episodeType)
+	}
+	res, err := c.getPlexReq(path)
+	if err != nil {
+		return models.AllMediaContainer{}, err
+	}
+
+	return parseAllMediaContainer(res)
+}
+
+// tmdbPrefix is how plex writes a tmdb guid
+const tmdbPrefix = "tmdb://"
+
+// MediaTMDB returns the tmdb ID of a movie or episode, blank if plex doesn't have one
+func MediaTMDB(data models.MediaContainer) string {
+	for _, g := range data.Video.Guid {
+		if id, ok := strings.CutPrefix(g.ID, tmdbPrefix); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// ShowTMDB returns the tmdb ID of a show, blank if plex doesn't have one
+func ShowTMDB(show models.ShowContainer) string {
+	for _, g := range show.Directory.Guid {
+		if id, ok := strings.CutPrefix(g.ID, tmdbPrefix); ok {
+			return id
+		}
+	}
+	return ""
+}
@@ -161,6 +161,47 @@ func (c *PlexClient) GetMediaData(libraryKey string) (models.MediaContainer, err
 	return data, nil
 }
 
+// GetEdition tries to extract the edition from plex or file name. Assumes you have well named files
+// Returned types, Unrated, Ultimate, Theatrical, Extended, Director, Criterion
+func (c *PlexClient) GetEdition(data models.MediaContainer) string {
+	edition := data.Video.EditionTitle
+	fileName := strings.ToLower(data.Video.Media.Part.File)
+
+	// if there is an edition from plex metadata, use it
+	if edition != "" {
+		return edition
+	}
+	// otherwise try to extract from file name
+	switch {
+	case strings.Contains(fileName, "extended"):
+		return "Extended"
+	case strings.Contains(fileName, "unrated"):
+		return "Unrated"
+	case strings.Contains(fileName, "theatrical"):
+		return "Theatrical"
+	case strings.Contains(fileName, "ultimate"):
+		return "Ultimate"
+	case strings.Contains(fileName, "director"):
+		return "Director"
+	case strings.Contains(fileName, "criterion"):
+		return "Criterion"
+	default:
+		return ""
+	}
+}
+
+// GetShowData returns the metadata of a show, e.g from the grandparent key of an episode
+func (c *PlexClient) GetShowData(key string) (models.ShowContainer, error) {
+	var data models.ShowContainer
+	res, err := c.getPlexReq(key)
+	if err != nil {
+		return data, err
+	}
+
+	err = xml.Unmarshal(res, &data)
+	return data, err
+}
+
 func insensitiveContains(s string, sub string) bool {
 	return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
 }
@@ -0,0 +1,244 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/iloveicedgreentea/go-plex/internal/logger"
+	"github.com/iloveicedgreentea/go-plex/models"
+)
+
+var log = logger.GetLogger()
+
+// docker path, same volume as the config
+const defaultPath = "/data/state.json"
+
+var (
+	store *Store
+	once  sync.Once
+)
+
+// Store keeps playback and BEQ state on disk so resume and unload work across restarts
+type Store struct {
+	path string
+	mu   sync.Mutex
+	data models.State
+}
+
+// GetStore returns the shared store backed by /data/state.json
+func GetStore() *Store {
+	once.Do(func() {
+		var err error
+		store, err = NewStore(defaultPath)
+		if err != nil {
+			log.Errorf("Could not read saved state, starting fresh: %v", err)
+		}
+	})
+	return store
+}
+
+// NewStore loads state from path if it exists. The returned store is always usable, even with an error
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		data: models.State{
+			Players:  map[string]models.PlayerState{},
+			Profiles: map[string]models.ProfileState{},
+			Pins:     map[string]models.PinState{},
+		},
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Debugf("No state file found at %s", path)
+			return s, nil
+		}
+		return s, err
+	}
+
+	var data models.State
+	if err := json.Unmarshal(b, &data); err != nil {
+		return s, err
+	}
+	if data.Players != nil {
+		s.data.Players = data.Players
+	}
+	if data.Profiles != nil {
+		s.data.Profiles = data.Profiles
+	}
+	if data.Pins != nil {
+		s.data.Pins = data.Pins
+	}
+	log.Debugf("Loaded state for %d players and %d devices from %s", len(s.data.Players), len(s.data.Profiles), path)
+
+	return s, nil
+}
+
+// save writes the state to a temp file then renames it so a crash can't leave a half written file. Caller must hold the lock
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// GetPlayer returns the saved state for a player
+func (s *Store) GetPlayer(id string) (models.PlayerState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.data.Players[id]
+	return p, ok
+}
+
+// SetPlayer saves the state for a player
+func (s *Store) SetPlayer(id string, p models.PlayerState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.UpdatedAt = time.Now()
+	s.data.Players[id] = p
+	return s.save()
+}
+
+// DeletePlayer removes the saved state for a player
+func (s *Store) DeletePlayer(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.Players[id]; !ok {
+		return nil
+	}
+	delete(s.data.Players, id)
+	return s.save()
+}
+
+// LastPlayer returns the most recently updated player, which is what is playing now if anything is
+func (s *Store) LastPlayer() (models.PlayerState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last models.PlayerState
+	var found bool
+	for _, p := range s.data.Players {
+		if !found || p.UpdatedAt.After(last.UpdatedAt) {
+			last = p
+			found = true
+		}
+	}
+	return last, found
+}
+
+// GetProfiles returns a copy of the profile state of every known device
+func (s *Store) GetProfiles() map[string]models.ProfileState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]models.ProfileState, len(s.data.Profiles))
+	for k, v := range s.data.Profiles {
+		out[k] = v
+	}
+	return out
+}
+
+// SetProfile saves the profile loaded into a device
+func (s *Store) SetProfile(device string, p models.ProfileState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.UpdatedAt = time.Now()
+	s.data.Profiles[device] = p
+	return s.save()
+}
+
+// ClearProfile marks a device as unloaded but keeps the last entry so it can be reloaded quickly
+func (s *Store) ClearProfile(device string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.data.Profiles[device]
+	if !ok || !p.Loaded {
+		return nil
+	}
+	p.Loaded = false
+	p.PreviousMasterVolume = nil
+	p.UpdatedAt = time.Now()
+	s.data.Profiles[device] = p
+	return s.save()
+}
+
+// LastProfile returns the most recently updated profile across all devices
+func (s *Store) LastProfile() (models.ProfileState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last models.ProfileState
+	var found bool
+	for _, p := range s.data.Profiles {
+		if !found || p.UpdatedAt.After(last.UpdatedAt) {
+			last = p
+			found = true
+		}
+	}
+	return last, found
+}
+
+// GetPins returns a copy of every pinned entry
+func (s *Store) GetPins() map[string]models.PinState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]models.PinState, len(s.data.Pins))
+	for k, v := range s.data.Pins {
+		out[k] = v
+	}
+	return out
+}
+
+// GetPin returns the entry pinned to a TMDB ID
+func (s *Store) GetPin(tmdb string) (models.PinState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.data.Pins[tmdb]
+	return p, ok
+}
+
+// SetPin pins an entry to the TMDB ID in p
+func (s *Store) SetPin(p models.PinState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.TMDB == "" {
+		return errors.New("a pin needs a TMDB ID")
+	}
+	p.UpdatedAt = time.Now()
+	s.data.Pins[p.TMDB] = p
+	return s.save()
+}
+
+// DeletePin removes the pin for a TMDB ID
+func (s *Store) DeletePin(tmdb string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.Pins[tmdb]; !ok {
+		return nil
+	}
+	delete(s.data.Pins, tmdb)
+	return s.save()
+}
@@ -0,0 +1,120 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iloveicedgreentea/go-plex/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := NewStore(path)
+	assert.NoError(err)
+
+	assert.NoError(s.SetPlayer("player-id", models.PlayerState{
+		Title:    "Fast Five",
+		TMDB:     "51497",
+		Year:     2011,
+		Codec:    "DTS-X",
+		Edition:  "Extended",
+		EntryID:  "abc_416",
+		MVAdjust: -1.5,
+	}))
+	assert.NoError(s.SetProfile("master", models.ProfileState{
+		EntryID:  "abc_416",
+		Title:    "Fast Five",
+		MVAdjust: -1.5,
+		Slots:    []int{1},
+		Loaded:   true,
+	}))
+
+	// simulate a restart
+	reloaded, err := NewStore(path)
+	assert.NoError(err)
+
+	p, ok := reloaded.GetPlayer("player-id")
+	assert.True(ok)
+	assert.Equal("DTS-X", p.Codec)
+	assert.Equal("abc_416", p.EntryID)
+	assert.Equal(-1.5, p.MVAdjust)
+	assert.False(p.UpdatedAt.IsZero())
+
+	profiles := reloaded.GetProfiles()
+	assert.Len(profiles, 1)
+	assert.True(profiles["master"].Loaded)
+	assert.Equal([]int{1}, profiles["master"].Slots)
+
+	last, ok := reloaded.LastProfile()
+	assert.True(ok)
+	assert.Equal("abc_416", last.EntryID)
+}
+
+func TestStoreClearAndDelete(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := NewStore(path)
+	assert.NoError(err)
+	assert.NoError(s.SetPlayer("player-id", models.PlayerState{Codec: "Atmos"}))
+	assert.NoError(s.SetProfile("master", models.ProfileState{EntryID: "abc", Loaded: true}))
+
+	assert.NoError(s.ClearProfile("master"))
+	assert.NoError(s.DeletePlayer("player-id"))
+	// unknown keys are a no-op
+	assert.NoError(s.ClearProfile("unknown"))
+	assert.NoError(s.DeletePlayer("unknown"))
+
+	reloaded, err := NewStore(path)
+	assert.NoError(err)
+	_, ok := reloaded.GetPlayer("player-id")
+	assert.False(ok)
+	// entry is kept for a quick reload but marked unloaded
+	assert.False(reloaded.GetProfiles()["master"].Loaded)
+	assert.Equal("abc", reloaded.GetProfiles()["master"].EntryID)
+}
+
+func TestStoreMissingAndCorrupt(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	s, err := NewStore(filepath.Join(dir, "missing.json"))
+	assert.NoError(err)
+	assert.NotNil(s)
+	_, ok := s.LastProfile()
+	assert.False(ok)
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	assert.NoError(os.WriteFile(corrupt, []byte("{not json"), 0644))
+	s, err = NewStore(corrupt)
+	assert.Error(err)
+	// still usable
+	assert.NotNil(s)
+	assert.NoError(s.SetPlayer("player-id", models.PlayerState{Codec: "Atmos"}))
+}
+
+func TestStorePins(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := NewStore(path)
+	assert.NoError(err)
+	assert.Error(s.SetPin(models.PinState{EntryID: "abc_416"}))
+	assert.NoError(s.SetPin(models.PinState{TMDB: "51497", EntryID: "abc_416", Title: "Fast Five", MVAdjust: -1.5}))
+
+	reloaded, err := NewStore(path)
+	assert.NoError(err)
+	p, ok := reloaded.GetPin("51497")
+	assert.True(ok)
+	assert.Equal("abc_416", p.EntryID)
+	assert.Len(reloaded.GetPins(), 1)
+
+	assert.NoError(reloaded.DeletePin("51497"))
+	_, ok = reloaded.GetPin("51497")
+	assert.False(ok)
+	assert.NoError(reloaded.DeletePin("51497"))
+}
@@ -14,11 +14,15 @@ type SearchRequest struct {
 	Devices         []string
 	Slots           []int
 	Title           string
+	// Season and Episode are set for TV episodes. Title, TMDB and Year are then the series'
+	Season  int
+	Episode int
 }
 
 type BeqCatalog struct {
 	ID         string   `json:"id"`
 	Title      string   `json:"title"`
+	AltTitle   string   `json:"altTitle"`
 	SortTitle  string   `json:"sortTitle"`
 	Year       int      `json:"year"`
 	AudioTypes []string `json:"audioTypes"`
@@ -27,6 +31,11 @@ type BeqCatalog struct {
 	Edition    string   `json:"edition"`
 	MovieDbID  string   `json:"theMovieDB"`
 	Author     string   `json:"author"`
+	// ContentType is film or TV
+	ContentType string `json:"contentType"`
+	// Season and Episodes are what a TV entry covers, e.g 1 or 1-3 and 1-10. Blank covers all of them
+	Season   string `json:"season"`
+	Episodes string `json:"episodes"`
 }
 
 type BeqDevices struct {
@@ -46,10 +55,11 @@ type BeqSlots struct {
 	Mute2  bool    `json:"mute2"`
 }
 
+// BeqPatchV2 only changes what is set, so mute and master volume are left alone unless given
 type BeqPatchV2 struct {
-	Mute         bool      `json:"mute"`
-	MasterVolume float64   `json:"masterVolume"`
-	Slots        []SlotsV2 `json:"slots"`
+	Mute         *bool     `json:"mute,omitempty"`
+	MasterVolume *float64  `json:"masterVolume,omitempty"`
+	Slots        []SlotsV2 `json:"slots,omitempty"`
 }
 type SlotsV2 struct {
 	ID     string    `json:"id"`
@@ -21,6 +21,12 @@ type JellyfinWebhook struct {
 	IsPaused           string `json:"IsPaused"`
 }
 
+// JellyfinItems is a page of items from a library
+type JellyfinItems struct {
+	Items            []JellyfinMetadata `json:"Items"`
+	TotalRecordCount int                `json:"TotalRecordCount"`
+}
+
 type JellyfinMetadata struct {
 	Name                         string           `json:"Name"`
 	OriginalTitle                string           `json:"OriginalTitle"`
@@ -68,9 +74,9 @@ type JellyfinMetadata struct {
 	// IsPlaceHolder                bool             `json:"IsPlaceHolder"`
 	// Number                       string           `json:"Number"`
 	// ChannelNumber                string           `json:"ChannelNumber"`
-	// IndexNumber                  int              `json:"IndexNumber"`
+	IndexNumber                  int              `json:"IndexNumber"`
 	// IndexNumberEnd               int              `json:"IndexNumberEnd"`
-	// ParentIndexNumber            int              `json:"ParentIndexNumber"`
+	ParentIndexNumber            int              `json:"ParentIndexNumber"`
 	// RemoteTrailers               []RemoteTrailers `json:"RemoteTrailers"`
 	// ProviderIds                  ProviderIds      `json:"ProviderIds"`
 	// IsHD                         bool             `json:"IsHD"`
@@ -138,4 +138,9 @@ type Metadata struct {
 	Rating0               []Rating0    `json:"Rating"`
 	Collection            []Collection `json:"Collection"`
 	Role                  []Role       `json:"Role"`
+	// set for episodes, the grandparent is the show
+	GrandparentKey   string `json:"grandparentKey"`
+	GrandparentTitle string `json:"grandparentTitle"`
+	ParentIndex      int    `json:"parentIndex"`
+	Index            int    `json:"index"`
 }
@@ -133,6 +133,30 @@ type MediaContainer struct {
 	} `xml:"Video"`
 } 
 
+// LibrarySections lists the libraries of a server
+type LibrarySections struct {
+	XMLName   xml.Name `xml:"MediaContainer"`
+	Directory []struct {
+		Key   string `xml:"key,attr"`
+		Type  string `xml:"type,attr"`
+		Title string `xml:"title,attr"`
+	} `xml:"Directory"`
+}
+
+// ShowContainer is the metadata of a show, plex returns it as a directory
+type ShowContainer struct {
+	XMLName   xml.Name `xml:"MediaContainer"`
+	Directory struct {
+		RatingKey string `xml:"ratingKey,attr"`
+		Type      string `xml:"type,attr"`
+		Title     string `xml:"title,attr"`
+		Year      string `xml:"year,attr"`
+		Guid      []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"Guid"`
+	} `xml:"Directory"`
+}
+
 type AllMediaContainer struct {
 	XMLName             xml.Name `xml:"MediaContainer"`
 	Text                string   `xml:",chardata"`
@@ -182,6 +206,11 @@ type AllMediaContainer struct {
 		OriginalTitle         string `xml:"originalTitle,attr"`
 		UserRating            string `xml:"userRating,attr"`
 		LastRatedAt           string `xml:"lastRatedAt,attr"`
+		// set when listing episodes
+		GrandparentKey   string `xml:"grandparentKey,attr"`
+		GrandparentTitle string `xml:"grandparentTitle,attr"`
+		ParentIndex      string `xml:"parentIndex,attr"`
+		Index            string `xml:"index,attr"`
 		Media                 []struct {
 			Text                  string `xml:",chardata"`
 			ID                    string `xml:"id,attr"`
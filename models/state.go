@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// State is everything persisted to disk so it survives a restart
+type State struct {
+	Players  map[string]PlayerState  `json:"players"`
+	Profiles map[string]ProfileState `json:"profiles"`
+	// Pins are entries chosen by hand for a title, by TMDB ID
+	Pins map[string]PinState `json:"pins"`
+}
+
+// PlayerState is the last thing a player loaded, used to resume without searching again
+type PlayerState struct {
+	Title     string    `json:"title"`
+	TMDB      string    `json:"tmdb"`
+	Year      int       `json:"year"`
+	Codec     string    `json:"codec"`
+	Edition   string    `json:"edition"`
+	EntryID   string    `json:"entryId"`
+	MVAdjust  float64   `json:"mvAdjust"`
+	MediaType string    `json:"mediaType"`
+	Season    int       `json:"season,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ProfileState is the BEQ profile loaded into an ezbeq device
+type ProfileState struct {
+	EntryID   string  `json:"entryId"`
+	Title     string  `json:"title"`
+	Codec     string  `json:"codec"`
+	Author    string  `json:"author"`
+	MVAdjust  float64 `json:"mvAdjust"`
+	MediaType string  `json:"mediaType"`
+	Slots     []int   `json:"slots"`
+	Loaded    bool    `json:"loaded"`
+	// PreviousMasterVolume is the device master volume before the entry's offset was applied, restored on unload
+	PreviousMasterVolume *float64  `json:"previousMasterVolume,omitempty"`
+	UpdatedAt            time.Time `json:"updatedAt"`
+}
+
+// PinState is a BEQ entry pinned to a title, used instead of searching the next time it plays
+type PinState struct {
+	TMDB      string    `json:"tmdb"`
+	EntryID   string    `json:"entryId"`
+	Title     string    `json:"title"`
+	Author    string    `json:"author"`
+	MVAdjust  float64   `json:"mvAdjust"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
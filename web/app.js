@@ -5,12 +5,28 @@ function populateFields(config) {
     document.getElementById('ezbeq-adjustmastervolumewithprofile').checked = config.ezbeq.adjustmastervolumewithprofile;
     document.getElementById('ezbeq-enabled').checked = config.ezbeq.enabled;
     document.getElementById('ezbeq-avrip').value = config.ezbeq.avrip;
+    document.getElementById('ezbeq-denonproxyport').value = config.ezbeq.denonproxyport ?? '';
+    document.getElementById('ezbeq-avrmediasettings').value = JSON.stringify(config.ezbeq.avrmediasettings || {}, null, 2);
+    document.getElementById('ezbeq-avrcodecmappings').value = JSON.stringify(config.ezbeq.avrcodecmappings || {}, null, 2);
     document.getElementById('ezbeq-dryrun').checked = config.ezbeq.dryrun;
     document.getElementById('ezbeq-enabletvbeq').checked = config.ezbeq.enabletvbeq;
     document.getElementById('ezbeq-notifyendpointname').value = config.ezbeq.notifyendpointname;
     document.getElementById('ezbeq-notifyonload').checked = config.ezbeq.notifyonload;
     document.getElementById('ezbeq-port').value = config.ezbeq.port;
     document.getElementById('ezbeq-preferredauthor').value = config.ezbeq.preferredauthor;
+    document.getElementById('ezbeq-authorprioritybycodec').value = JSON.stringify(config.ezbeq.authorprioritybycodec || {}, null, 2);
+    document.getElementById('ezbeq-codecfallbacks').value = JSON.stringify(config.ezbeq.codecfallbacks || {}, null, 2);
+    document.getElementById('ezbeq-routes').value = JSON.stringify(config.ezbeq.routes || [], null, 2);
+    document.getElementById('ezbeq-overrides').value = JSON.stringify(config.ezbeq.overrides || [], null, 2);
+    document.getElementById('ezbeq-applymastervolume').checked = config.ezbeq.applymastervolume;
+    document.getElementById('ezbeq-mastervolumemin').value = config.ezbeq.mastervolumemin ?? '';
+    document.getElementById('ezbeq-mastervolumemax').value = config.ezbeq.mastervolumemax ?? '';
+    document.getElementById('ezbeq-uselocalcatalog').checked = config.ezbeq.uselocalcatalog;
+    document.getElementById('ezbeq-catalogrefreshhours').value = config.ezbeq.catalogrefreshhours ?? '';
+    document.getElementById('ezbeq-coveragescanhours').value = config.ezbeq.coveragescanhours ?? '';
+    document.getElementById('ezbeq-livedevicestate').checked = config.ezbeq.livedevicestate;
+    document.getElementById('ezbeq-matchthreshold').value = config.ezbeq.matchthreshold ?? '';
+    document.getElementById('ezbeq-yeartolerance').value = config.ezbeq.yeartolerance ?? '';
     const slotsArray = config.ezbeq.slots;
     slotsArray.forEach(slot => {
         document.getElementById(`slot${slot}`).checked = true;
@@ -18,6 +34,10 @@ function populateFields(config) {
     document.getElementById('ezbeq-stopplexifmismatch').checked = config.ezbeq.stopplexifmismatch;
     document.getElementById('ezbeq-url').value = config.ezbeq.url;
     document.getElementById('ezbeq-useavrcodecsearch').checked = config.ezbeq.useavrcodecsearch;
+    document.getElementById('ezbeq-avrcodecstableseconds').value = config.ezbeq.avrcodecstableseconds ?? '';
+    document.getElementById('ezbeq-avrcodectimeoutseconds').value = config.ezbeq.avrcodectimeoutseconds ?? '';
+    document.getElementById('ezbeq-avrpreflight').checked = config.ezbeq.avrpreflight;
+    document.getElementById('ezbeq-avrplayerinputs').value = JSON.stringify(config.ezbeq.avrplayerinputs || [], null, 2);
     document.getElementById('ezbeq-avrbrand').value = config.ezbeq.avrbrand;
 
 
@@ -41,8 +61,12 @@ function populateFields(config) {
     document.getElementById('mqtt-topiclights').value = config.mqtt.topiclights;
     document.getElementById('mqtt-topicvolume').value = config.mqtt.topicvolume;
     document.getElementById('mqtt-topicbeqcurrentprofile').value = config.mqtt.topicbeqcurrentprofile;
+    document.getElementById('mqtt-topicbeqerror').value = config.mqtt.topicbeqerror ?? '';
     document.getElementById('mqtt-topicminidspmutestatus').value = config.mqtt.topicminidspmutestatus;
+    document.getElementById('mqtt-topicminidspmastervolume').value = config.mqtt.topicminidspmastervolume ?? '';
     document.getElementById('mqtt-topicplayingstatus').value = config.mqtt.topicplayingstatus;
+    document.getElementById('mqtt-topicavrstate').value = config.mqtt.topicavrstate ?? '';
+    document.getElementById('mqtt-topicavailability').value = config.mqtt.topicavailability ?? '';
 
     // Plex
     document.getElementById('plex-enabled').checked = config.plex.enabled;
@@ -80,17 +104,37 @@ function buildFinalConfig() {
         "adjustmastervolumewithprofile": document.getElementById('ezbeq-adjustmastervolumewithprofile').checked,
         "enabled": document.getElementById('ezbeq-enabled').checked,
         "avrip": document.getElementById('ezbeq-avrip').value,
+        "denonproxyport": document.getElementById('ezbeq-denonproxyport').value,
+        "avrmediasettings": parseJSONField('ezbeq-avrmediasettings'),
+        "avrcodecmappings": parseJSONField('ezbeq-avrcodecmappings'),
         "dryrun": document.getElementById('ezbeq-dryrun').checked,
         "enabletvbeq": document.getElementById('ezbeq-enabletvbeq').checked,
         "notifyendpointname": document.getElementById('ezbeq-notifyendpointname').value,
         "notifyonload": document.getElementById('ezbeq-notifyonload').checked,
         "port": document.getElementById('ezbeq-port').value,
         "preferredauthor": document.getElementById('ezbeq-preferredauthor').value,
+        "authorprioritybycodec": parseJSONField('ezbeq-authorprioritybycodec'),
+        "codecfallbacks": parseJSONField('ezbeq-codecfallbacks'),
+        "routes": parseJSONField('ezbeq-routes', []),
+        "overrides": parseJSONField('ezbeq-overrides', []),
+        "applymastervolume": document.getElementById('ezbeq-applymastervolume').checked,
+        "mastervolumemin": document.getElementById('ezbeq-mastervolumemin').value,
+        "mastervolumemax": document.getElementById('ezbeq-mastervolumemax').value,
+        "uselocalcatalog": document.getElementById('ezbeq-uselocalcatalog').checked,
+        "catalogrefreshhours": document.getElementById('ezbeq-catalogrefreshhours').value,
+        "coveragescanhours": document.getElementById('ezbeq-coveragescanhours').value,
+        "livedevicestate": document.getElementById('ezbeq-livedevicestate').checked,
+        "matchthreshold": document.getElementById('ezbeq-matchthreshold').value,
+        "yeartolerance": document.getElementById('ezbeq-yeartolerance').value,
         "slots": slotsArray,
         "stopplexifmismatch": document.getElementById('ezbeq-stopplexifmismatch').checked,
         "url": document.getElementById('ezbeq-url').value,
         "avrbrand": document.getElementById('ezbeq-avrbrand').value,
-        "useavrcodecsearch": document.getElementById('ezbeq-useavrcodecsearch').checked
+        "useavrcodecsearch": document.getElementById('ezbeq-useavrcodecsearch').checked,
+        "avrcodecstableseconds": document.getElementById('ezbeq-avrcodecstableseconds').value,
+        "avrcodectimeoutseconds": document.getElementById('ezbeq-avrcodectimeoutseconds').value,
+        "avrpreflight": document.getElementById('ezbeq-avrpreflight').checked,
+        "avrplayerinputs": parseJSONField('ezbeq-avrplayerinputs', [])
     };
     const homeAssistantConfig = {
         "enabled": document.getElementById('homeassistant-enabled').checked,
@@ -113,8 +157,12 @@ function buildFinalConfig() {
         "topiclights": document.getElementById('mqtt-topiclights').value,
         "topicvolume": document.getElementById('mqtt-topicvolume').value,
         "topicbeqcurrentprofile": document.getElementById('mqtt-topicbeqcurrentprofile').value,
+        "topicbeqerror": document.getElementById('mqtt-topicbeqerror').value,
         "topicminidspmutestatus": document.getElementById('mqtt-topicminidspmutestatus').value,
-        "topicplayingstatus": document.getElementById('mqtt-topicplayingstatus').value
+        "topicminidspmastervolume": document.getElementById('mqtt-topicminidspmastervolume').value,
+        "topicplayingstatus": document.getElementById('mqtt-topicplayingstatus').value,
+        "topicavrstate": document.getElementById('mqtt-topicavrstate').value,
+        "topicavailability": document.getElementById('mqtt-topicavailability').value
     };
 
     const plexConfig = {
@@ -180,18 +228,42 @@ document.addEventListener('DOMContentLoaded', function () {
     document.getElementById('ezbeqForm').addEventListener('submit', async function (e) {
         e.preventDefault();
 
-        // We moved the logic to build the finalConfig object here
-        const finalConfig = buildFinalConfig();
-        console.log(JSON.stringify(finalConfig))
         try {
+            // We moved the logic to build the finalConfig object here
+            const finalConfig = buildFinalConfig();
+            console.log(JSON.stringify(finalConfig))
             await submitConfig(finalConfig);
             showNotification("Configuration saved successfully.");
         } catch (error) {
-            showNotification("Failed to save configuration.", false);
+            console.error(error);
+            showNotification(`Failed to save configuration. ${error.message}`, false);
         }
     });
 
+    document.getElementById('simulateButton').addEventListener('click', simulateWebhook);
+
 });
+
+// simulateWebhook sends the payload to the simulate endpoint and shows the planned steps
+async function simulateWebhook() {
+    const source = document.getElementById('simulate-source').value;
+    const result = document.getElementById('simulate-result');
+    result.textContent = "Simulating...";
+    try {
+        const response = await fetch(`/simulate/${source}`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: document.getElementById('simulate-payload').value
+        });
+        const data = await response.json();
+        result.textContent = JSON.stringify(data, null, 2);
+    } catch (error) {
+        console.error(error);
+        result.textContent = `Failed to simulate. ${error.message}`;
+    }
+}
 async function submitConfig(data) {
     try {
         const response = await fetch('/save-config', {
@@ -216,6 +288,19 @@ async function submitConfig(data) {
 
 
 
+// parseJSONField parses a textarea holding JSON, blank means empty
+function parseJSONField(id, empty = {}) {
+    const value = document.getElementById(id).value.trim();
+    if (value === "") {
+        return empty;
+    }
+    try {
+        return JSON.parse(value);
+    } catch (error) {
+        throw new Error(`Invalid JSON in ${id}: ${error.message}`);
+    }
+}
+
 function showNotification(message, isSuccess = true) {
     const notification = document.getElementById("notification");
     notification.textContent = message;